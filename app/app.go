@@ -4,8 +4,13 @@ import (
 	appbase "github.com/warptools/warpforge/app/base"
 	_ "github.com/warptools/warpforge/app/catalog"
 	_ "github.com/warptools/warpforge/app/check"
+	_ "github.com/warptools/warpforge/app/cleanup"
 	_ "github.com/warptools/warpforge/app/enter"
+	_ "github.com/warptools/warpforge/app/formula"
 	_ "github.com/warptools/warpforge/app/healthcheck"
+	_ "github.com/warptools/warpforge/app/history"
+	_ "github.com/warptools/warpforge/app/logs"
+	_ "github.com/warptools/warpforge/app/netproxy"
 	_ "github.com/warptools/warpforge/app/plan"
 	_ "github.com/warptools/warpforge/app/quickstart"
 	_ "github.com/warptools/warpforge/app/run"
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/urfave/cli/v2"
 
@@ -83,7 +84,7 @@ func CmdMiddlewareTracingConfig(f cli.ActionFunc) cli.ActionFunc {
 	}
 }
 
-// CmdMiddlewareCancelOnInterrupt will cause the function context to be canceled on receiving an os.Interrupt signal
+// CmdMiddlewareCancelOnInterrupt will cause the function context to be canceled on receiving an os.Interrupt or SIGTERM signal
 func CmdMiddlewareCancelOnInterrupt(f cli.ActionFunc) cli.ActionFunc {
 	return func(c *cli.Context) error {
 		ctx := c.Context
@@ -94,10 +95,13 @@ func CmdMiddlewareCancelOnInterrupt(f cli.ActionFunc) cli.ActionFunc {
 	}
 }
 
-// CancelOnInterrupt blocks until a os.Interrupt is received, then calls cancel.
+// CancelOnInterrupt blocks until a os.Interrupt or SIGTERM is received, then calls cancel.
+// Only the first signal is caught: another one kills the process as usual,
+// in case cleaning up after cancellation gets stuck.
 func CancelOnInterrupt(cancel context.CancelFunc) {
-	signalChan := make(chan os.Signal)
-	signal.Notify(signalChan, os.Interrupt)
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 	<-signalChan
+	signal.Stop(signalChan)
 	cancel()
 }
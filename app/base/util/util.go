@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/codec/json"
@@ -43,6 +44,39 @@ func OpenWorkspaceSet() (workspace.WorkspaceSet, error) {
 	return wss, nil
 }
 
+// StepFromArg resolves a command line argument naming a plot step, e.g. `build`, or `subplot:build` for a step of a subplot,
+// to the module and step path which its runs are recorded by (see workspace.StepRunPath).
+// Steps are of the module in the current working directory.
+// If there's no module there, an empty module name is returned.
+//
+// Errors:
+//
+//    - warpforge-error-unknown -- failed to get working directory
+//    - warpforge-error-io -- when the module file cannot be read
+//    - warpforge-error-module-invalid -- when the module data is invalid
+//    - warpforge-error-serialization -- when the module file cannot be parsed
+//    - warpforge-error-datatoonew -- when the module file is too new
+func StepFromArg(arg string) (wfapi.ModuleName, []wfapi.StepName, error) {
+	pwd, err := os.Getwd()
+	if err != nil {
+		return "", nil, serum.Errorf(wfapi.ECodeUnknown, "failed to get working directory: %w", err)
+	}
+	var moduleName wfapi.ModuleName
+	modulePath := filepath.Join(pwd, dab.MagicFilename_Module)
+	if _, err := os.Stat(modulePath); err == nil {
+		module, err := dab.ModuleFromFile(os.DirFS("/"), modulePath)
+		if err != nil {
+			return "", nil, err
+		}
+		moduleName = module.Name
+	}
+	var stepPath []wfapi.StepName
+	for _, name := range strings.Split(arg, ":") {
+		stepPath = append(stepPath, wfapi.StepName(name))
+	}
+	return moduleName, stepPath, nil
+}
+
 // DEPRECATED: use dab package
 // PlotFromFile takes a path to a plot file, returns a plot
 // Errors:
@@ -94,6 +128,7 @@ func canonicalizePath(pwd, path string) string {
 //    - warpforge-error-plot-invalid -- when the plot data is invalid
 //    - warpforge-error-plot-step-failed --
 //    - warpforge-error-serialization -- when the module or plot cannot be parsed
+//    - warpforge-error-not-hermetic -- when strict mode is enabled, and the plot is not hermetic
 //    - warpforge-error-workspace-missing -- when opening the workspace set fails
 //    - warpforge-error-datatoonew -- when error is too new
 //    - warpforge-error-searching-filesystem -- unexpected error traversing filesystem
@@ -104,10 +139,12 @@ func ExecModule(ctx context.Context, wss workspace.WorkspaceSet, pltCfg wfapi.Pl
 
 	fsys := os.DirFS("/")
 
-	// parse the module, even though it is not currently used
-	if _, err := dab.ModuleFromFile(fsys, fileName); err != nil {
+	// the module's name scopes the records of its steps
+	module, err := dab.ModuleFromFile(fsys, fileName)
+	if err != nil {
 		return result, err
 	}
+	pltCfg.Module = module.Name
 
 	moduleDir := filepath.Dir(fileName)
 	execCfg, err := config.PlotExecConfig(&moduleDir)
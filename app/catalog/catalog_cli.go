@@ -606,7 +606,7 @@ func cmdCatalogRelease(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	results, err := plotexec.Exec(ctx, execCfg, wss, wfapi.PlotCapsule{Plot: &plot}, wfapi.PlotExecConfig{Recursive: false})
+	results, err := plotexec.Exec(ctx, execCfg, wss, wfapi.PlotCapsule{Plot: &plot}, wfapi.PlotExecConfig{Recursive: false, Module: module.Name})
 	if err != nil {
 		return err
 	}
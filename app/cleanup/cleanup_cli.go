@@ -0,0 +1,61 @@
+package cleanupcli
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	appbase "github.com/warptools/warpforge/app/base"
+	"github.com/warptools/warpforge/app/base/util"
+	"github.com/warptools/warpforge/pkg/config"
+	"github.com/warptools/warpforge/pkg/formulaexec"
+)
+
+func init() {
+	appbase.App.Commands = append(appbase.App.Commands, cleanupCmdDef)
+}
+
+var cleanupCmdDef = &cli.Command{
+	Name:  "cleanup",
+	Usage: "Remove containers and run directories left behind by runs which didn't finish cleanly",
+	Description: "Runs which crashed, or were killed, can leave containers and run directories behind. " +
+		"Anything which belongs to a run that's still going is left alone. " +
+		"Run directories kept on purpose (with WARPFORGE_KEEP_RUNDIR) are removed too.",
+	Action: util.ChainCmdMiddleware(cmdCleanup,
+		util.CmdMiddlewareLogging,
+		util.CmdMiddlewareTracingConfig,
+		util.CmdMiddlewareTracingSpan,
+	),
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Only list what would be removed",
+		},
+	},
+}
+
+func cmdCleanup(c *cli.Context) error {
+	ctx := c.Context
+	wss, err := util.OpenWorkspaceSet()
+	if err != nil {
+		return err
+	}
+	execCfg, err := config.FormulaExecConfig(nil)
+	if err != nil {
+		return err
+	}
+	dryRun := c.Bool("dry-run")
+	leftovers, err := formulaexec.Cleanup(ctx, execCfg, wss.Root(), dryRun)
+	for _, l := range leftovers {
+		fmt.Fprintf(c.App.Writer, "leftover %s\n", l)
+	}
+	switch {
+	case len(leftovers) == 0:
+		fmt.Fprintln(c.App.Writer, "nothing to clean up")
+	case dryRun:
+		fmt.Fprintf(c.App.Writer, "%d leftovers found; run without --dry-run to remove them\n", len(leftovers))
+	case err == nil:
+		fmt.Fprintf(c.App.Writer, "%d leftovers removed\n", len(leftovers))
+	}
+	return err
+}
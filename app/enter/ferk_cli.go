@@ -33,6 +33,7 @@ var ferkCmdDef = &cli.Command{
 		util.CmdMiddlewareLogging,
 		util.CmdMiddlewareTracingConfig,
 		util.CmdMiddlewareTracingSpan,
+		util.CmdMiddlewareCancelOnInterrupt,
 	),
 	Flags: []cli.Flag{
 		&cli.StringFlag{
@@ -0,0 +1,158 @@
+package formulacli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/json"
+	"github.com/serum-errors/go-serum"
+	"github.com/urfave/cli/v2"
+
+	appbase "github.com/warptools/warpforge/app/base"
+	"github.com/warptools/warpforge/app/base/util"
+	"github.com/warptools/warpforge/pkg/config"
+	"github.com/warptools/warpforge/pkg/dab"
+	"github.com/warptools/warpforge/pkg/formulaexec"
+	"github.com/warptools/warpforge/pkg/plotexec"
+	"github.com/warptools/warpforge/pkg/workspace"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+func init() {
+	appbase.App.Commands = append(appbase.App.Commands, formulaCmdDef)
+}
+
+var formulaCmdDef = &cli.Command{
+	Name:  "formula",
+	Usage: "Subcommands that operate on formulas",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "export-bundle",
+			Usage:     "Export a formula as an OCI bundle, which can be run without warpforge (e.g. with runc)",
+			ArgsUsage: "[formula|module:step] [dir]",
+			Description: strings.Join([]string{
+				`[formula]: a formula file.`,
+				`[module:step]: a step of a module's plot, e.g. ".:build" for the module in the current directory. The outputs of any other steps it uses must be memoized, so run the module first.`,
+				`[dir]: where to write the bundle. It must not exist, or be empty.`,
+				`Inputs are unpacked, then copied into the bundle along with the generated config.json.`,
+			}, "\n"),
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "executor",
+					Usage:   "Select the executor which generates the container config and unpacks inputs (one of: runc, crun)",
+					EnvVars: []string{config.EnvWarpforgeExecutor},
+				},
+			},
+			Action: util.ChainCmdMiddleware(cmdExportBundle,
+				util.CmdMiddlewareLogging,
+				util.CmdMiddlewareTracingConfig,
+				util.CmdMiddlewareTracingSpan,
+				util.CmdMiddlewareCancelOnInterrupt,
+			),
+		},
+	},
+}
+
+// checkBundleDir makes sure that exporting a bundle won't clobber anything.
+//
+// Errors:
+//
+//    - warpforge-error-invalid-argument -- when the directory is not empty, or is not a directory
+func checkBundleDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return serum.Errorf(wfapi.ECodeArgument, "cannot export bundle to %q: %w", dir, err)
+	case len(entries) > 0:
+		return serum.Errorf(wfapi.ECodeArgument, "cannot export bundle to %q: directory is not empty", dir)
+	}
+	return nil
+}
+
+func cmdExportBundle(c *cli.Context) error {
+	ctx := c.Context
+	if c.Args().Len() != 2 {
+		return serum.Errorf(wfapi.ECodeArgument, "export-bundle requires exactly two arguments: a formula file (or module:step) and a directory")
+	}
+	target := c.Args().Get(0)
+	bundleDir, err := filepath.Abs(c.Args().Get(1))
+	if err != nil {
+		return serum.Errorf(wfapi.ECodeArgument, "invalid bundle directory %q: %w", c.Args().Get(1), err)
+	}
+	if err := checkBundleDir(bundleDir); err != nil {
+		return err
+	}
+	frmCfg := wfapi.FormulaExecConfig{
+		Executor: c.String("executor"),
+	}
+	if frmCfg.Executor == formulaexec.ExecutorFake {
+		return serum.Errorf(wfapi.ECodeArgument, "the fake executor can't export bundles, since it doesn't unpack anything")
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	fsys := os.DirFS("/")
+
+	// a formula file, or else a module and a step name
+	var frmAndCtx wfapi.FormulaAndContext
+	var formulaDir string
+	var root *workspace.Workspace
+	if fi, err := os.Stat(target); err == nil && !fi.IsDir() {
+		f, err := os.ReadFile(target)
+		if err != nil {
+			return wfapi.ErrorIo("unable to read formula file", target, err)
+		}
+		_, err = ipld.Unmarshal(f, json.Decode, &frmAndCtx, wfapi.TypeSystem.TypeByName("FormulaAndContext"))
+		if err != nil {
+			return wfapi.ErrorSerialization("unable to deserialize formula", err)
+		}
+		formulaDir = filepath.Dir(filepath.Join(cwd, target))
+		wss, err := workspace.FindWorkspaceStack(fsys, "", cwd[1:])
+		if err != nil {
+			return err
+		}
+		root = wss.Root()
+	} else {
+		i := strings.LastIndex(target, ":")
+		if i < 0 {
+			return serum.Errorf(wfapi.ECodeArgument, "%q is neither a formula file, nor a module:step", target)
+		}
+		moduleDir, step := filepath.Join(cwd, target[:i]), wfapi.StepName(target[i+1:])
+		if filepath.Base(moduleDir) == dab.MagicFilename_Module {
+			moduleDir = filepath.Dir(moduleDir)
+		}
+		if _, err := dab.ModuleFromFile(fsys, filepath.Join(moduleDir, dab.MagicFilename_Module)); err != nil {
+			return err
+		}
+		plot, err := dab.PlotFromFile(fsys, filepath.Join(moduleDir, dab.MagicFilename_Plot))
+		if err != nil {
+			return err
+		}
+		wss, err := workspace.FindWorkspaceStack(fsys, "", moduleDir[1:])
+		if err != nil {
+			return err
+		}
+		plotExecCfg, err := config.PlotExecConfig(&moduleDir)
+		if err != nil {
+			return err
+		}
+		frmAndCtx, err = plotexec.ResolveStep(ctx, plotExecCfg, wss, wfapi.PlotCapsule{Plot: plot}, wfapi.PlotExecConfig{FormulaExecConfig: frmCfg}, step)
+		if err != nil {
+			return err
+		}
+		formulaDir = moduleDir
+		root = wss.Root()
+	}
+
+	frmExecCfg, err := config.FormulaExecConfig(&formulaDir)
+	if err != nil {
+		return err
+	}
+	return formulaexec.ExportBundle(ctx, frmExecCfg, root, frmAndCtx, frmCfg, bundleDir)
+}
@@ -5,6 +5,7 @@ import (
 
 	appbase "github.com/warptools/warpforge/app/base"
 	"github.com/warptools/warpforge/app/base/util"
+	"github.com/warptools/warpforge/pkg/formulaexec"
 	"github.com/warptools/warpforge/pkg/healthcheck"
 	"github.com/warptools/warpforge/pkg/logging"
 )
@@ -29,13 +30,17 @@ func cmdHealth(c *cli.Context) error {
 	// Check tracing config
 	// Check for workspace stack
 	// Attempt to execute a module in a temporary workspace
+	runners := []healthcheck.Runner{
+		&healthcheck.KernelInfo{},
+		&healthcheck.BinCheck{Name: "runc"},
+		&healthcheck.BinCheck{Name: "rio"},
+	}
+	for _, name := range formulaexec.ExecutorNames() {
+		runners = append(runners, &healthcheck.ExecutorCheck{Name: name})
+	}
+	runners = append(runners, &healthcheck.ExecutionInfo{})
 	hc := &healthcheck.HealthCheck{
-		Runners: []healthcheck.Runner{
-			&healthcheck.KernelInfo{},
-			&healthcheck.BinCheck{Name: "runc"},
-			&healthcheck.BinCheck{Name: "rio"},
-			&healthcheck.ExecutionInfo{},
-		},
+		Runners: runners,
 	}
 	if err := hc.Run(c.Context); err != nil {
 		log.Info("", "health check critical error: %s", err)
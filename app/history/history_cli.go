@@ -0,0 +1,113 @@
+package historycli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/serum-errors/go-serum"
+	"github.com/urfave/cli/v2"
+
+	appbase "github.com/warptools/warpforge/app/base"
+	"github.com/warptools/warpforge/app/base/util"
+	"github.com/warptools/warpforge/pkg/workspace"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+func init() {
+	appbase.App.Commands = append(appbase.App.Commands, historyCmdDef)
+}
+
+var historyCmdDef = &cli.Command{
+	Name:        "history",
+	Usage:       "List previous runs of formulas, and find those which didn't reproduce",
+	ArgsUsage:   "[formulaID|step...]",
+	Description: "Lists every recorded run of the given formulas or plot steps (or of every formula, if none are given), oldest first.  Formulas whose successful runs produced different results are flagged, since they aren't reproducible.  Steps are of the module in the current directory; steps of subplots are named by their step path, e.g. `subplot:step`.",
+	Action: util.ChainCmdMiddleware(cmdHistory,
+		util.CmdMiddlewareLogging,
+		util.CmdMiddlewareTracingConfig,
+		util.CmdMiddlewareTracingSpan,
+	),
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "differing",
+			Usage: "Only list formulas whose runs produced different results",
+		},
+	},
+}
+
+func cmdHistory(c *cli.Context) error {
+	wss, err := util.OpenWorkspaceSet()
+	if err != nil {
+		return err
+	}
+	ws := wss.Root()
+	if ws == nil {
+		return serum.Errorf(wfapi.ECodeWorkspaceMissing, "no root workspace found")
+	}
+
+	var fids []string
+	if c.Args().Present() {
+		for _, target := range c.Args().Slice() {
+			// a step name finds the formula that step last ran, otherwise this should be a formula ID
+			module, stepPath, err := util.StepFromArg(target)
+			if err != nil {
+				return err
+			}
+			rr, err := ws.LoadStepRun(module, stepPath...)
+			if err != nil {
+				return err
+			}
+			if rr != nil {
+				target = rr.FormulaID
+			}
+			fids = append(fids, target)
+		}
+	} else {
+		fids, err = ws.HistoryFormulaIDs()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, fid := range fids {
+		runs, err := ws.LoadHistory(fid)
+		if err != nil {
+			return err
+		}
+		if len(runs) == 0 && c.Args().Present() {
+			return serum.Errorf(wfapi.ECodeMissing, "no history found for %q: it must be the ID of a formula, or the name of a plot step, which has run in this workspace", fid)
+		}
+		differ := workspace.ResultsDiffer(runs)
+		if c.Bool("differing") && !differ {
+			continue
+		}
+		printHistory(c.App.Writer, fid, runs, differ)
+	}
+	return nil
+}
+
+func printHistory(w io.Writer, fid string, runs []wfapi.RunRecord, differ bool) {
+	fmt.Fprintf(w, "formula %s: %d run(s)\n", fid, len(runs))
+	if differ {
+		fmt.Fprintf(w, "  WARNING: results differ between successful runs; this formula is not reproducible\n")
+	}
+	for _, rr := range runs {
+		results := make([]string, 0, len(rr.Results.Keys))
+		for _, name := range rr.Results.Keys {
+			result := rr.Results.Values[name]
+			switch {
+			case result.WareID != nil:
+				results = append(results, fmt.Sprintf("%s=ware:%s", name, result.WareID))
+			case result.Literal != nil:
+				results = append(results, fmt.Sprintf("%s=literal:%s", name, *result.Literal))
+			}
+		}
+		fmt.Fprintf(w, "  %s  guid=%s  exitcode=%d  %s\n",
+			time.Unix(rr.Time, 0).UTC().Format(time.RFC3339),
+			rr.Guid,
+			rr.Exitcode,
+			strings.Join(results, " "))
+	}
+}
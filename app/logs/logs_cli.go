@@ -0,0 +1,96 @@
+package logscli
+
+import (
+	"fmt"
+
+	"github.com/serum-errors/go-serum"
+	"github.com/urfave/cli/v2"
+
+	appbase "github.com/warptools/warpforge/app/base"
+	"github.com/warptools/warpforge/app/base/util"
+	"github.com/warptools/warpforge/pkg/logging"
+	"github.com/warptools/warpforge/pkg/workspace"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+func init() {
+	appbase.App.Commands = append(appbase.App.Commands, logsCmdDef)
+}
+
+var logsCmdDef = &cli.Command{
+	Name:        "logs",
+	Usage:       "Show the output of a formula's action, from a previous run",
+	ArgsUsage:   "<formulaID|step>",
+	Description: "Shows the output of the most recent run of a formula or plot step.  If it's memoized, the output of the run which made the memo is shown.  Steps are of the module in the current directory; steps of subplots are named by their step path, e.g. `subplot:step`.",
+	Action: util.ChainCmdMiddleware(cmdLogs,
+		util.CmdMiddlewareLogging,
+		util.CmdMiddlewareTracingConfig,
+		util.CmdMiddlewareTracingSpan,
+	),
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "guid",
+			Usage: "Show the output of a specific run, instead of the most recent one",
+		},
+	},
+}
+
+func cmdLogs(c *cli.Context) error {
+	logger := logging.Ctx(c.Context)
+	if c.Args().Len() != 1 {
+		return serum.Errorf(wfapi.ECodeArgument, "logs requires exactly one argument: a formula ID or step name")
+	}
+	target := c.Args().First()
+
+	wss, err := util.OpenWorkspaceSet()
+	if err != nil {
+		return err
+	}
+	ws := wss.Root()
+	if ws == nil {
+		return serum.Errorf(wfapi.ECodeWorkspaceMissing, "no root workspace found")
+	}
+
+	// a step name finds the run of that step, otherwise this should be a formula ID
+	fid, guid := target, c.String("guid")
+	module, stepPath, err := util.StepFromArg(target)
+	if err != nil {
+		return err
+	}
+	rr, err := ws.LoadStepRun(module, stepPath...)
+	if err != nil {
+		return err
+	}
+	var logRef string
+	if rr != nil {
+		logger.Debug("", "step %q last ran formula %s", workspace.StepKey(module, stepPath...), rr.FormulaID)
+		fid = rr.FormulaID
+		if guid == "" {
+			guid = rr.Guid
+			if rr.Log != nil {
+				// the run refers to its log directly
+				logRef = *rr.Log
+			}
+		}
+	}
+	if guid == "" {
+		guid, err = ws.LatestLog(fid)
+		if err != nil {
+			if serum.Code(err) == wfapi.ECodeMissing {
+				return serum.Errorf(wfapi.ECodeMissing, "no logs found for %q: it must be the ID of a formula, or the name of a plot step, which has run in this workspace", target)
+			}
+			return err
+		}
+	}
+	if logRef == "" {
+		logRef = workspace.LogRef(fid, guid)
+	}
+
+	content, err := ws.ReadLogRef(logRef)
+	if err != nil {
+		return err
+	}
+	logger.Info("", "log of formula %s, run %s:", fid, guid)
+	fmt.Fprintf(c.App.Writer, "%s", content)
+	return nil
+}
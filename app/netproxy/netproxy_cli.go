@@ -0,0 +1,31 @@
+package netproxycli
+
+import (
+	"github.com/serum-errors/go-serum"
+	"github.com/urfave/cli/v2"
+
+	appbase "github.com/warptools/warpforge/app/base"
+	"github.com/warptools/warpforge/pkg/formulaexec"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+func init() {
+	appbase.App.Commands = append(appbase.App.Commands, netproxyHookCmdDef)
+}
+
+// netproxyHookCmdDef isn't for people: it's run by the executor as a hook of containers
+// whose network is limited by a policy (see formulaexec.NetworkProxyHook).
+var netproxyHookCmdDef = &cli.Command{
+	Name:      formulaexec.NetworkProxyHookCommand,
+	Usage:     "Make the network proxy of a run reachable within its container (used internally)",
+	ArgsUsage: "<handoffSocket> <listenAddr>",
+	Hidden:    true,
+	Action:    cmdNetproxyHook,
+}
+
+func cmdNetproxyHook(c *cli.Context) error {
+	if c.Args().Len() != 2 {
+		return serum.Errorf(wfapi.ECodeArgument, "%s requires exactly two arguments: the handoff socket, and the address to listen on", formulaexec.NetworkProxyHookCommand)
+	}
+	return formulaexec.NetworkProxyHook(c.Args().Get(0), c.Args().Get(1))
+}
@@ -34,6 +34,7 @@ var runCmdDef = &cli.Command{
 		util.CmdMiddlewareLogging,
 		util.CmdMiddlewareTracingConfig,
 		util.CmdMiddlewareTracingSpan,
+		util.CmdMiddlewareCancelOnInterrupt,
 	),
 	Flags: []cli.Flag{
 		&cli.BoolFlag{
@@ -46,6 +47,27 @@ var runCmdDef = &cli.Command{
 			Aliases: []string{"f"},
 			Usage:   "Force execution, even if memoized formulas exist",
 		},
+		&cli.BoolFlag{
+			Name:  "strict",
+			Usage: "Refuse to run anything which isn't hermetic (mount, ingest, secret and cache inputs, network access, interactive stdin); every violation is reported before anything runs",
+		},
+		&cli.BoolFlag{
+			Name:  "explain",
+			Usage: "Resolve everything and print each step's formula, mounts, environment and args, without running any containers or fetching any wares",
+		},
+		&cli.BoolFlag{
+			Name:  "explain-spec",
+			Usage: "Like --explain, but also print the OCI runtime config (config.json) of each action's container",
+		},
+		&cli.BoolFlag{
+			Name:  "debug-on-failure",
+			Usage: "If an action fails, start an interactive shell in its container, with its mounts and environment",
+		},
+		&cli.StringFlag{
+			Name:    "executor",
+			Usage:   "Select the executor which runs containers (one of: runc, crun, fake)",
+			EnvVars: []string{config.EnvWarpforgeExecutor},
+		},
 	},
 }
 
@@ -156,6 +178,11 @@ func cmdRun(c *cli.Context) error {
 		Recursive: c.Bool("recursive"),
 		FormulaExecConfig: wfapi.FormulaExecConfig{
 			DisableMemoization: c.Bool("force"),
+			Executor:           c.String("executor"),
+			DebugOnFailure:     c.Bool("debug-on-failure"),
+			Strict:             c.Bool("strict"),
+			Explain:            c.Bool("explain") || c.Bool("explain-spec"),
+			ExplainSpec:        c.Bool("explain-spec"),
 		},
 	}
 
@@ -198,7 +225,13 @@ func cmdRun(c *cli.Context) error {
 				}
 
 				// run formula
-				frmCfg := wfapi.FormulaExecConfig{}
+				frmCfg := wfapi.FormulaExecConfig{
+					Executor:       c.String("executor"),
+					DebugOnFailure: c.Bool("debug-on-failure"),
+					Strict:         c.Bool("strict"),
+					Explain:        c.Bool("explain") || c.Bool("explain-spec"),
+					ExplainSpec:    c.Bool("explain-spec"),
+				}
 				wss, err := workspace.FindWorkspaceStack(os.DirFS("/"), "", cwd)
 				if err != nil {
 					return err
@@ -156,7 +156,7 @@ func cmdStatus(c *cli.Context) error {
 			}
 			if input.Basis().CatalogRef != nil {
 				catalogRefCount++
-				ware, _, err := wss.GetCatalogWare(*input.PlotInputSimple.CatalogRef)
+				ware, _, err := wss.GetCatalogWare(*input.Basis().CatalogRef)
 				if err != nil {
 					return fmt.Errorf("failed to lookup catalog ref: %s", err)
 				}
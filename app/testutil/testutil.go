@@ -122,6 +122,10 @@ func cleanOutput(str string) string {
 	matcher = regexp.MustCompile(`"time": [0-9]+`)
 	str = matcher.ReplaceAllString(str, `"time": "22222222222"`)
 
+	// replace script entry durations
+	matcher = regexp.MustCompile(`"duration": [0-9]+`)
+	str = matcher.ReplaceAllString(str, `"duration": 0`)
+
 	// replace tmp path
 	matcher = regexp.MustCompile(`/tmp/go-build.*/warpforge.test`)
 	str = matcher.ReplaceAllString(str, `warpforge`)
@@ -175,6 +179,7 @@ func buildExecFn(t *testing.T, projPath string) func([]string, io.Reader, io.Wri
 			transform.Chain(
 				NewGuidMapper(rand.NewSource(65)).Transformer(),
 				replace.RegexpString(regexp.MustCompile(`"time": [0-9]+`), `"time": 169455678`),
+				replace.RegexpString(regexp.MustCompile(`"duration": [0-9]+`), `"duration": 0`),
 			),
 		)
 
@@ -187,6 +192,7 @@ func buildExecFn(t *testing.T, projPath string) func([]string, io.Reader, io.Wri
 			transform.Chain(
 				NewGuidMapper(rand.NewSource(66)).Transformer(),
 				replace.RegexpString(regexp.MustCompile(`"time": [0-9]+`), `"time": 169455699`),
+				replace.RegexpString(regexp.MustCompile(`"duration": [0-9]+`), `"duration": 0`),
 			),
 		)
 
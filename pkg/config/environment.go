@@ -11,6 +11,17 @@ const (
 	// EnvWarpforgeWarehouse will override the warehouse used for execution
 	EnvWarpforgeWarehouse = "WARPFORGE_WAREHOUSE"
 	EnvWarpforgeDebug     = "WARPFORGE_DEBUG" // Enables debug logging
+	// EnvWarpforgeExecutor selects the executor which runs containers (e.g. "runc", "crun", or "fake")
+	EnvWarpforgeExecutor = "WARPFORGE_EXECUTOR"
+	// EnvWarpforgeRemoteWarehouse is the address of a warehouse to look for memoized outputs in,
+	// when they're missing from the local warehouse (e.g. "ca+https://example.org/warehouse")
+	EnvWarpforgeRemoteWarehouse = "WARPFORGE_REMOTE_WAREHOUSE"
+	// EnvWarpforgeUidMap and EnvWarpforgeGidMap map ids within containers to ids on the host,
+	// as comma separated "containerID:hostID:size" ranges (e.g. "0:1000:1,1:100000:65536").
+	// Both must be set for either to apply, and the executor must support user namespaces.
+	// When they apply, the ownership of files in wares is kept, which changes formula IDs (see formulaexec.ExecConfig).
+	EnvWarpforgeUidMap = "WARPFORGE_UIDMAP"
+	EnvWarpforgeGidMap = "WARPFORGE_GIDMAP"
 )
 
 // NOTE: keep this up to date or the config loader won't load them
@@ -20,4 +31,8 @@ var envKeys = []string{
 	EnvWarpforgeRunPath,
 	EnvWarpforgeWarehouse,
 	EnvWarpforgeDebug,
+	EnvWarpforgeExecutor,
+	EnvWarpforgeRemoteWarehouse,
+	EnvWarpforgeUidMap,
+	EnvWarpforgeGidMap,
 }
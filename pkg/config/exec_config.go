@@ -3,6 +3,10 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
 
 	"github.com/warptools/warpforge/pkg/formulaexec"
 	"github.com/warptools/warpforge/pkg/plotexec"
@@ -58,6 +62,86 @@ func WarehousePathOverride() *string {
 	return &value
 }
 
+// Executor returns the name of the executor to run containers with.
+// An empty string means the default executor should be used.
+func Executor() string {
+	return os.Getenv(EnvWarpforgeExecutor)
+}
+
+// RemoteWarehouse returns the address of the warehouse to check for memoized outputs
+// which are missing from the local warehouse, or nil if there isn't one.
+func RemoteWarehouse() *wfapi.WarehouseAddr {
+	value, ok := os.LookupEnv(EnvWarpforgeRemoteWarehouse)
+	if !ok || value == "" {
+		return nil
+	}
+	addr := wfapi.WarehouseAddr(value)
+	return &addr
+}
+
+// parseIdMappings parses a list of id mappings, as comma separated "containerID:hostID:size" ranges.
+//
+// Errors:
+//
+//    - warpforge-error-initialization -- when the mappings are malformed
+func parseIdMappings(env string, value string) ([]specs.LinuxIDMapping, error) {
+	var result []specs.LinuxIDMapping
+	for _, mapping := range strings.Split(value, ",") {
+		fields := strings.Split(strings.TrimSpace(mapping), ":")
+		if len(fields) != 3 {
+			return nil, serum.Error(wfapi.ECodeInitialization,
+				serum.WithMessageTemplate("Environment variable {{env}} must be comma separated \"containerID:hostID:size\" ranges: {{value|q}}"),
+				serum.WithDetail("env", env),
+				serum.WithDetail("value", value),
+			)
+		}
+		var ids [3]uint32
+		for i, field := range fields {
+			id, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, serum.Error(wfapi.ECodeInitialization,
+					serum.WithMessageTemplate("Environment variable {{env}} has an invalid id in {{mapping|q}}"),
+					serum.WithDetail("env", env),
+					serum.WithDetail("mapping", mapping),
+					serum.WithCause(err),
+				)
+			}
+			ids[i] = uint32(id)
+		}
+		result = append(result, specs.LinuxIDMapping{ContainerID: ids[0], HostID: ids[1], Size: ids[2]})
+	}
+	return result, nil
+}
+
+// IdMappings returns the uid and gid mappings for containers, or nil if there are none.
+//
+// Errors:
+//
+//    - warpforge-error-initialization -- when the mappings are malformed, or only one of them is set
+func IdMappings() (uidMappings, gidMappings []specs.LinuxIDMapping, _ error) {
+	uidValue, uidOk := os.LookupEnv(EnvWarpforgeUidMap)
+	gidValue, gidOk := os.LookupEnv(EnvWarpforgeGidMap)
+	if !uidOk && !gidOk {
+		return nil, nil, nil
+	}
+	if !uidOk || !gidOk {
+		return nil, nil, serum.Error(wfapi.ECodeInitialization,
+			serum.WithMessageTemplate("Environment variables {{uidEnv}} and {{gidEnv}} must be set together"),
+			serum.WithDetail("uidEnv", EnvWarpforgeUidMap),
+			serum.WithDetail("gidEnv", EnvWarpforgeGidMap),
+		)
+	}
+	uidMappings, err := parseIdMappings(EnvWarpforgeUidMap, uidValue)
+	if err != nil {
+		return nil, nil, err
+	}
+	gidMappings, err = parseIdMappings(EnvWarpforgeGidMap, gidValue)
+	if err != nil {
+		return nil, nil, err
+	}
+	return uidMappings, gidMappings, nil
+}
+
 // Errors:
 //
 //    - warpforge-error-initialization -- unable to get working or executable directories
@@ -99,6 +183,10 @@ func FormulaExecConfig(formulaPath *string) (cfg formulaexec.ExecConfig, _ error
 			formulaDirectory = filepath.Join(wd, *formulaPath)
 		}
 	}
+	uidMappings, gidMappings, err := IdMappings()
+	if err != nil {
+		return cfg, err
+	}
 	return formulaexec.ExecConfig{
 		BinPath:          binpath,
 		KeepRunDir:       KeepRunDir(),
@@ -106,5 +194,9 @@ func FormulaExecConfig(formulaPath *string) (cfg formulaexec.ExecConfig, _ error
 		WhPathOverride:   WarehousePathOverride(),
 		WorkingDirectory: wd,
 		FormulaDirectory: formulaDirectory,
+		Executor:         Executor(),
+		RemoteWarehouse:  RemoteWarehouse(),
+		UidMappings:      uidMappings,
+		GidMappings:      gidMappings,
 	}, nil
 }
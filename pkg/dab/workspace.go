@@ -1,6 +1,7 @@
 package dab
 
 import (
+	"fmt"
 	"io/fs"
 	"strings"
 
@@ -13,6 +14,7 @@ const (
 	MagicFilename_Workspace       = ".warpforge"
 	MagicFilename_HomeWorkspace   = ".warphome"
 	MagicFilename_MirroringConfig = "config/mirroring.json"
+	MagicFilename_Policy          = "config/policy.json"
 )
 
 // MirroringConfigFromFile loads a wfapi.MirroringConfig from filesystem path.
@@ -45,3 +47,34 @@ func MirroringConfigFromFile(fsys fs.FS, filename string) (wfapi.MirroringConfig
 
 	return *mirroringConfigCapsule.MirroringConfig, nil
 }
+
+// PolicyFromFile loads a wfapi.Policy from filesystem path.
+//
+// In typical usage, the filename parameter will have the suffix of MagicFilename_Policy.
+//
+// Errors:
+//
+// 	- warpforge-error-io -- for errors reading from fsys.
+// 	- warpforge-error-serialization -- for errors from try to parse the data as a Policy.
+func PolicyFromFile(fsys fs.FS, filename string) (wfapi.Policy, error) {
+	const situation = "loading a policy"
+	if strings.HasPrefix(filename, "/") {
+		filename = filename[1:]
+	}
+	f, err := fs.ReadFile(fsys, filename)
+	if err != nil {
+		return wfapi.Policy{}, wfapi.ErrorIo(situation, filename, err)
+	}
+
+	policyCapsule := wfapi.PolicyCapsule{}
+	_, err = ipld.Unmarshal(f, json.Decode, &policyCapsule, wfapi.TypeSystem.TypeByName("PolicyCapsule"))
+	if err != nil {
+		return wfapi.Policy{}, wfapi.ErrorSerialization(situation, err)
+	}
+
+	if policyCapsule.Policy == nil {
+		return wfapi.Policy{}, wfapi.ErrorSerialization(situation, fmt.Errorf("no policy.v1 in %q", filename))
+	}
+
+	return *policyCapsule.Policy, nil
+}
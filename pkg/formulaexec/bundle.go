@@ -0,0 +1,304 @@
+package formulaexec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/serum-errors/go-serum"
+
+	"github.com/warptools/warpforge/pkg/logging"
+	"github.com/warptools/warpforge/pkg/tracing"
+	"github.com/warptools/warpforge/pkg/workspace"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// An OCI bundle is a directory containing a container's config.json and its root filesystem.
+// Exporting a formula as a bundle lets its action be run by any OCI runtime, without warpforge:
+//
+//	<bundle>/config.json
+//	<bundle>/rootfs/             -- the formula's "/" input
+//	<bundle>/mounts/<n>_<path>/  -- the formula's other ware and overlay inputs
+//	<bundle>/script/             -- the script of a script action
+//
+// Everything is copied, so that the bundle stands alone, and running it can't change the workspace.
+// Paths in the config are relative to the bundle, so it can be moved (or handed to someone else).
+// Literal files are copied in too.
+// Bind mount inputs, and the host files used for networking, still refer to the host.
+// Secret inputs are left out entirely.
+
+// mountOption returns the value of a "key=value" option of a mount.
+func mountOption(m specs.Mount, key string) (string, bool) {
+	for _, opt := range m.Options {
+		if k, v, ok := strings.Cut(opt, "="); ok && k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// writeBundle exports the formula's action as an OCI bundle in cfg.bundlePath,
+// once its container has been fully configured.
+// Warpforge's own mounts are left out, since they're only used for packing and unpacking.
+//
+// Errors:
+//
+//    - warpforge-error-formula-invalid -- when the action is a noop, which has no process to run
+//    - warpforge-error-io -- when the bundle can't be written
+//    - warpforge-error-internal -- when copying the container config fails
+//    - warpforge-error-serialization -- when the container config can't be serialized
+func (cfg *internalConfig) writeBundle(ctx context.Context, formula wfapi.Formula, rc *runcConfig) error {
+	logger := logging.Ctx(ctx)
+	if formula.Action.Noop != nil {
+		return wfapi.ErrorFormulaInvalid("noop actions launch no process, so can't be exported as a bundle")
+	}
+	spec, err := copySpec(rc.spec)
+	if err != nil {
+		return err
+	}
+
+	rootfs := filepath.Join(cfg.bundlePath, "rootfs")
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		return wfapi.ErrorIo("failed to create bundle rootfs", rootfs, err)
+	}
+	spec.Root = &specs.Root{Path: "rootfs"}
+
+	mounts := []specs.Mount{}
+	for i, m := range spec.Mounts {
+		switch {
+		case m.Destination == containerScriptPath():
+			if err := copyTree(m.Source, filepath.Join(cfg.bundlePath, "script")); err != nil {
+				return err
+			}
+			m.Source = "script"
+		case strings.HasPrefix(m.Destination, CONTAINER_BASE_PATH):
+			continue
+		case filepath.Dir(m.Source) == rc.literalsPath():
+			rel := filepath.Join("literals", filepath.Base(m.Source))
+			if err := os.MkdirAll(filepath.Join(cfg.bundlePath, "literals"), 0755); err != nil {
+				return wfapi.ErrorIo("failed to create bundle literals dir", cfg.bundlePath, err)
+			}
+			if err := copyFile(m.Source, filepath.Join(cfg.bundlePath, rel), 0444, time.Now()); err != nil {
+				return wfapi.ErrorIo("failed to copy literal file", m.Source, err)
+			}
+			m.Source = rel
+		case m.Type == "overlay":
+			// stacked wares are flattened into one copy. overlayfs lists lowerdirs top first.
+			lowerdir, _ := mountOption(m, "lowerdir")
+			layers := strings.Split(lowerdir, ":")
+			for l, r := 0, len(layers)-1; l < r; l, r = l+1, r-1 {
+				layers[l], layers[r] = layers[r], layers[l]
+			}
+			if m.Destination == "/" {
+				if err := copyLayers(layers, rootfs); err != nil {
+					return err
+				}
+				continue
+			}
+			rel := filepath.Join("mounts", fmt.Sprintf("%02d%s", i, strings.ReplaceAll(m.Destination, "/", "_")))
+			if err := copyLayers(layers, filepath.Join(cfg.bundlePath, rel)); err != nil {
+				return err
+			}
+			m = specs.Mount{
+				Source:      rel,
+				Destination: m.Destination,
+				Type:        "none",
+				Options:     []string{"rbind"},
+			}
+		}
+		mounts = append(mounts, m)
+	}
+	spec.Mounts = mounts
+
+	// secrets aren't written into the bundle, since it may be handed on.
+	// whoever runs it has to provide them.
+	for _, secret := range rc.secrets {
+		if secret.port.SandboxVar != nil {
+			env := []string{}
+			for _, v := range spec.Process.Env {
+				if strings.Split(v, "=")[0] != string(*secret.port.SandboxVar) {
+					env = append(env, v)
+				}
+			}
+			spec.Process.Env = env
+		} else {
+			mounts := []specs.Mount{}
+			for _, m := range spec.Mounts {
+				if m.Destination != filepath.Join("/", string(*secret.port.SandboxPath)) {
+					mounts = append(mounts, m)
+				}
+			}
+			spec.Mounts = mounts
+		}
+		logger.Info(LOG_TAG, "secret input %q is not exported, and must be provided when running the bundle", PortString(secret.port))
+	}
+
+	configBytes, err := json.MarshalIndent(spec, "", "\t")
+	if err != nil {
+		return wfapi.ErrorSerialization("failed to serialize container config", err)
+	}
+	configPath := filepath.Join(cfg.bundlePath, "config.json")
+	if err := os.WriteFile(configPath, configBytes, 0644); err != nil {
+		return wfapi.ErrorIo("failed to write bundle config", configPath, err)
+	}
+	logger.Info(LOG_TAG, "exported bundle to %q; run it with: runc run -b %s <container-id>", cfg.bundlePath, cfg.bundlePath)
+	logger.Info(LOG_TAG_END, "")
+	return nil
+}
+
+// copyTree copies a directory tree, keeping modes, modification times, and symlinks.
+// Ownership isn't kept, since that would need privileges: the copy is owned by whoever made it.
+// dst may already exist.
+//
+// Errors:
+//
+//    - warpforge-error-io -- when reading or writing fails, or the tree contains special files (e.g. devices)
+func copyTree(src, dst string) error {
+	return copyLayers([]string{src}, dst)
+}
+
+// copyLayers copies several directory trees into one, as copyTree does, bottom first:
+// where more than one of them has something at the same path, the last one wins,
+// the same way it would if they were stacked in an overlay.
+//
+// Errors:
+//
+//    - warpforge-error-io -- when reading or writing fails, or a tree contains special files (e.g. devices)
+func copyLayers(srcs []string, dst string) error {
+	type dirMeta struct {
+		mode  fs.FileMode
+		mtime time.Time
+	}
+	dirs := map[string]dirMeta{}
+	keptMode := fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+	for _, src := range srcs {
+		err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(src, path)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(dst, rel)
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			// anything a lower layer left here is replaced, unless both are directories
+			if existing, err := os.Lstat(target); err == nil && !(existing.IsDir() && info.IsDir()) {
+				if err := os.RemoveAll(target); err != nil {
+					return err
+				}
+				delete(dirs, target)
+			}
+			switch {
+			case info.IsDir():
+				if err := os.MkdirAll(target, 0755); err != nil {
+					return err
+				}
+				dirs[target] = dirMeta{info.Mode() & keptMode, info.ModTime()}
+			case info.Mode()&fs.ModeSymlink != 0:
+				link, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				return os.Symlink(link, target)
+			case info.Mode().IsRegular():
+				return copyFile(path, target, info.Mode()&keptMode, info.ModTime())
+			default:
+				return fmt.Errorf("cannot copy special file %q", path)
+			}
+			return nil
+		})
+		if err != nil {
+			return wfapi.ErrorIo("failed to copy directory", src, err)
+		}
+	}
+
+	// directories get their modes last, since a read-only directory can't be copied into.
+	// deepest first, so that setting a directory's mtime isn't undone by changes to its children.
+	paths := make([]string, 0, len(dirs))
+	for path := range dirs {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i] > paths[j] })
+	for _, path := range paths {
+		if err := os.Chmod(path, dirs[path].mode); err != nil {
+			return wfapi.ErrorIo("failed to set mode of copied directory", path, err)
+		}
+		if err := os.Chtimes(path, dirs[path].mtime, dirs[path].mtime); err != nil {
+			return wfapi.ErrorIo("failed to set mtime of copied directory", path, err)
+		}
+	}
+	return nil
+}
+
+// copyFile copies a regular file, setting the mode and mtime of the copy.
+func copyFile(src, dst string, mode fs.FileMode, mtime time.Time) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(dst, mode); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, mtime, mtime)
+}
+
+// ExportBundle resolves a formula and writes its action out as an OCI bundle in the given directory,
+// instead of running it.  Inputs are unpacked (and fetched, if need be) as they would be for a run,
+// then copied into the bundle.  The bundle can be run with `runc run`, or any other OCI runtime.
+// Memoization doesn't apply, and nothing is recorded in the workspace.
+//
+// Errors:
+//
+//    - warpforge-error-executor-failed -- when generating the container config fails
+//    - warpforge-error-formula-execution-failed -- when an error occurs while exporting
+//    - warpforge-error-formula-invalid -- when an invalid formula is provided, its action is a noop, or it has a network policy
+//    - warpforge-error-serialization -- when the container config can't be serialized
+//    - warpforge-error-ware-unpack -- when a ware unpack operation fails for a formula input
+//    - warpforge-error-not-hermetic -- when strict mode is enabled, and the formula is not hermetic
+func ExportBundle(ctx context.Context, cfg ExecConfig, root *workspace.Workspace, frmCtx wfapi.FormulaAndContext, frmCfg wfapi.FormulaExecConfig, bundlePath string) (err error) {
+	ctx, span := tracing.StartFn(ctx, "ExportBundle")
+	defer func() { tracing.EndWithStatus(span, err) }()
+	frmCfg.Explain = false
+	icfg := internalConfig{
+		ExecConfig:        cfg,
+		RootWs:            root,
+		FormulaAndContext: frmCtx,
+		FormulaExecConfig: frmCfg,
+		bundlePath:        bundlePath,
+	}
+	_, err = execFormula(ctx, icfg)
+	switch serum.Code(err) {
+	case "":
+		return nil
+	case "warpforge-error-io", "warpforge-error-internal":
+		return wfapi.ErrorFormulaExecutionFailed(err)
+	default:
+		// Error Codes -= warpforge-error-io, warpforge-error-internal
+		return err
+	}
+}
@@ -0,0 +1,142 @@
+package formulaexec
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/ipld/go-ipld-prime"
+	ipldjson "github.com/ipld/go-ipld-prime/codec/json"
+	"github.com/opencontainers/runtime-spec/specs-go"
+
+	"github.com/warptools/warpforge/wfapi"
+)
+
+func TestCopyTree(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	qt.Assert(t, os.MkdirAll(filepath.Join(src, "ro"), 0755), qt.IsNil)
+	qt.Assert(t, os.WriteFile(filepath.Join(src, "file"), []byte("hello"), 0640), qt.IsNil)
+	qt.Assert(t, os.WriteFile(filepath.Join(src, "ro", "inner"), []byte("inner"), 0644), qt.IsNil)
+	qt.Assert(t, os.Symlink("file", filepath.Join(src, "link")), qt.IsNil)
+	qt.Assert(t, os.Chmod(filepath.Join(src, "ro"), 0555), qt.IsNil)
+	t.Cleanup(func() { os.Chmod(filepath.Join(src, "ro"), 0755) })
+
+	dst := filepath.Join(t.TempDir(), "dst")
+	qt.Assert(t, copyTree(src, dst), qt.IsNil)
+	t.Cleanup(func() { os.Chmod(filepath.Join(dst, "ro"), 0755) })
+
+	content, err := os.ReadFile(filepath.Join(dst, "file"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(content), qt.Equals, "hello")
+	fi, err := os.Stat(filepath.Join(dst, "file"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, fi.Mode().Perm(), qt.Equals, os.FileMode(0640))
+	content, err = os.ReadFile(filepath.Join(dst, "ro", "inner"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(content), qt.Equals, "inner")
+	fi, err = os.Stat(filepath.Join(dst, "ro"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, fi.Mode().Perm(), qt.Equals, os.FileMode(0555))
+	link, err := os.Readlink(filepath.Join(dst, "link"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, link, qt.Equals, "file")
+}
+
+func TestExportBundle(t *testing.T) {
+	serial := `{
+		"formula": {
+			"formula.v1": {
+				"inputs": {
+					"$GREETING": "literal:hello",
+					"/etc/greeting": ["hello", "world"]
+				},
+				"action": {
+					"script": {
+						"interpreter": "/bin/sh",
+						"contents": ["echo $GREETING"]
+					}
+				},
+				"outputs": {}
+			}
+		}
+	}`
+	frmAndCtx := wfapi.FormulaAndContext{}
+	_, err := ipld.Unmarshal([]byte(serial), ipldjson.Decode, &frmAndCtx, wfapi.TypeSystem.TypeByName("FormulaAndContext"))
+	qt.Assert(t, err, qt.IsNil)
+
+	wfCfg, rootWs := newTestConfig(t)
+	wfCfg.Executor = ExecutorFake
+	bundlePath := filepath.Join(t.TempDir(), "bundle")
+	err = ExportBundle(context.Background(), wfCfg, rootWs, frmAndCtx, wfapi.FormulaExecConfig{}, bundlePath)
+	qt.Assert(t, err, qt.IsNil)
+
+	configBytes, err := os.ReadFile(filepath.Join(bundlePath, "config.json"))
+	qt.Assert(t, err, qt.IsNil)
+	var spec specs.Spec
+	qt.Assert(t, json.Unmarshal(configBytes, &spec), qt.IsNil)
+	qt.Check(t, spec.Root.Path, qt.Equals, "rootfs")
+	qt.Check(t, spec.Process.Env, qt.Contains, "GREETING=hello")
+	scriptMounted := false
+	literalMounted := false
+	for _, m := range spec.Mounts {
+		if m.Destination == containerScriptPath() {
+			scriptMounted = true
+			qt.Check(t, m.Source, qt.Equals, "script")
+			continue
+		}
+		if m.Destination == "/etc/greeting" {
+			literalMounted = true
+			qt.Check(t, filepath.Dir(m.Source), qt.Equals, "literals")
+			qt.Check(t, m.Options, qt.Contains, "ro")
+			content, err := os.ReadFile(filepath.Join(bundlePath, m.Source))
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(content), qt.Equals, "hello\nworld\n")
+			continue
+		}
+		qt.Check(t, strings.HasPrefix(m.Destination, CONTAINER_BASE_PATH), qt.IsFalse, qt.Commentf("mount %q", m.Destination))
+	}
+	qt.Check(t, scriptMounted, qt.IsTrue)
+	qt.Check(t, literalMounted, qt.IsTrue)
+
+	script, err := os.ReadFile(filepath.Join(bundlePath, "script", "entry-0"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(script), qt.Equals, "echo $GREETING\n")
+	fi, err := os.Stat(filepath.Join(bundlePath, "rootfs"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, fi.IsDir(), qt.IsTrue)
+}
+
+// Later layers win, the same way they would in an overlay.
+func TestCopyLayers(t *testing.T) {
+	lower, upper := t.TempDir(), t.TempDir()
+	qt.Assert(t, os.MkdirAll(filepath.Join(lower, "ro"), 0755), qt.IsNil)
+	qt.Assert(t, os.WriteFile(filepath.Join(lower, "ro", "file"), []byte("lower"), 0444), qt.IsNil)
+	qt.Assert(t, os.WriteFile(filepath.Join(lower, "only-lower"), []byte("lower"), 0644), qt.IsNil)
+	qt.Assert(t, os.WriteFile(filepath.Join(lower, "replaced"), []byte("lower"), 0644), qt.IsNil)
+	qt.Assert(t, os.Chmod(filepath.Join(lower, "ro"), 0555), qt.IsNil)
+	t.Cleanup(func() { os.Chmod(filepath.Join(lower, "ro"), 0755) })
+	qt.Assert(t, os.MkdirAll(filepath.Join(upper, "ro"), 0755), qt.IsNil)
+	qt.Assert(t, os.WriteFile(filepath.Join(upper, "ro", "file"), []byte("upper"), 0444), qt.IsNil)
+	qt.Assert(t, os.MkdirAll(filepath.Join(upper, "replaced"), 0755), qt.IsNil)
+
+	dst := filepath.Join(t.TempDir(), "dst")
+	qt.Assert(t, copyLayers([]string{lower, upper}, dst), qt.IsNil)
+	t.Cleanup(func() { os.Chmod(filepath.Join(dst, "ro"), 0755) })
+
+	content, err := os.ReadFile(filepath.Join(dst, "ro", "file"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(content), qt.Equals, "upper")
+	content, err = os.ReadFile(filepath.Join(dst, "only-lower"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(content), qt.Equals, "lower")
+	fi, err := os.Stat(filepath.Join(dst, "replaced"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, fi.IsDir(), qt.IsTrue)
+	fi, err = os.Stat(filepath.Join(dst, "ro"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, fi.Mode().Perm(), qt.Equals, os.FileMode(0755))
+}
@@ -0,0 +1,59 @@
+package formulaexec
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+
+	"github.com/warptools/warpforge/pkg/logging"
+	"github.com/warptools/warpforge/pkg/workspace"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// Cache mounts are directories kept by the root workspace, which are mounted read-write into any action that names them,
+// so that incremental compilers and package managers (cargo, ccache, go, and so on) don't start cold every time.
+// Like secrets, they're left out when computing the formula ID, since they're meant to make things faster, not different.
+// They're not hermetic, though, since what's in them depends on whatever ran before.
+//
+// Nothing stops two actions from using the same cache mount at once;
+// the tools they're meant for already cope with that, since they're shared by concurrent builds outside of warpforge too.
+// Removing a cache mount's directory (within the workspace's "cache-mounts" directory) empties it.
+
+// addCacheMount mounts a cache mount into the container, creating it if it doesn't exist yet.
+// When explaining, nothing is created.
+//
+// Errors:
+//
+//    - warpforge-error-formula-invalid -- when the cache name is invalid, or the cache isn't mounted at a path other than "/"
+//    - warpforge-error-io -- when the cache mount's directory can't be created
+func (rc *runcConfig) addCacheMount(ctx context.Context, port wfapi.SandboxPort, name wfapi.CacheName, ws *workspace.Workspace) error {
+	logger := logging.Ctx(ctx)
+	if port.SandboxPath == nil || *port.SandboxPath == wfapi.SandboxPath("") {
+		return wfapi.ErrorFormulaInvalid(fmt.Sprintf("cache input %q must be mounted at a path other than \"/\"", PortString(port)))
+	}
+	cachePath, err := ws.CacheMountPath(name)
+	if err != nil {
+		return err
+	}
+	destPath := filepath.Join("/", string(*port.SandboxPath))
+	logger.Info(LOG_TAG,
+		"cache mount:\t%s = %s\t%s = %s",
+		color.HiBlueString("name"),
+		color.WhiteString(string(name)),
+		color.HiBlueString("destPath"),
+		color.WhiteString(destPath))
+	if !rc.explain {
+		if err := os.MkdirAll(cachePath, 0755); err != nil {
+			return wfapi.ErrorIo("failed to create cache mount", cachePath, err)
+		}
+	}
+	mnt, err := rc.makeBindPathMount(ctx, cachePath, destPath, false)
+	if err != nil {
+		return err
+	}
+	rc.spec.Mounts = append(rc.spec.Mounts, mnt)
+	return nil
+}
@@ -0,0 +1,83 @@
+package formulaexec
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/json"
+	"github.com/serum-errors/go-serum"
+
+	"github.com/warptools/warpforge/pkg/workspace"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// Cache mounts are kept by the root workspace, and left out of the formula ID.
+func TestCacheMounts(t *testing.T) {
+	ctx := context.Background()
+	wfCfg, _ := newTestConfig(t)
+	wfCfg.Executor = ExecutorFake
+	wsPath := t.TempDir()
+	qt.Assert(t, os.Mkdir(filepath.Join(wsPath, ".warpforge"), 0755), qt.IsNil)
+	rootWs, err := workspace.OpenWorkspace(os.DirFS("/"), wsPath[1:])
+	qt.Assert(t, err, qt.IsNil)
+	exec := func(inputs string, frmCfg wfapi.FormulaExecConfig) (wfapi.RunRecord, error) {
+		frmAndCtx := wfapi.FormulaAndContext{}
+		serial := `{"formula": {"formula.v1": {"inputs": ` + inputs + `, "action": {"exec": {"command": ["true"]}}, "outputs": {}}}}`
+		_, err := ipld.Unmarshal([]byte(serial), json.Decode, &frmAndCtx, wfapi.TypeSystem.TypeByName("FormulaAndContext"))
+		qt.Assert(t, err, qt.IsNil)
+		return Exec(ctx, wfCfg, rootWs, frmAndCtx, frmCfg)
+	}
+
+	t.Run("formula id", func(t *testing.T) {
+		withCache, err := exec(`{"/root/.cargo": "cache:cargo"}`, wfapi.FormulaExecConfig{})
+		qt.Assert(t, err, qt.IsNil)
+		withoutCache, err := exec(`{}`, wfapi.FormulaExecConfig{})
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, withCache.FormulaID, qt.Equals, withoutCache.FormulaID)
+
+		cachePath, err := rootWs.CacheMountPath("cargo")
+		qt.Assert(t, err, qt.IsNil)
+		fi, err := os.Stat(cachePath)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, fi.IsDir(), qt.IsTrue)
+	})
+	t.Run("strict", func(t *testing.T) {
+		_, err := exec(`{"/root/.cargo": "cache:cargo"}`, wfapi.FormulaExecConfig{Strict: true})
+		qt.Check(t, serum.Code(err), qt.Equals, wfapi.ECodeNotHermetic)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, inputs := range []string{
+			`{"$CARGO_HOME": "cache:cargo"}`,
+			`{"/": "cache:cargo"}`,
+			`{"/root/.cargo": "cache:../cargo"}`,
+		} {
+			_, err := exec(inputs, wfapi.FormulaExecConfig{})
+			qt.Check(t, serum.Code(err), qt.Equals, wfapi.ECodeFormulaInvalid, qt.Commentf("inputs %s", inputs))
+		}
+	})
+}
+
+func TestAddCacheMount(t *testing.T) {
+	wsPath := t.TempDir()
+	qt.Assert(t, os.Mkdir(filepath.Join(wsPath, ".warpforge"), 0755), qt.IsNil)
+	ws, err := workspace.OpenWorkspace(os.DirFS("/"), wsPath[1:])
+	qt.Assert(t, err, qt.IsNil)
+	pathPort := wfapi.SandboxPath("root/.cache/ccache")
+
+	rc := &runcConfig{runPath: t.TempDir()}
+	qt.Assert(t, rc.addCacheMount(context.Background(), wfapi.SandboxPort{SandboxPath: &pathPort}, "ccache", ws), qt.IsNil)
+	qt.Assert(t, rc.spec.Mounts, qt.HasLen, 1)
+	qt.Check(t, rc.spec.Mounts[0].Source, qt.Equals, filepath.Join(wsPath, ".warpforge", "cache-mounts", "ccache"))
+	qt.Check(t, rc.spec.Mounts[0].Destination, qt.Equals, "/root/.cache/ccache")
+	qt.Check(t, rc.spec.Mounts[0].Options, qt.Not(qt.Contains), "ro")
+
+	// when explaining, nothing is created
+	rc = &runcConfig{runPath: t.TempDir(), explain: true}
+	qt.Assert(t, rc.addCacheMount(context.Background(), wfapi.SandboxPort{SandboxPath: &pathPort}, "sccache", ws), qt.IsNil)
+	_, err = os.Stat(rc.spec.Mounts[0].Source)
+	qt.Check(t, os.IsNotExist(err), qt.IsTrue)
+}
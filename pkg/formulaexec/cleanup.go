@@ -0,0 +1,243 @@
+package formulaexec
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/warptools/warpforge/pkg/logging"
+	"github.com/warptools/warpforge/pkg/workspace"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// Each run of a formula gets a run directory, which is removed once the run is over,
+// and its containers are run by a runtime which keeps their state in the root workspace.
+// A run which doesn't finish cleanly -- because warpforge crashed, or was killed -- can leave these behind.
+// Run directories are locked for as long as they're in use,
+// so that leftovers can be told apart from the directories (and containers) of runs which are still going.
+
+// runPathLockName is the name of the lock file within a run directory.
+const runPathLockName = ".lock"
+
+// runPathGracePeriod is how long a run directory without a lock file is assumed to be in use,
+// since there's a moment between creating a run directory and locking it.
+const runPathGracePeriod = time.Minute
+
+// lockRunPath locks a run directory, for as long as the returned file is open.
+//
+// Errors:
+//
+//    - warpforge-error-io -- when the lock file can't be created or locked
+func lockRunPath(runPath string) (*os.File, error) {
+	lockPath := filepath.Join(runPath, runPathLockName)
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, wfapi.ErrorIo("failed to create rundir lock", lockPath, err)
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		f.Close()
+		return nil, wfapi.ErrorIo("failed to lock rundir", lockPath, err)
+	}
+	return f, nil
+}
+
+// runPathInUse returns true if a run directory belongs to a run which is still going.
+func runPathInUse(runPath string) bool {
+	f, err := os.Open(filepath.Join(runPath, runPathLockName))
+	if err != nil {
+		fi, err := os.Stat(runPath)
+		return err == nil && time.Since(fi.ModTime()) < runPathGracePeriod
+	}
+	defer f.Close()
+	return unix.Flock(int(f.Fd()), unix.LOCK_SH|unix.LOCK_NB) != nil
+}
+
+// mountsUnder lists everything mounted at or below path, deepest first, so that they can be unmounted in that order.
+//
+// Errors:
+//
+//    - warpforge-error-io -- when the mount table can't be read
+func mountsUnder(path string) ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, wfapi.ErrorIo("failed to read mount table", "/proc/self/mountinfo", err)
+	}
+	defer f.Close()
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		// the mount point is the fifth field, with whitespace escaped as octal.
+		mountPoint := unescapeMountinfo(fields[4])
+		if mountPoint == path || strings.HasPrefix(mountPoint, path+"/") {
+			mounts = append(mounts, mountPoint)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, wfapi.ErrorIo("failed to read mount table", "/proc/self/mountinfo", err)
+	}
+	sort.Slice(mounts, func(i, j int) bool { return len(mounts[i]) > len(mounts[j]) })
+	return mounts, nil
+}
+
+// unescapeMountinfo decodes the octal escapes (e.g. "\040" for a space) used in /proc/self/mountinfo.
+func unescapeMountinfo(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// removeRunPath unmounts anything still mounted within a run directory, then removes it.
+// Directories which have lost their write permission (such as the work directories of overlays) are fixed up first.
+//
+// Errors:
+//
+//    - warpforge-error-io -- when something can't be unmounted, or the directory can't be removed
+func removeRunPath(runPath string) error {
+	mounts, err := mountsUnder(runPath)
+	if err != nil {
+		return err
+	}
+	for _, m := range mounts {
+		if err := unix.Unmount(m, unix.MNT_DETACH); err != nil {
+			return wfapi.ErrorIo("failed to unmount", m, err)
+		}
+	}
+	if err := os.RemoveAll(runPath); err == nil {
+		return nil
+	}
+	filepath.WalkDir(runPath, func(path string, d fs.DirEntry, err error) error {
+		if d != nil && d.IsDir() {
+			os.Chmod(path, 0700)
+		}
+		return nil
+	})
+	if err := os.RemoveAll(runPath); err != nil {
+		return wfapi.ErrorIo("failed to remove rundir", runPath, err)
+	}
+	return nil
+}
+
+// Leftover is something which was left behind by a run that didn't finish cleanly.
+type Leftover struct {
+	Kind string // "container", or "rundir"
+	Name string // the ID of the container, or the path of the run directory
+}
+
+func (l Leftover) String() string {
+	return fmt.Sprintf("%s %s", l.Kind, l.Name)
+}
+
+// runtimeContainer is a container, as listed by "<runtime> list".
+type runtimeContainer struct {
+	Id     string `json:"id"`
+	Status string `json:"status"`
+	Bundle string `json:"bundle"`
+}
+
+// listContainers lists the containers whose state is kept in stateDir.
+//
+// Errors:
+//
+//    - warpforge-error-executor-failed -- when the runtime can't list the containers
+func (e *ociExecutor) listContainers(ctx context.Context, stateDir string) ([]runtimeContainer, error) {
+	out, err := exec.CommandContext(ctx, e.bin(), "--root", stateDir, "list", "--format", "json").Output()
+	if err != nil {
+		return nil, wfapi.ErrorExecutorFailed(e.name, fmt.Errorf("failed to list containers: %w", err))
+	}
+	var containers []runtimeContainer
+	if err := json.Unmarshal(out, &containers); err != nil {
+		return nil, wfapi.ErrorExecutorFailed(e.name, wfapi.ErrorSerialization("failed to parse container list", err))
+	}
+	return containers, nil
+}
+
+// Cleanup finds what was left behind by runs which didn't finish cleanly, and removes it:
+// containers (which are killed, if they're still running) in the root workspace,
+// and run directories in the run path base (including any which were kept on purpose, with KeepRunDir).
+// Anything which belongs to a run that's still going is left alone.
+// If dryRun is true, leftovers are only found, and nothing is removed.
+// Everything found is returned, even if removing some of it failed.
+//
+// Errors:
+//
+//    - warpforge-error-executor-failed -- when a runtime can't list or delete containers
+//    - warpforge-error-io -- when a run directory can't be removed
+func Cleanup(ctx context.Context, cfg ExecConfig, root *workspace.Workspace, dryRun bool) ([]Leftover, error) {
+	logger := logging.Ctx(ctx)
+	var leftovers []Leftover
+	var firstErr error
+	fail := func(err error) {
+		logger.Info(LOG_TAG, "%s", err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	// containers come first, since they may still be using their run directories
+	if root != nil {
+		for _, name := range []string{ExecutorRunc, ExecutorCrun} {
+			stateDir := filepath.Join("/", root.InternalPath(), name+"-root")
+			if _, err := os.Stat(stateDir); err != nil {
+				continue
+			}
+			e := &ociExecutor{name: name, binPath: cfg.BinPath}
+			containers, err := e.listContainers(ctx, stateDir)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			for _, c := range containers {
+				if !strings.HasPrefix(c.Id, "warpforge-") || runPathInUse(filepath.Dir(c.Bundle)) {
+					continue
+				}
+				leftovers = append(leftovers, Leftover{Kind: "container", Name: c.Id})
+				if dryRun {
+					continue
+				}
+				out, err := exec.CommandContext(ctx, e.bin(), "--root", stateDir, "delete", "--force", c.Id).CombinedOutput()
+				if err != nil {
+					fail(wfapi.ErrorExecutorFailed(name, fmt.Errorf("failed to delete container %q: %w: %s", c.Id, err, strings.TrimSpace(string(out)))))
+				}
+			}
+		}
+	}
+
+	runPaths, _ := filepath.Glob(filepath.Join(cfg.RunPathBase, DefaultRunPathPrefix+"*"))
+	for _, runPath := range runPaths {
+		if fi, err := os.Lstat(runPath); err != nil || !fi.IsDir() || runPathInUse(runPath) {
+			continue
+		}
+		leftovers = append(leftovers, Leftover{Kind: "rundir", Name: runPath})
+		if dryRun {
+			continue
+		}
+		if err := removeRunPath(runPath); err != nil {
+			fail(err)
+		}
+	}
+	return leftovers, firstErr
+}
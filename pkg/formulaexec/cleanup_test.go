@@ -0,0 +1,91 @@
+package formulaexec
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/json"
+
+	"github.com/warptools/warpforge/wfapi"
+)
+
+func TestRemoveRunPath(t *testing.T) {
+	runPath := filepath.Join(t.TempDir(), DefaultRunPathPrefix+"test")
+	locked := filepath.Join(runPath, "overlays", "work")
+	qt.Assert(t, os.MkdirAll(locked, 0755), qt.IsNil)
+	qt.Assert(t, os.WriteFile(filepath.Join(locked, "file"), []byte("content"), 0644), qt.IsNil)
+	qt.Assert(t, os.Chmod(locked, 0), qt.IsNil)
+
+	qt.Assert(t, removeRunPath(runPath), qt.IsNil)
+	_, err := os.Stat(runPath)
+	qt.Check(t, os.IsNotExist(err), qt.IsTrue)
+}
+
+func TestCleanup(t *testing.T) {
+	ctx := context.Background()
+	cfg := ExecConfig{RunPathBase: t.TempDir()}
+	mkRunPath := func(name string, age time.Duration) string {
+		runPath := filepath.Join(cfg.RunPathBase, name)
+		qt.Assert(t, os.Mkdir(runPath, 0755), qt.IsNil)
+		then := time.Now().Add(-age)
+		qt.Assert(t, os.Chtimes(runPath, then, then), qt.IsNil)
+		return runPath
+	}
+	crashed := mkRunPath(DefaultRunPathPrefix+"crashed", time.Hour)
+	kept := mkRunPath(DefaultRunPathPrefix+"kept", time.Hour)
+	keptLock, err := lockRunPath(kept)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, keptLock.Close(), qt.IsNil)
+	running := mkRunPath(DefaultRunPathPrefix+"running", time.Hour)
+	runningLock, err := lockRunPath(running)
+	qt.Assert(t, err, qt.IsNil)
+	defer runningLock.Close()
+	starting := mkRunPath(DefaultRunPathPrefix+"starting", 0)
+	unrelated := mkRunPath("unrelated", time.Hour)
+
+	leftovers, err := Cleanup(ctx, cfg, nil, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, leftovers, qt.DeepEquals, []Leftover{
+		{Kind: "rundir", Name: crashed},
+		{Kind: "rundir", Name: kept},
+	})
+	for _, runPath := range []string{crashed, kept, running, starting, unrelated} {
+		_, err := os.Stat(runPath)
+		qt.Check(t, err, qt.IsNil)
+	}
+
+	leftovers, err = Cleanup(ctx, cfg, nil, false)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, leftovers, qt.HasLen, 2)
+	for _, runPath := range []string{crashed, kept} {
+		_, err := os.Stat(runPath)
+		qt.Check(t, os.IsNotExist(err), qt.IsTrue)
+	}
+	for _, runPath := range []string{running, starting, unrelated} {
+		_, err := os.Stat(runPath)
+		qt.Check(t, err, qt.IsNil)
+	}
+}
+
+// A run removes its run directory when it's canceled, just as when it finishes.
+func TestExecCanceled(t *testing.T) {
+	wfCfg, rootWs := newTestConfig(t)
+	wfCfg.Executor = ExecutorFake
+	wfCfg.RunPathBase = t.TempDir()
+	frmAndCtx := wfapi.FormulaAndContext{}
+	serial := `{"formula": {"formula.v1": {"inputs": {}, "action": {"exec": {"command": ["true"]}}, "outputs": {}}}}`
+	_, err := ipld.Unmarshal([]byte(serial), json.Decode, &frmAndCtx, wfapi.TypeSystem.TypeByName("FormulaAndContext"))
+	qt.Assert(t, err, qt.IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	Exec(ctx, wfCfg, rootWs, frmAndCtx, wfapi.FormulaExecConfig{})
+	entries, err := os.ReadDir(wfCfg.RunPathBase)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, entries, qt.HasLen, 0)
+}
@@ -0,0 +1,168 @@
+package formulaexec
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/warptools/warpforge/pkg/logging"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// sourceDateEpochBase is the earliest SOURCE_DATE_EPOCH which is derived from inputs: 1980-01-01T00:00:00Z.
+// Some archive formats (zip, notably) can't represent anything earlier.
+const sourceDateEpochBase = 315532800
+
+// sourceDateEpochSpan is the range of SOURCE_DATE_EPOCH values derived from inputs (about 34 years).
+const sourceDateEpochSpan = 1 << 30
+
+// deriveSourceDateEpoch derives a SOURCE_DATE_EPOCH from the WareIDs of a formula's inputs.
+// The result only changes when the input wares do, and is always a plausible (past) date.
+// A formula with no input wares gets sourceDateEpochBase.
+func deriveSourceDateEpoch(formula wfapi.Formula) int64 {
+	var wareIds []string
+	for _, port := range formula.Inputs.Keys {
+		input := formula.Inputs.Values[port]
+		for _, layer := range input.Layers() {
+			if layer.WareID != nil {
+				wareIds = append(wareIds, layer.WareID.String())
+			}
+		}
+	}
+	if len(wareIds) == 0 {
+		return sourceDateEpochBase
+	}
+	sort.Strings(wareIds)
+	h := sha256.New()
+	for _, wareId := range wareIds {
+		fmt.Fprintln(h, wareId)
+	}
+	sum := h.Sum(nil)
+	return sourceDateEpochBase + int64(binary.BigEndian.Uint32(sum[:4])%sourceDateEpochSpan)
+}
+
+// configureDeterministic sets up a deterministic sandbox for the action's process,
+// if the action asks for one: a fixed hostname, SOURCE_DATE_EPOCH, and a time namespace.
+// As with HOME and USER, SOURCE_DATE_EPOCH is not set if an input already set it.
+//
+// Errors:
+//
+//    - warpforge-error-formula-invalid -- when the declared sourceDateEpoch is negative
+func (rc *runcConfig) configureDeterministic(formula wfapi.Formula) error {
+	deterministic := formula.Action.GetDeterministic()
+	if deterministic == nil {
+		return nil
+	}
+	epoch := deriveSourceDateEpoch(formula)
+	if deterministic.SourceDateEpoch != nil {
+		if *deterministic.SourceDateEpoch < 0 {
+			return wfapi.ErrorFormulaInvalid("deterministic sourceDateEpoch must not be negative")
+		}
+		epoch = int64(*deterministic.SourceDateEpoch)
+	}
+	rc.spec.Hostname = deterministic.GetHostname()
+	rc.setEnvDefault("SOURCE_DATE_EPOCH", strconv.FormatInt(epoch, 10))
+	rc.timeNamespace = true
+	return nil
+}
+
+// timeOffset is the offset of a clock within a time namespace.
+// It's the "timeOffsets" entry of newer OCI runtime specs, which our spec library predates.
+type timeOffset struct {
+	Secs     int64  `json:"secs"`
+	Nanosecs uint32 `json:"nanosecs"`
+}
+
+// timeNamespaceOffsets returns clock offsets which take the monotonic and boot clocks back to (almost) zero.
+// Only whole seconds are offset, so the clocks can never be taken below zero; they keep running from there.
+func timeNamespaceOffsets() (map[string]timeOffset, error) {
+	offsets := map[string]timeOffset{}
+	for name, clock := range map[string]int32{
+		"monotonic": unix.CLOCK_MONOTONIC,
+		"boottime":  unix.CLOCK_BOOTTIME,
+	} {
+		var ts unix.Timespec
+		if err := unix.ClockGettime(clock, &ts); err != nil {
+			return nil, fmt.Errorf("failed to read %s clock: %w", name, err)
+		}
+		offsets[name] = timeOffset{Secs: -int64(ts.Sec)}
+	}
+	return offsets, nil
+}
+
+// timeNamespaceSupported returns true if both the kernel and the runtime support time namespaces.
+// Runtimes which are too old to report their features are too old to support time namespaces.
+// The runtime is only asked once per executor, since its answer won't change.
+func (e *ociExecutor) timeNamespaceSupported(ctx context.Context) bool {
+	e.timeNamespaceOnce.Do(func() {
+		e.timeNamespace = e.probeTimeNamespace(ctx)
+	})
+	return e.timeNamespace
+}
+
+// probeTimeNamespace checks whether the kernel supports time namespaces,
+// and asks the runtime whether it does too.
+func (e *ociExecutor) probeTimeNamespace(ctx context.Context) bool {
+	if _, err := os.Stat("/proc/self/ns/time"); err != nil {
+		return false
+	}
+	out, err := exec.CommandContext(ctx, e.bin(), "features").Output()
+	if err != nil {
+		return false
+	}
+	var features struct {
+		Linux struct {
+			Namespaces []string `json:"namespaces"`
+		} `json:"linux"`
+	}
+	if err := json.Unmarshal(out, &features); err != nil {
+		return false
+	}
+	for _, ns := range features.Linux.Namespaces {
+		if ns == "time" {
+			return true
+		}
+	}
+	return false
+}
+
+// configJSON serializes the container config for the runtime.
+// Our spec library predates time namespaces, so when the config asks for one
+// (and one is available), it's added to the serialized config.
+func (e *ociExecutor) configJSON(ctx context.Context, rc *runcConfig) ([]byte, error) {
+	configBytes, err := json.Marshal(rc.spec)
+	if err != nil || !rc.timeNamespace {
+		return configBytes, err
+	}
+	logger := logging.Ctx(ctx)
+	if !e.timeNamespaceSupported(ctx) {
+		logger.Debug(LOG_TAG, "time namespaces are not supported by the kernel or %s, running without one", e.name)
+		return configBytes, nil
+	}
+	offsets, err := timeNamespaceOffsets()
+	if err != nil {
+		logger.Debug(LOG_TAG, "%s, running without a time namespace", err)
+		return configBytes, nil
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(configBytes, &config); err != nil {
+		return nil, err
+	}
+	linux, _ := config["linux"].(map[string]interface{})
+	if linux == nil {
+		linux = map[string]interface{}{}
+		config["linux"] = linux
+	}
+	namespaces, _ := linux["namespaces"].([]interface{})
+	linux["namespaces"] = append(namespaces, map[string]interface{}{"type": "time"})
+	linux["timeOffsets"] = offsets
+	return json.Marshal(config)
+}
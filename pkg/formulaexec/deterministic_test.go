@@ -0,0 +1,133 @@
+package formulaexec
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/json"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/serum-errors/go-serum"
+
+	"github.com/warptools/warpforge/wfapi"
+)
+
+func parseTestFormula(t *testing.T, serial string) wfapi.Formula {
+	formula := wfapi.Formula{}
+	_, err := ipld.Unmarshal([]byte(serial), json.Decode, &formula, wfapi.TypeSystem.TypeByName("Formula"))
+	qt.Assert(t, err, qt.IsNil)
+	return formula
+}
+
+func TestDeriveSourceDateEpoch(t *testing.T) {
+	one := parseTestFormula(t, `{
+		"inputs": {"/": "ware:tar:aaaaaaaa", "/src": "ware:tar:bbbbbbbb", "$X": "literal:x"},
+		"action": {"script": {"interpreter": "/bin/sh", "contents": []}},
+		"outputs": {}
+	}`)
+	reordered := parseTestFormula(t, `{
+		"inputs": {"/src": "ware:tar:bbbbbbbb", "/": "ware:tar:aaaaaaaa", "$X": "literal:y"},
+		"action": {"exec": {"command": []}},
+		"outputs": {}
+	}`)
+	other := parseTestFormula(t, `{
+		"inputs": {"/": "ware:tar:aaaaaaaa", "/src": "ware:tar:cccccccc"},
+		"action": {"exec": {"command": []}},
+		"outputs": {}
+	}`)
+	none := parseTestFormula(t, `{
+		"inputs": {"$X": "literal:x"},
+		"action": {"exec": {"command": []}},
+		"outputs": {}
+	}`)
+
+	epoch := deriveSourceDateEpoch(one)
+	qt.Check(t, epoch >= sourceDateEpochBase, qt.IsTrue)
+	qt.Check(t, epoch < sourceDateEpochBase+sourceDateEpochSpan, qt.IsTrue)
+	qt.Check(t, deriveSourceDateEpoch(reordered), qt.Equals, epoch)
+	qt.Check(t, deriveSourceDateEpoch(other), qt.Not(qt.Equals), epoch)
+	qt.Check(t, deriveSourceDateEpoch(none), qt.Equals, int64(sourceDateEpochBase))
+}
+
+func TestConfigureDeterministic(t *testing.T) {
+	newConfig := func(env ...string) *runcConfig {
+		return &runcConfig{spec: specs.Spec{Hostname: "runc", Process: &specs.Process{Env: env}}}
+	}
+	t.Run("not deterministic", func(t *testing.T) {
+		rc := newConfig()
+		err := rc.configureDeterministic(parseTestFormula(t, `{
+			"inputs": {},
+			"action": {"exec": {"command": []}},
+			"outputs": {}
+		}`))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, rc.spec.Hostname, qt.Equals, "runc")
+		qt.Check(t, rc.spec.Process.Env, qt.HasLen, 0)
+		qt.Check(t, rc.timeNamespace, qt.IsFalse)
+	})
+	t.Run("derived epoch", func(t *testing.T) {
+		rc := newConfig()
+		err := rc.configureDeterministic(parseTestFormula(t, `{
+			"inputs": {},
+			"action": {"exec": {"command": [], "deterministic": {}}},
+			"outputs": {}
+		}`))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, rc.spec.Hostname, qt.Equals, "warpforge")
+		qt.Check(t, rc.spec.Process.Env, qt.DeepEquals, []string{"SOURCE_DATE_EPOCH=315532800"})
+		qt.Check(t, rc.timeNamespace, qt.IsTrue)
+	})
+	t.Run("declared epoch and hostname", func(t *testing.T) {
+		rc := newConfig()
+		err := rc.configureDeterministic(parseTestFormula(t, `{
+			"inputs": {},
+			"action": {"script": {"interpreter": "/bin/sh", "contents": [], "deterministic": {"hostname": "builder", "sourceDateEpoch": 1700000000}}},
+			"outputs": {}
+		}`))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, rc.spec.Hostname, qt.Equals, "builder")
+		qt.Check(t, rc.spec.Process.Env, qt.DeepEquals, []string{"SOURCE_DATE_EPOCH=1700000000"})
+	})
+	t.Run("epoch set by input", func(t *testing.T) {
+		rc := newConfig("SOURCE_DATE_EPOCH=1")
+		err := rc.configureDeterministic(parseTestFormula(t, `{
+			"inputs": {},
+			"action": {"exec": {"command": [], "deterministic": {"sourceDateEpoch": 2}}},
+			"outputs": {}
+		}`))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, rc.spec.Process.Env, qt.DeepEquals, []string{"SOURCE_DATE_EPOCH=1"})
+	})
+	t.Run("negative epoch", func(t *testing.T) {
+		rc := newConfig()
+		err := rc.configureDeterministic(parseTestFormula(t, `{
+			"inputs": {},
+			"action": {"exec": {"command": [], "deterministic": {"sourceDateEpoch": -1}}},
+			"outputs": {}
+		}`))
+		qt.Check(t, serum.Code(err), qt.Equals, wfapi.ECodeFormulaInvalid)
+	})
+}
+
+func TestTimeNamespaceSupported(t *testing.T) {
+	if _, err := os.Stat("/proc/self/ns/time"); err != nil {
+		t.Skip("kernel does not support time namespaces")
+	}
+	// a runtime which records each time it's asked for its features
+	binPath := t.TempDir()
+	calls := filepath.Join(binPath, "calls")
+	script := "#!/bin/sh\necho \"$1\" >> " + calls + "\necho '{\"linux\": {\"namespaces\": [\"mount\", \"time\"]}}'\n"
+	qt.Assert(t, os.WriteFile(filepath.Join(binPath, ExecutorRunc), []byte(script), 0755), qt.IsNil)
+
+	e := &ociExecutor{name: ExecutorRunc, binPath: binPath}
+	ctx := context.Background()
+	qt.Check(t, e.timeNamespaceSupported(ctx), qt.IsTrue)
+	qt.Check(t, e.timeNamespaceSupported(ctx), qt.IsTrue)
+	called, err := os.ReadFile(calls)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, strings.Fields(string(called)), qt.DeepEquals, []string{"features"})
+}
@@ -0,0 +1,413 @@
+package formulaexec
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/serum-errors/go-serum"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/warptools/warpforge/pkg/logging"
+	"github.com/warptools/warpforge/pkg/tracing"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// Names of the available executors.
+const (
+	ExecutorRunc = "runc" // runs containers using runc.  This is the default.
+	ExecutorCrun = "crun" // runs containers using crun.
+	ExecutorFake = "fake" // runs nothing at all.  See fakeExecutor.
+)
+
+// DefaultExecutor is the executor used when none is configured.
+const DefaultExecutor = ExecutorRunc
+
+// ExecutorNames lists the names of all the available executors.
+func ExecutorNames() []string {
+	return []string{ExecutorRunc, ExecutorCrun, ExecutorFake}
+}
+
+// Executor is an engine which runs the containers used during formula execution:
+// those which unpack inputs, run the action, and pack outputs.
+// Each container is described by an OCI runtime spec, which is generated by the executor
+// and then configured for the job at hand.
+type Executor interface {
+	// Name returns the name that the executor is selected by.
+	Name() string
+
+	// Spec generates the base OCI runtime spec, from which each container's configuration starts.
+	//
+	// Errors:
+	//
+	//    - warpforge-error-executor-failed -- when generation of the base spec fails
+	//    - warpforge-error-io -- when the generated spec cannot be read
+	Spec(ctx context.Context, runPath string) (specs.Spec, error)
+
+	// Run runs a container to completion, and returns its stdout and exit code.
+	//
+	// A non-zero exit code of the process in the container is reported as an ErrorActionFailed,
+	// which is distinct from failures of the executor itself (ErrorExecutorFailed).
+	// If the config has a timeout, and the container runs for longer than that,
+	// the container is killed and an ErrorActionTimeout is returned, with an exit code of -1.
+	//
+	// Errors:
+	//
+	//    - warpforge-error-executor-failed -- invocation of the executor caused an error, or the context was canceled
+	//    - warpforge-error-action-failed -- the process in the container exited non-zero
+	//    - warpforge-error-action-timeout -- the container ran for longer than the timeout
+	//    - warpforge-error-io -- i/o error occurred during setup of the invocation
+	Run(ctx context.Context, rc *runcConfig, logWriter io.Writer) (string, int, error)
+
+	// Check reports whether the executor is usable on this host.
+	//
+	// Errors:
+	//
+	//    - warpforge-error-executor-failed -- when the executor is not usable
+	Check(ctx context.Context) error
+
+	// UserNamespaces reports whether the containers the executor runs can have their own user namespace,
+	// which ids must be mapped into for the ownership of files to be kept (see ExecConfig.UidMappings).
+	UserNamespaces() bool
+}
+
+// NewExecutor returns the executor with the given name.
+// Executors which run an external binary look for it in binPath.
+//
+// Errors:
+//
+//    - warpforge-error-invalid-argument -- when there is no executor with the given name
+func NewExecutor(name string, binPath string) (Executor, error) {
+	switch name {
+	case ExecutorRunc, ExecutorCrun:
+		return &ociExecutor{name: name, binPath: binPath}, nil
+	case ExecutorFake:
+		return &fakeExecutor{}, nil
+	default:
+		return nil, serum.Error(wfapi.ECodeArgument,
+			serum.WithMessageTemplate("unknown executor {{executor|q}} (available executors: {{available}})"),
+			serum.WithDetail("executor", name),
+			serum.WithDetail("available", strings.Join(ExecutorNames(), ", ")),
+		)
+	}
+}
+
+// ociExecutor runs containers using an OCI runtime binary with a runc-compatible CLI.
+// Both runc and crun are supported.
+type ociExecutor struct {
+	name    string // name of the executor, which is also the name of the binary
+	binPath string // path containing the binary
+
+	timeNamespaceOnce sync.Once // guards probing for time namespace support; see timeNamespaceSupported
+	timeNamespace     bool      // true if time namespaces are supported, once probed
+}
+
+func (e *ociExecutor) Name() string {
+	return e.name
+}
+
+func (e *ociExecutor) bin() string {
+	return filepath.Join(e.binPath, e.name)
+}
+
+// Spec executes "<runtime> spec" and parses the result.
+//
+// Errors:
+//
+//    - warpforge-error-executor-failed -- when generation of the base spec fails
+//    - warpforge-error-io -- when the generated spec file cannot be read
+func (e *ociExecutor) Spec(ctx context.Context, runPath string) (specs.Spec, error) {
+	var result specs.Spec
+	configFile := filepath.Join(runPath, "config.json")
+	// generate a rootless config, then read the resulting config
+	if err := os.RemoveAll(configFile); err != nil {
+		return result, wfapi.ErrorIo("failed to remove config.json", configFile, err)
+	}
+
+	args := []string{"spec", "-b", runPath}
+	if os.Getuid() != 0 {
+		args = append(args, "--rootless")
+	}
+	cmdCtx, cmdSpan := tracing.Start(ctx, e.name+" config", trace.WithAttributes(attribute.String(tracing.AttrKeyWarpforgeExecName, e.name)))
+	defer cmdSpan.End()
+	err := exec.CommandContext(cmdCtx, e.bin(), args...).Run()
+	tracing.EndWithStatus(cmdSpan, err)
+	if err != nil {
+		return result, wfapi.ErrorExecutorFailed(fmt.Sprintf("failed to generate %s config", e.name), err)
+	}
+
+	configFileBytes, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return result, wfapi.ErrorIo(fmt.Sprintf("failed to read %s config", e.name), configFile, err)
+	}
+
+	err = json.Unmarshal(configFileBytes, &result)
+	if err != nil {
+		return result, wfapi.ErrorExecutorFailed(e.name,
+			wfapi.ErrorSerialization(fmt.Sprintf("failed to parse %s config", e.name), err))
+	}
+	return result, nil
+}
+
+// Run performs an invocation of the runtime and collects results.
+//
+// The runtime is asked to write a pid file once the container process has been started,
+// so the presence of that file is used to tell failures of the action apart from failures of the runtime.
+// If the context is canceled, the container is killed and deleted before returning.
+//
+// Errors:
+//
+//    - warpforge-error-executor-failed -- invocation of the runtime caused an error, or the context was canceled
+//    - warpforge-error-action-failed -- the process in the container exited non-zero
+//    - warpforge-error-action-timeout -- the container ran for longer than the timeout
+//    - warpforge-error-io -- i/o error occurred during setup of the invocation
+func (e *ociExecutor) Run(ctx context.Context, rc *runcConfig, logWriter io.Writer) (string, int, error) {
+	ctx, span := tracing.Start(ctx, "invoke "+e.name)
+	defer span.End()
+	rc.debug(ctx)
+	configBytes, err := e.configJSON(ctx, rc)
+	if err != nil {
+		return "", 0, wfapi.ErrorExecutorFailed(e.name, wfapi.ErrorSerialization("failed to serialize container config", err))
+	}
+
+	bundlePath, err := ioutil.TempDir(rc.runPath, "bundle-")
+	if err != nil {
+		return "", 0, wfapi.ErrorIo("creating bundle tmpdir", bundlePath, err)
+	}
+	configPath := filepath.Join(bundlePath, "config.json")
+	err = ioutil.WriteFile(configPath, configBytes, 0644)
+	if err != nil {
+		return "", 0, wfapi.ErrorIo("writing config.json", configPath, err)
+	}
+	pidPath := filepath.Join(bundlePath, "pid")
+
+	containerId := fmt.Sprintf("warpforge-%d", time.Now().UTC().UnixNano())
+
+	// the runtime isn't started with the context, since killing it would leave the container behind.
+	// instead, the container is killed (and deleted) when the context is canceled.
+	_, cmdSpan := tracing.Start(ctx, "exec bundle", trace.WithAttributes(attribute.String(tracing.AttrKeyWarpforgeExecName, e.name)))
+	defer cmdSpan.End()
+	cmd := exec.Command(e.bin(),
+		"--root", rc.rootPath,
+		"run",
+		"-b", bundlePath, // bundle path
+		"--pid-file", pidPath, // written by the runtime once the container process has started
+		containerId,
+	)
+
+	// if the config has terminal enabled, and interactivity is requested,
+	// wire stdin to the contaniner
+	if rc.spec.Process.Terminal && rc.interactive {
+		cmd.Stdin = os.Stdin
+	}
+
+	// if a logWriter was provided, write output to it
+	// otherwise, capture stderr and stdout to buffers
+	var stderrBuf bytes.Buffer
+	var stdoutBuf bytes.Buffer
+	if logWriter != nil {
+		cmd.Stderr = io.MultiWriter(&stderrBuf, logWriter)
+		cmd.Stdout = io.MultiWriter(&stdoutBuf, logWriter)
+	} else {
+		cmd.Stderr = &stderrBuf
+		cmd.Stdout = &stdoutBuf
+	}
+	timedOut, canceled := false, false
+	err = cmd.Start()
+	if err == nil {
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		var timeoutC <-chan time.Time
+		if rc.timeout > 0 {
+			timer := time.NewTimer(rc.timeout)
+			defer timer.Stop()
+			timeoutC = timer.C
+		}
+		select {
+		case err = <-done:
+		case <-timeoutC:
+			timedOut = true
+			err = e.killUntilDone(ctx, rc, containerId, done)
+		case <-ctx.Done():
+			canceled = true
+			err = e.killUntilDone(withoutCancel(ctx), rc, containerId, done)
+		}
+	}
+	tracing.EndWithStatus(cmdSpan, err)
+	if canceled {
+		span.SetStatus(codes.Error, "canceled")
+		return stdoutBuf.String(), -1, wfapi.ErrorExecutorFailed(e.name, fmt.Errorf("container %q was killed: %w", containerId, ctx.Err()))
+	}
+	if timedOut {
+		span.SetStatus(codes.Error, "timeout")
+		return stdoutBuf.String(), -1, wfapi.ErrorActionTimeout(rc.timeout)
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		// `run` exits with the exit code of the container process.
+		// if the pid file exists, the process was started, and the exit code is the action's own.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			if _, statErr := os.Stat(pidPath); statErr == nil {
+				span.SetAttributes(attribute.Int(tracing.AttrKeyWarpforgeExitCode, exitErr.ExitCode()))
+				return stdoutBuf.String(), exitErr.ExitCode(), wfapi.ErrorActionFailed(exitErr.ExitCode())
+			}
+		}
+		return "", 0, wfapi.ErrorExecutorFailed(e.name, fmt.Errorf("%s %s", stdoutBuf.String(), stderrBuf.String()))
+	}
+	return stdoutBuf.String(), 0, nil
+}
+
+// killContainer kills all processes in a running container, then removes the container.
+// This is done on a best effort basis: failures are only logged,
+// since the caller is already handling the container not exiting on its own.
+//
+// Errors: none -- failures are logged and otherwise ignored
+func (e *ociExecutor) killContainer(ctx context.Context, rc *runcConfig, containerId string) {
+	logger := logging.Ctx(ctx)
+	out, err := exec.CommandContext(ctx, e.bin(), "--root", rc.rootPath, "kill", "--all", containerId, "KILL").CombinedOutput()
+	if err != nil {
+		logger.Debug(LOG_TAG, "failed to kill container %q: %s: %s", containerId, err, out)
+	}
+	out, err = exec.CommandContext(ctx, e.bin(), "--root", rc.rootPath, "delete", "--force", containerId).CombinedOutput()
+	if err != nil {
+		logger.Debug(LOG_TAG, "failed to delete container %q: %s: %s", containerId, err, out)
+	}
+}
+
+// killUntilDone kills the container, until the runtime running it exits, and returns the runtime's exit error.
+// Killing is retried, since the runtime may not have created the container yet when it's first killed.
+//
+// Errors: passed through from the runtime -- failures to kill are logged and otherwise ignored
+func (e *ociExecutor) killUntilDone(ctx context.Context, rc *runcConfig, containerId string, done <-chan error) error {
+	for {
+		e.killContainer(ctx, rc, containerId)
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// withoutCancel returns a context which keeps the logger of ctx, but is never canceled.
+// It's used for cleaning up after ctx has been canceled.
+func withoutCancel(ctx context.Context) context.Context {
+	return logging.Ctx(ctx).WithContext(context.Background())
+}
+
+// Check runs "<runtime> --version", which shows the binary is present and can be executed.
+//
+// Errors:
+//
+//    - warpforge-error-executor-failed -- when the binary cannot be run
+func (e *ociExecutor) Check(ctx context.Context) error {
+	out, err := exec.CommandContext(ctx, e.bin(), "--version").CombinedOutput()
+	if err != nil {
+		return wfapi.ErrorExecutorFailed(e.name, fmt.Errorf("%s: %w", strings.TrimSpace(string(out)), err))
+	}
+	return nil
+}
+
+// UserNamespaces reports whether the kernel supports user namespaces, which both runc and crun can use.
+func (e *ociExecutor) UserNamespaces() bool {
+	_, err := os.Stat("/proc/self/ns/user")
+	return err == nil
+}
+
+// fakeExecutor runs nothing at all, and needs no container privileges.
+// It exists so that plots and the CLI can be exercised on hosts which can't run containers.
+//
+// Unpacking inputs always succeeds, and the action always exits zero.
+// Packed outputs are given placeholder ware IDs, which are derived from the action and the packed path,
+// so that different steps produce different (but repeatable) ware IDs.
+// Nothing is ever written to the warehouse.
+// Because its results are not real, formulas run with the fake executor are never memoized.
+type fakeExecutor struct {
+	lastAction []string // args of the most recent container which was not running rio
+}
+
+func (e *fakeExecutor) Name() string {
+	return ExecutorFake
+}
+
+// Spec returns a minimal spec, since there is no runtime to generate one.
+//
+// Errors: none -- this executor has no runtime to fail
+func (e *fakeExecutor) Spec(ctx context.Context, runPath string) (specs.Spec, error) {
+	return specs.Spec{
+		Version: specs.Version,
+		Process: &specs.Process{
+			Args: []string{"sh"},
+			Env: []string{
+				"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+				"TERM=xterm",
+			},
+			Cwd: "/",
+		},
+		Root:     &specs.Root{Path: "rootfs"},
+		Hostname: "fake",
+		Linux:    &specs.Linux{},
+	}, nil
+}
+
+// Run pretends to run the container.
+// Invocations of rio are recognized, so that their output can be faked.
+//
+// Errors: none -- this executor never fails
+func (e *fakeExecutor) Run(ctx context.Context, rc *runcConfig, logWriter io.Writer) (string, int, error) {
+	logger := logging.Ctx(ctx)
+	args := rc.spec.Process.Args
+	isRio := len(args) > 2 && args[0] == filepath.Join(containerBinPath(), "rio")
+	switch {
+	case isRio && args[1] == "unpack":
+		// rio unpack reports the ware ID it unpacked, which is the second last arg.
+		return fakeRioOutput(args[len(args)-2]), 0, nil
+	case isRio && args[1] == "pack":
+		// rio pack takes the packtype and path as its last args.
+		packtype, path := args[len(args)-2], args[len(args)-1]
+		h := sha512.New384()
+		fmt.Fprintf(h, "%q\n%q\n", e.lastAction, path)
+		wareId := fmt.Sprintf("%s:fake%s", packtype, hex.EncodeToString(h.Sum(nil)))
+		return fakeRioOutput(wareId), 0, nil
+	default:
+		e.lastAction = append(append([]string{}, args...), rc.spec.Process.Env...)
+		logger.Debug(LOG_TAG, "fake executor skipped running: %q", args)
+		if logWriter != nil {
+			fmt.Fprintf(logWriter, "fake executor: not running %q\n", strings.Join(args, " "))
+		}
+		return "", 0, nil
+	}
+}
+
+func fakeRioOutput(wareId string) string {
+	out, _ := json.Marshal(RioOutput{Result: RioResult{WareId: wareId}})
+	return string(out)
+}
+
+// Check always succeeds, since the fake executor needs nothing from the host.
+//
+// Errors: none -- the fake executor is always usable
+func (e *fakeExecutor) Check(ctx context.Context) error {
+	return nil
+}
+
+// UserNamespaces is always false, since the fake executor runs no containers to map ids into.
+func (e *fakeExecutor) UserNamespaces() bool {
+	return false
+}
@@ -0,0 +1,103 @@
+package formulaexec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/ipld/go-ipld-prime"
+	ipldjson "github.com/ipld/go-ipld-prime/codec/json"
+	"github.com/opencontainers/runtime-spec/specs-go"
+
+	"github.com/warptools/warpforge/pkg/logging"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// describeMount says what a mount in the container's config is, and where its contents come from on the host.
+// Ware mounts are overlays of the ware's unpacked form in the cache; if the ware isn't in the cache yet,
+// it would be unpacked there first.
+func (rc *runcConfig) describeMount(m specs.Mount) string {
+	flags := map[string]bool{}
+	for _, opt := range m.Options {
+		flags[opt] = true
+	}
+	lowerdir, _ := mountOption(m, "lowerdir")
+	switch {
+	case m.Type == "overlay" && strings.HasPrefix(lowerdir, rc.cachePath+"/"):
+		if _, err := os.Stat(lowerdir); os.IsNotExist(err) {
+			return fmt.Sprintf("ware     %s (not yet unpacked)", lowerdir)
+		}
+		return fmt.Sprintf("ware     %s", lowerdir)
+	case m.Type == "overlay":
+		return fmt.Sprintf("overlay  %s", lowerdir)
+	case flags["bind"] || flags["rbind"]:
+		if flags["ro"] {
+			return fmt.Sprintf("bind ro  %s", m.Source)
+		}
+		return fmt.Sprintf("bind rw  %s", m.Source)
+	default:
+		return fmt.Sprintf("%-8s %s", m.Type, m.Source)
+	}
+}
+
+// explainFormula describes how a formula would be run, once its container has been fully configured:
+// the resolved formula, each mount (in the order they're made), and the action's process.
+// If the formula is memoized, that's noted, since running it would just reuse the memo.
+// Nothing is launched.
+//
+// Errors:
+//
+//    - warpforge-error-serialization -- when the formula or the container config can't be serialized
+func (cfg *internalConfig) explainFormula(ctx context.Context, formula wfapi.Formula, rc *runcConfig, memoized bool) error {
+	logger := logging.Ctx(ctx)
+
+	formulaSerial, err := ipld.Marshal(ipldjson.Encode, &formula, wfapi.TypeSystem.TypeByName("Formula"))
+	if err != nil {
+		return wfapi.ErrorSerialization("failed to serialize formula", err)
+	}
+	logger.Info(LOG_TAG, "%s (nothing will be run)", color.HiYellowString("explain"))
+	logger.Info(LOG_TAG, "formula:\n%s", strings.TrimSpace(string(formulaSerial)))
+	if memoized {
+		logger.Info(LOG_TAG, "formula is memoized: running it would reuse the memoized results")
+	}
+
+	logger.Info(LOG_TAG, "mounts:")
+	for _, m := range rc.spec.Mounts {
+		logger.Info(LOG_TAG, "\t%s\t%s",
+			color.HiBlueString(m.Destination),
+			color.WhiteString(rc.describeMount(m)))
+	}
+
+	if formula.Action.Noop != nil {
+		logger.Info(LOG_TAG, "noop action: no process is launched, outputs are gathered from the inputs")
+	} else {
+		logger.Info(LOG_TAG, "process:")
+		logger.Info(LOG_TAG, "\t%s = %s", color.HiBlueString("args"), color.WhiteString("%q", rc.spec.Process.Args))
+		logger.Info(LOG_TAG, "\t%s = %s", color.HiBlueString("cwd"), color.WhiteString(rc.spec.Process.Cwd))
+		logger.Info(LOG_TAG, "\t%s = %s", color.HiBlueString("user"),
+			color.WhiteString("%d:%d", rc.spec.Process.User.UID, rc.spec.Process.User.GID))
+		logger.Info(LOG_TAG, "\t%s = %s", color.HiBlueString("hostname"), color.WhiteString(rc.spec.Hostname))
+		logger.Info(LOG_TAG, "\t%s:", color.HiBlueString("env"))
+		for _, kv := range rc.spec.Process.Env {
+			logger.Info(LOG_TAG, "\t\t%s", rc.redact(kv))
+		}
+	}
+
+	if cfg.FormulaExecConfig.ExplainSpec {
+		spec, err := json.Marshal(rc.redactedSpec())
+		if err != nil {
+			return wfapi.ErrorSerialization("failed to serialize container config", err)
+		}
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, spec, "", "\t"); err != nil {
+			return wfapi.ErrorSerialization("failed to format container config", err)
+		}
+		logger.Info(LOG_TAG, "config.json:\n%s", buf.String())
+	}
+	logger.Info(LOG_TAG_END, "")
+	return nil
+}
@@ -1,16 +1,18 @@
 package formulaexec
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/fs"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,8 +28,6 @@ import (
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/serum-errors/go-serum"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/codes"
-	"go.opentelemetry.io/otel/trace"
 
 	"github.com/warptools/warpforge/pkg/logging"
 	"github.com/warptools/warpforge/pkg/tracing"
@@ -44,34 +44,43 @@ const (
 	LOG_TAG_END          = "│ └─ formula"
 )
 
-type RioResult struct {
-	WareId string `json:"wareID"`
-}
-type RioOutput struct {
-	Result RioResult `json:"result"`
-}
-
 // DefaultRunPathPrefix will be the prefix used to create a temporary execution directory
 const DefaultRunPathPrefix = "warpforge-run-"
 
-// runConfig is the minimal set of things to execute invokeRunc
+// runcConfig is the minimal set of things to run a container with an Executor.
+// (It's named for runc, the original executor, but is used by all of them.)
 type runcConfig struct {
-	binPath     string     // path containing required binaries to run (rio, runc)
-	interactive bool       // flag to determine if stdin should be wired to containier for interactivity
-	rootPath    string     // rootPath is the root directory for storage of container state
-	runPath     string     // path used to store temporary files used for formula run
-	spec        specs.Spec // OCI config spec
-	cachePath   string     // directory where wares will be cached
+	executor      Executor      // runs the container
+	binPath       string        // path containing required binaries to run (rio, runc)
+	interactive   bool          // flag to determine if stdin should be wired to containier for interactivity
+	rootPath      string        // rootPath is the root directory for storage of container state
+	runPath       string        // path used to store temporary files used for formula run
+	spec          specs.Spec    // OCI config spec
+	cachePath     string        // directory where wares will be cached
+	warehousePath string        // host path of the local warehouse, which is mounted into the container
+	timeout       time.Duration // if non-zero, the container is killed if it runs for longer than this
+
+	keepOwnership bool // if true, ids are mapped into the container, so the ownership of files is kept when packing and unpacking
+	timeNamespace bool // if true, the container gets its own time namespace, if the kernel and runtime support it
+	explain       bool // if true, nothing is run or fetched: wares are assumed to be unpacked where they would be
+
+	secrets []secretInput // secret inputs given to the container, whose values are redacted wherever they'd be shown
 }
 
 func (rc runcConfig) debug(ctx context.Context) {
 	logger := logging.Ctx(ctx)
+	logger.Debug(LOG_TAG+" runc-config", "executor: %s", rc.executor.Name())
 	logger.Debug(LOG_TAG+" runc-config", "binpath: %s", rc.binPath)
 	logger.Debug(LOG_TAG+" runc-config", "interactive: %t", rc.interactive)
 	logger.Debug(LOG_TAG+" runc-config", "rootPath: %s", rc.rootPath)
 	logger.Debug(LOG_TAG+" runc-config", "runPath: %s", rc.runPath)
 	logger.Debug(LOG_TAG+" runc-config", "cachePath: %s", rc.cachePath)
-	spec, _ := json.Marshal(rc.spec)
+	logger.Debug(LOG_TAG+" runc-config", "warehousePath: %s", rc.warehousePath)
+	logger.Debug(LOG_TAG+" runc-config", "timeout: %s", rc.timeout)
+	logger.Debug(LOG_TAG+" runc-config", "keepOwnership: %t", rc.keepOwnership)
+	logger.Debug(LOG_TAG+" runc-config", "timeNamespace: %t", rc.timeNamespace)
+	logger.Debug(LOG_TAG+" runc-config", "explain: %t", rc.explain)
+	spec, _ := json.Marshal(rc.redactedSpec())
 	logger.Debug(LOG_TAG+" runc-config", "spec: %s", string(spec))
 }
 
@@ -92,6 +101,27 @@ type ExecConfig struct {
 	// FormulaDirectory is the location of the formula (or module) being run
 	// Relative mount paths are relative to this path
 	FormulaDirectory string
+	// Executor is the name of the executor which runs containers.
+	// If empty, DefaultExecutor is used.
+	// This may be overridden for a single execution by wfapi.FormulaExecConfig.
+	Executor string
+	// RemoteWarehouse is an additional warehouse which memoized outputs may be found in,
+	// if they're no longer in the local warehouse.  Optional.
+	RemoteWarehouse *wfapi.WarehouseAddr
+	// UidMappings and GidMappings map ids within containers to ids on the host.
+	// If neither is set, the executor's default mapping is used (which, when rootless, only maps root),
+	// and all files in wares are packed and unpacked as owned by root.
+	// If both are set, the real ownership of files is kept instead, so that the ids used must be mapped.
+	// Mappings only apply if the executor supports user namespaces, and are ignored otherwise.
+	// Since keeping ownership changes what a formula produces, it's part of the formula ID (see formulaForID).
+	UidMappings []specs.LinuxIDMapping
+	GidMappings []specs.LinuxIDMapping
+}
+
+// keepOwnership reports whether the real ownership of files is kept when packing and unpacking wares,
+// which is when ids are mapped into containers, and the executor supports user namespaces.
+func (cfg *internalConfig) keepOwnership() bool {
+	return len(cfg.UidMappings) > 0 && len(cfg.GidMappings) > 0 && cfg.executor.UserNamespaces()
 }
 
 func (cfg *ExecConfig) debug(ctx context.Context) {
@@ -100,6 +130,10 @@ func (cfg *ExecConfig) debug(ctx context.Context) {
 	logger.Debug(LOG_TAG, "run path base: %q", cfg.RunPathBase)
 	logger.Debug(LOG_TAG, "keep run dir: %t", cfg.KeepRunDir)
 	logger.Debug(LOG_TAG, "warehouse override path: %v", cfg.WhPathOverride)
+	logger.Debug(LOG_TAG, "executor: %q", cfg.Executor)
+	logger.Debug(LOG_TAG, "remote warehouse: %v", cfg.RemoteWarehouse)
+	logger.Debug(LOG_TAG, "uid mappings: %v", cfg.UidMappings)
+	logger.Debug(LOG_TAG, "gid mappings: %v", cfg.GidMappings)
 }
 
 type internalConfig struct {
@@ -107,21 +141,54 @@ type internalConfig struct {
 	RootWs *workspace.Workspace
 	wfapi.FormulaExecConfig
 	wfapi.FormulaAndContext
+	executor   Executor // set up at the start of execution, from the executor names in the configs
+	bundlePath string   // if set, the formula is exported as an OCI bundle here instead of being run (see ExportBundle)
+}
+
+// executorName returns the name of the executor to use,
+// preferring the per-execution config over the general one.
+func (cfg *internalConfig) executorName() string {
+	switch {
+	case cfg.FormulaExecConfig.Executor != "":
+		return cfg.FormulaExecConfig.Executor
+	case cfg.ExecConfig.Executor != "":
+		return cfg.ExecConfig.Executor
+	default:
+		return DefaultExecutor
+	}
 }
 
 func (cfg *internalConfig) loadMemo(ctx context.Context, fid string) (*wfapi.RunRecord, error) {
-	// check if a memoized RunRecord already exists
-	if !cfg.FormulaExecConfig.DisableMemoization && cfg.RootWs != nil {
+	// check if a memoized RunRecord already exists.
+	// the fake executor never uses memos, since it only pretends to run anything.
+	if !cfg.FormulaExecConfig.DisableMemoization && cfg.RootWs != nil && cfg.executor.Name() != ExecutorFake {
 		memo, err := cfg.RootWs.LoadMemo(fid)
 		if err != nil {
 			return nil, err
 		}
+		if memo == nil {
+			return nil, nil
+		}
+		// a memo is only useful if its results can still be had.
+		// if any are gone, the formula is run again, and the new memo replaces this one.
+		if missing := cfg.missingMemoResults(ctx, *memo); len(missing) > 0 {
+			logger := logging.Ctx(ctx)
+			for _, wareId := range missing {
+				logger.Info(LOG_TAG, "memo is stale: output ware %q is missing from the warehouse", wareId)
+			}
+			logger.Info(LOG_TAG, "ignoring stale memo of formula %s, and running it again", fid)
+			return nil, nil
+		}
 		return memo, nil
 	}
 	return nil, nil
 }
 
 func (cfg *internalConfig) storeMemo(ctx context.Context, rr wfapi.RunRecord) error {
+	if cfg.executor.Name() == ExecutorFake {
+		// the results of the fake executor are not real, and must never be reused.
+		return nil
+	}
 	if cfg.RootWs != nil {
 		if err := cfg.RootWs.StoreMemo(rr); err != nil {
 			return err
@@ -133,6 +200,44 @@ func (cfg *internalConfig) storeMemo(ctx context.Context, rr wfapi.RunRecord) er
 	return nil
 }
 
+// recordHistory appends a RunRecord to the history of its formula, whether the run succeeded or not.
+//
+// Errors:
+//
+//    - warpforge-error-io -- when the history can't be written
+//    - warpforge-error-serialization -- when the RunRecord can't be serialized
+func (cfg *internalConfig) recordHistory(ctx context.Context, rr wfapi.RunRecord) error {
+	if cfg.executor.Name() == ExecutorFake {
+		// the fake executor's runs aren't real, and would only muddy the history.
+		return nil
+	}
+	if cfg.RootWs == nil {
+		logger := logging.Ctx(ctx)
+		logger.Info("", "unable to store history of run record")
+		return nil
+	}
+	return cfg.RootWs.AppendHistory(rr)
+}
+
+// createLog creates the file which the output of the formula's action is kept in.
+// If there's nowhere to keep it, nil is returned.
+//
+// Errors:
+//
+//    - warpforge-error-io -- when the log file can't be created
+func (cfg *internalConfig) createLog(ctx context.Context, rr wfapi.RunRecord) (*os.File, error) {
+	if cfg.executor.Name() == ExecutorFake {
+		// like its results, the output of the fake executor isn't real.
+		return nil, nil
+	}
+	if cfg.RootWs == nil {
+		logger := logging.Ctx(ctx)
+		logger.Info("", "unable to store log of action output")
+		return nil, nil
+	}
+	return cfg.RootWs.CreateLog(rr.FormulaID, rr.Guid)
+}
+
 func (cfg *ExecConfig) warehousePathOverride() (string, bool) {
 	if cfg.WhPathOverride == nil {
 		return "", false
@@ -161,9 +266,19 @@ func containerWarehousePath() string {
 	return filepath.Join(containerWorkspacePath(), "warehouse")
 }
 
+// cacheDirName returns the name of the directory within the workspace which wares are unpacked into.
+// Wares unpacked with their real ownership kept are cached apart from those whose ownership was normalized to root,
+// since the same ware ID can be unpacked either way.
+func cacheDirName(keepOwnership bool) string {
+	if keepOwnership {
+		return "cache-keep-ownership"
+	}
+	return "cache"
+}
+
 // rio cache directory location within the container
-func containerCachePath() string {
-	return filepath.Join(containerWorkspacePath(), "cache")
+func containerCachePath(keepOwnership bool) string {
+	return filepath.Join(containerWorkspacePath(), cacheDirName(keepOwnership))
 }
 
 // base directory for `script` Action files within the container
@@ -171,6 +286,121 @@ func containerScriptPath() string {
 	return filepath.Join(CONTAINER_BASE_PATH, "script")
 }
 
+// directory within the container which gathered variables are written to by `script` Actions
+func containerVarsPath() string {
+	return filepath.Join(containerScriptPath(), "vars")
+}
+
+// reShellVar matches names which are safe to use as shell variables.
+var reShellVar = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// gatherVars returns the variables which the formula gathers as outputs, in output order.
+// Gathering variables is only supported for script actions, since only the script
+// interpreter still has the variables available once the user's commands have finished.
+//
+// Errors:
+//
+//    - warpforge-error-formula-invalid -- when a SandboxVar gather directive cannot be satisfied
+func gatherVars(formula wfapi.Formula) ([]wfapi.SandboxVar, error) {
+	vars := []wfapi.SandboxVar{}
+	for _, name := range formula.Outputs.Keys {
+		gather := formula.Outputs.Values[name]
+		if gather.From.SandboxVar == nil {
+			continue
+		}
+		v := *gather.From.SandboxVar
+		switch {
+		case formula.Action.Script == nil:
+			return nil, wfapi.ErrorFormulaInvalid(fmt.Sprintf("output %q gathers variable %q, which is only supported by script actions", name, v))
+		case gather.Packtype != nil || gather.Filters != nil:
+			return nil, wfapi.ErrorFormulaInvalid(fmt.Sprintf("output %q gathers variable %q, and must not have a packtype or filters", name, v))
+		case !reShellVar.MatchString(string(v)):
+			return nil, wfapi.ErrorFormulaInvalid(fmt.Sprintf("output %q gathers variable %q, which is not a valid variable name", name, v))
+		}
+		vars = append(vars, v)
+	}
+	return vars, nil
+}
+
+func isKeepOrId(v string) bool {
+	if v == "keep" {
+		return true
+	}
+	id, err := strconv.Atoi(v)
+	return err == nil && id >= 0
+}
+
+func isKeepOrTime(v string) bool {
+	if v == "keep" {
+		return true
+	}
+	if strings.HasPrefix(v, "@") {
+		_, err := strconv.ParseInt(v[1:], 10, 64)
+		return err == nil
+	}
+	_, err := time.Parse(time.RFC3339, v)
+	return err == nil
+}
+
+// checkGatherPaths checks the gather directives of the outputs which pack a path.
+// These must name a packtype which can be packed (see packFilters),
+// and may only use filters which apply when packing that packtype.
+//
+// Errors:
+//
+//    - warpforge-error-formula-invalid -- when a SandboxPath gather directive is invalid
+func checkGatherPaths(formula wfapi.Formula) error {
+	for _, name := range formula.Outputs.Keys {
+		gather := formula.Outputs.Values[name]
+		if gather.From.SandboxPath == nil {
+			continue
+		}
+		path := "/" + string(*gather.From.SandboxPath)
+		switch {
+		case gather.Packtype == nil:
+			return wfapi.ErrorFormulaInvalid(fmt.Sprintf("output %q gathers path %q, and must have a packtype", name, path))
+		case packFilters[*gather.Packtype] == nil:
+			return wfapi.ErrorFormulaInvalid(fmt.Sprintf("output %q has packtype %q, which outputs can't be packed as (supported: %s)",
+				name, *gather.Packtype, strings.Join(packablePacktypes(), ", ")))
+		}
+		if gather.Filters == nil {
+			continue
+		}
+		filters := packFilters[*gather.Packtype]
+		for k, v := range gather.Filters.Values {
+			valid, ok := filters[k]
+			if !ok {
+				return wfapi.ErrorFormulaInvalid(fmt.Sprintf("output %q has filter %q, which doesn't apply to packtype %q", name, k, *gather.Packtype))
+			}
+			if !valid(v) {
+				return wfapi.ErrorFormulaInvalid(fmt.Sprintf("output %q has invalid value %q for filter %q", name, v, k))
+			}
+		}
+	}
+	return nil
+}
+
+// readGatheredVar reads the value of a variable which was written out by a script action.
+//
+// Errors:
+//
+//    - warpforge-error-missing -- when the variable was not set when the script finished
+//    - warpforge-error-io -- when the variable's file cannot be read
+func readGatheredVar(scriptPath string, v wfapi.SandboxVar) (wfapi.Literal, error) {
+	path := filepath.Join(scriptPath, "vars", string(v))
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", serum.Error(wfapi.ECodeMissing,
+			serum.WithMessageTemplate("variable {{var|q}} was gathered as an output, but was not set by the action"),
+			serum.WithDetail("var", string(v)),
+		)
+	}
+	if err != nil {
+		return "", wfapi.ErrorIo("failed to read gathered variable", path, err)
+	}
+	return wfapi.Literal(content), nil
+}
+
 func getMountDirSymlinks(start string) []string {
 	//FIXME: This function is not implemented in an easily testable way.
 	paths := []string{}
@@ -302,52 +532,6 @@ func getBinMounts(ctx context.Context, binPath string) []specs.Mount {
 	return result
 }
 
-// newRuncSpec executes "runc spec" for the given parameters and parses the result
-//
-// Errors:
-//
-//    - warpforge-error-executor-failed -- when generation of the base spec by runc fails
-//    - warpforge-error-io  -- when the generated spec file cannot be read
-func newRuncSpec(ctx context.Context, runPath string, binPath string) (specs.Spec, error) {
-	var result specs.Spec
-	configFile := filepath.Join(runPath, "config.json")
-	// generate a runc rootless config, then read the resulting config
-	if err := os.RemoveAll(configFile); err != nil {
-		return result, wfapi.ErrorIo("failed to remove config.json", configFile, err)
-	}
-
-	var cmd *exec.Cmd
-	cmdCtx, cmdSpan := tracing.Start(ctx, "runc config", trace.WithAttributes(tracing.AttrFullExecNameRunc))
-	defer cmdSpan.End()
-	if os.Getuid() == 0 {
-		cmd = exec.CommandContext(cmdCtx, filepath.Join(binPath, "runc"),
-			"spec",
-			"-b", runPath)
-	} else {
-		cmd = exec.CommandContext(cmdCtx, filepath.Join(binPath, "runc"),
-			"spec",
-			"--rootless",
-			"-b", runPath)
-	}
-	err := cmd.Run()
-	tracing.EndWithStatus(cmdSpan, err)
-	if err != nil {
-		return result, wfapi.ErrorExecutorFailed("failed to generate runc config", err)
-	}
-
-	configFileBytes, err := ioutil.ReadFile(configFile)
-	if err != nil {
-		return result, wfapi.ErrorIo("failed to read runc config", configFile, err)
-	}
-
-	err = json.Unmarshal(configFileBytes, &result)
-	if err != nil {
-		return result, wfapi.ErrorExecutorFailed("runc",
-			wfapi.ErrorSerialization("failed to parse runc config", err))
-	}
-	return result, nil
-}
-
 // copySpec returns a copy of the spec
 // Internally, copySpec serializes and deserializes the data
 // Presumably this can round trip, but an error is returned just in case.
@@ -381,11 +565,14 @@ func copySpec(spec specs.Spec) (specs.Spec, error) {
 func (cfg internalConfig) newRuncConfig(ctx context.Context, runPath string, baseSpec specs.Spec) (runcConfig, error) {
 	rootWsIntPath := "/" + cfg.RootWs.InternalPath()
 	rc := runcConfig{
-		binPath:     cfg.ExecConfig.BinPath,
-		runPath:     runPath,
-		rootPath:    filepath.Join(rootWsIntPath, "runc-root"),
-		cachePath:   filepath.Join(rootWsIntPath, "cache"),
-		interactive: false,
+		executor:      cfg.executor,
+		binPath:       cfg.ExecConfig.BinPath,
+		runPath:       runPath,
+		rootPath:      filepath.Join(rootWsIntPath, cfg.executor.Name()+"-root"),
+		cachePath:     filepath.Join(rootWsIntPath, cacheDirName(cfg.keepOwnership())),
+		warehousePath: cfg.localWarehousePath(),
+		interactive:   false,
+		explain:       cfg.FormulaExecConfig.Explain,
 	}
 	_spec, err := copySpec(baseSpec)
 	if err != nil {
@@ -451,134 +638,190 @@ func (cfg internalConfig) newRuncConfig(ctx context.Context, runPath string, bas
 	}
 	rc.spec.Linux.Namespaces = newNamespaces
 
+	// if ids are mapped, replace the executor's default mapping.
+	// a user namespace is needed for the mapping to apply (the rootless spec already has one).
+	if cfg.keepOwnership() {
+		rc.spec.Linux.UIDMappings = cfg.UidMappings
+		rc.spec.Linux.GIDMappings = cfg.GidMappings
+		hasUserNs := false
+		for _, ns := range rc.spec.Linux.Namespaces {
+			if ns.Type == specs.UserNamespace {
+				hasUserNs = true
+			}
+		}
+		if !hasUserNs {
+			rc.spec.Linux.Namespaces = append(rc.spec.Linux.Namespaces, specs.LinuxNamespace{Type: specs.UserNamespace})
+		}
+		rc.keepOwnership = true
+	}
+
 	return rc, nil
 }
 
+// actionReadableMode returns the permissions for a file (or directory, if dir is true)
+// which warpforge creates on the host for the action to read.
+// The action may run as a user other than root (see configureProcess), who still needs to be able to read it.
+func actionReadableMode(dir bool) os.FileMode {
+	if dir {
+		return 0555
+	}
+	return 0444
+}
+
+// actionWritableMode returns the permissions for a file (or directory, if dir is true)
+// which warpforge creates on the host for the action to write to.
+// Like actionReadableMode, these allow for the action running as a user other than root.
+func actionWritableMode(dir bool) os.FileMode {
+	if dir {
+		return 0777
+	}
+	return 0666
+}
+
+// configureProcess sets the working directory and user of the action's process.
+// If no cwd is given, "/" is used.
+// If no userinfo is given, the process runs as root, as it always has.
+// When userinfo is given, HOME and USER are also set, unless an input already set them.
+//
+// Errors:
+//
+//    - warpforge-error-formula-invalid -- when the cwd is not an absolute path, or the uid or gid is negative
+func (rc *runcConfig) configureProcess(cwd *string, userinfo *wfapi.ActionUserinfo) error {
+	rc.spec.Process.Cwd = "/"
+	if cwd != nil {
+		if !filepath.IsAbs(*cwd) {
+			return wfapi.ErrorFormulaInvalid(fmt.Sprintf("cwd %q must be an absolute path", *cwd))
+		}
+		rc.spec.Process.Cwd = filepath.Clean(*cwd)
+	}
+
+	if userinfo == nil {
+		return nil
+	}
+	if userinfo.Uid < 0 || userinfo.Gid < 0 {
+		return wfapi.ErrorFormulaInvalid("userinfo uid and gid must not be negative")
+	}
+	rc.spec.Process.User = specs.User{
+		UID: uint32(userinfo.Uid),
+		GID: uint32(userinfo.Gid),
+	}
+	rc.setEnvDefault("HOME", userinfo.Homedir)
+	rc.setEnvDefault("USER", userinfo.Username)
+	return nil
+}
+
+// setEnv sets an environment variable for the container's process, replacing any value it already has.
+func (rc *runcConfig) setEnv(key string, value string) {
+	env := []string{}
+	for _, v := range rc.spec.Process.Env {
+		if strings.Split(v, "=")[0] != key {
+			env = append(env, v)
+		}
+	}
+	rc.spec.Process.Env = append(env, fmt.Sprintf("%s=%s", key, value))
+}
+
+// setEnvDefault sets an environment variable for the container's process, unless it's already set.
+func (rc *runcConfig) setEnvDefault(key string, value string) {
+	for _, v := range rc.spec.Process.Env {
+		if strings.Split(v, "=")[0] == key {
+			return
+		}
+	}
+	rc.spec.Process.Env = append(rc.spec.Process.Env, key+"="+value)
+}
+
+// sortedPorts returns the ports of a formula's inputs in the order their inputs are set up:
+// variables first, then paths, with every path before any path within it.
+// Mounts are made in the order they're listed, so this means a mount is never shadowed
+// by the mount of a directory it's within (a literal file within a ware, say).
+// Otherwise, ports are sorted by name, so that the order is always the same.
+func sortedPorts(inputs map[wfapi.SandboxPort]wfapi.FormulaInput) []wfapi.SandboxPort {
+	ports := make([]wfapi.SandboxPort, 0, len(inputs))
+	for port := range inputs {
+		ports = append(ports, port)
+	}
+	depth := func(port wfapi.SandboxPort) int {
+		if port.SandboxPath == nil {
+			return -1
+		}
+		path := filepath.Clean("/" + string(*port.SandboxPath))
+		if path == "/" {
+			return 0
+		}
+		return strings.Count(path, "/")
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		if di, dj := depth(ports[i]), depth(ports[j]); di != dj {
+			return di < dj
+		}
+		return PortString(ports[i]) < PortString(ports[j])
+	})
+	return ports
+}
+
 func wareCachePath(base string, wareId wfapi.WareID) string {
 	packType := string(wareId.Packtype)
 	hash := wareId.Hash
 	return filepath.Join(base, packType, "fileset", hash[0:3], hash[3:6], hash)
 }
 
-// Creates a mount for a ware
+// Creates a mount for a ware, or for several wares stacked at one path
 // This function performs several steps to create and configure a ware mount
-//   1. Determine which warehouse to use to fetch the ware
-//   2. Configure a runc execution for unpacking the mount
-//   3. Invoke `rio unpack` using runc to unpack the ware
-//   4. Use result of `rio unpack` to create a ware mount for execution
+//   1. Check to see if each ware already exists in the cache
+//   2. If not, or if there are filters, unpack the ware into the cache using the Packer for its packtype (unless explaining)
+//   3. Create an overlay mount of the cached wares for execution,
+//      with the first ware at the bottom, and the last one on top
 //
-// This function also checks to see if a cached ware exists in the warehouse.
-// If so, no unpack operation is performed.
+// If subpath isn't empty, only that directory within each ware is mounted.
 //
 // Errors:
 //
 //     - warpforge-error-io -- when IO error occurs during setup
-//     - warpforge-error-executor-failed -- when runc execution of `rio unpack` fails
-//     - warpforge-error-ware-unpack -- when `rio unpack` operation fails
+//     - warpforge-error-executor-failed -- when the container running the unpack fails
+//     - warpforge-error-ware-unpack -- when the unpack operation fails
+//     - warpforge-error-formula-invalid -- when the subpath isn't a directory within every ware
 func (rc *runcConfig) makeWareMount(ctx context.Context,
-	wareId wfapi.WareID,
+	wareIds []wfapi.WareID,
+	subpath string,
 	dest string,
 	context *wfapi.FormulaContext,
 	filters wfapi.FilterMap,
 ) (specs.Mount, error) {
-	// default warehouse to unpack from
-	src := "ca+file://" + containerWarehousePath()
-
-	// check to see if this ware should be fetched from a different warehouse
-	for k, v := range context.Warehouses.Values {
-		if k.String() == wareId.String() {
-			wareAddr := string(v)
-
-			// check if we need to create a mount for this warehouse
-			proto := strings.Split(wareAddr, ":")[0]
-			hostPath := strings.Split(wareAddr, "://")[1]
-			if proto == "file" || proto == "file+ca" {
-				// this is a local file or directory, we will need to mount it to the container for unpacking
-				// we will mount it at CONTAINER_BASE_PATH/tmp
-				src = filepath.Join(CONTAINER_BASE_PATH, "tmp")
-				mnt, err := rc.makeBindPathMount(ctx, hostPath, src, true)
-				if err != nil {
-					return mnt, err
-				}
-				rc.spec.Mounts = append(rc.spec.Mounts, mnt)
-
-				// finally, add the protocol back on to the src string for rio
-				src = fmt.Sprintf("%s://%s", proto, src)
-			} else {
-				// this is a network address, pass it to rio as is
-				src = string(v)
-				// HACK
-				src = strings.Replace(src, "ca+s3", "ca+https", 1)
+	// overlayfs lists lowerdirs top first
+	lowerdirs := make([]string, len(wareIds))
+	var cacheWareId wfapi.WareID
+	for i, wareId := range wareIds {
+		cacheWareId = wareId
+		// check if the cached ware already exists.
+		// the cache is keyed by what was unpacked, which filters change, and which we can't know without unpacking:
+		// so when there are filters, the unpack always runs, rather than mounting an unfiltered tree.
+		expectCachePath := wareCachePath(rc.cachePath, wareId)
+		_, errRaw := os.Stat(expectCachePath)
+		if (os.IsNotExist(errRaw) || len(filters.Values) > 0) && !rc.explain {
+			// no cached ware (or filters to apply), run the unpack
+			var err error
+			cacheWareId, err = packerFor(wareId.Packtype).Unpack(ctx, rc, wareId, context, filters)
+			if err != nil {
+				return specs.Mount{}, err
 			}
 		}
-	}
-
-	// unpacking may require fetching from a remote source, which may
-	// require network access. since we do this in an empty container,
-	// we need a resolv.conf for DNS configuration and /etc/ssl/certs
-	// for trusted CAs
-	rc.spec.Mounts = append(rc.spec.Mounts, getNetworkMounts()...)
-
-	// convert FilterMap to rio string
-	var filterStr string
-	for name, value := range filters.Values {
-		filterStr = fmt.Sprintf(",%s%s=%s", filterStr, name, value)
-	}
-
-	// perform a rio unpack with no placer. this will unpack the contents
-	// to the RIO_CACHE dir and stop. we will then overlay mount the cache
-	// dir when executing the formula.
-	rc.spec.Process.Env = []string{"RIO_CACHE=" + containerCachePath()}
-	rc.spec.Process.Args = []string{
-		filepath.Join(containerBinPath(), "rio"),
-		"unpack",
-		fmt.Sprintf("--source=%s", src),
-		// force uid and gid to zero since these are the values in the container
-		// note that the resulting hash used for placing this in the cache dir
-		// will end up being different if a tar doesn't only use uid/gid 0!
-		// these *must* be zero due to runc issue 1800, otherwise we would
-		// choose a more sane value
-		"--filters=uid=0,gid=0,mtime=follow" + filterStr,
-		"--placer=none",
-		"--format=json",
-		wareId.String(),
-		"/null",
-	}
-
-	cacheWareId := wareId
-	// check if the cached ware already exists
-	expectCachePath := wareCachePath(rc.cachePath, wareId)
-	if _, errRaw := os.Stat(expectCachePath); os.IsNotExist(errRaw) {
-		// no cached ware, run the unpack
-		outStr, err := rc.invokeRunc(ctx, nil)
+		lowerdirPath, err := rc.wareSubpath(wareCachePath(rc.cachePath, cacheWareId), wareId, subpath)
 		if err != nil {
-			// TODO: currently, this will return an ExecutorFailed error
-			// it would be better to determine if runc or rio failed, and return
-			// ErrorWareUnpack if it was rio's fault
 			return specs.Mount{}, err
 		}
-		out := RioOutput{}
-		for _, line := range strings.Split(outStr, "\n") {
-			err := json.Unmarshal([]byte(line), &out)
-			if err != nil {
-				return specs.Mount{}, wfapi.ErrorWareUnpack(wareId, wfapi.ErrorSerialization("deserializing rio output", err))
-			}
-			if out.Result.WareId != "" {
-				// found wareId
-				break
-			}
-		}
-		if out.Result.WareId == "" {
-			return specs.Mount{}, wfapi.ErrorWareUnpack(wareId, fmt.Errorf("rio unpack resulted in empty WareID output"))
-		}
-		// UID/GID filters can mean the ware ID changes.
-		wareIdSplit := strings.SplitN(out.Result.WareId, ":", 2)
-		cacheWareId = wfapi.WareID{Packtype: wfapi.Packtype(wareIdSplit[0]), Hash: wareIdSplit[1]}
+		lowerdirs[len(wareIds)-1-i] = lowerdirPath
 	}
+	lowerdir := strings.Join(lowerdirs, ":")
 
-	lowerdirPath := wareCachePath(rc.cachePath, cacheWareId)
-	upperdirPath := filepath.Join(rc.runPath, "overlays", fmt.Sprintf("upper-%s", cacheWareId))
-	workdirPath := filepath.Join(rc.runPath, "overlays", fmt.Sprintf("work-%s", cacheWareId))
+	// a single whole ware is named for itself; anything else, for everything it's made of,
+	// so that mounts of different parts of the same ware don't share their upper and work dirs.
+	mountId := cacheWareId.String()
+	if len(wareIds) > 1 || subpath != "" {
+		mountId = fmt.Sprintf("stack-%x", sha256.Sum256([]byte(lowerdir)))
+	}
+	upperdirPath := filepath.Join(rc.runPath, "overlays", fmt.Sprintf("upper-%s", mountId))
+	workdirPath := filepath.Join(rc.runPath, "overlays", fmt.Sprintf("work-%s", mountId))
 
 	// create upper and work dirs
 	errRaw := os.MkdirAll(upperdirPath, 0755)
@@ -595,7 +838,7 @@ func (rc *runcConfig) makeWareMount(ctx context.Context,
 		Source:      "none",
 		Type:        "overlay",
 		Options: []string{
-			"lowerdir=" + lowerdirPath,
+			"lowerdir=" + lowerdir,
 			"upperdir=" + upperdirPath,
 			"workdir=" + workdirPath,
 			"userxattr,index=on,xino=on",
@@ -603,6 +846,35 @@ func (rc *runcConfig) makeWareMount(ctx context.Context,
 	}, nil
 }
 
+// wareSubpath returns the path of a directory within an unpacked ware.
+// Symlinks within the ware aren't followed, since they'd be resolved on the host, rather than within the ware.
+// Nothing is checked when explaining, since the ware may not have been unpacked.
+//
+// Errors:
+//
+//    - warpforge-error-formula-invalid -- when the subpath leaves the ware, or isn't a directory within it
+func (rc *runcConfig) wareSubpath(warePath string, wareId wfapi.WareID, subpath string) (string, error) {
+	rel := filepath.Clean(strings.TrimPrefix(subpath, "/"))
+	if rel == "." {
+		return warePath, nil
+	}
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", wfapi.ErrorFormulaInvalid(fmt.Sprintf("subpath %q leaves the ware", subpath))
+	}
+	if rc.explain {
+		return filepath.Join(warePath, rel), nil
+	}
+	path := warePath
+	for _, name := range strings.Split(rel, "/") {
+		path = filepath.Join(path, name)
+		fi, err := os.Lstat(path)
+		if err != nil || !fi.IsDir() {
+			return "", wfapi.ErrorFormulaInvalid(fmt.Sprintf("subpath %q is not a directory in ware %s (symlinks are not followed)", subpath, wareId))
+		}
+	}
+	return path, nil
+}
+
 // Creates an overlay mount for a path on the host filesystem
 //
 // Errors:
@@ -652,112 +924,125 @@ func (rc *runcConfig) makeBindPathMount(ctx context.Context, path string, dest s
 	}, nil
 }
 
-// Performs runc invocation and collects results.
+// literalsPath returns the directory within the run directory where literal files are written.
+func (rc *runcConfig) literalsPath() string {
+	return filepath.Join(rc.runPath, "literals")
+}
+
+// Creates a read-only file with the content of a literal, and a mount for it
 //
 // Errors:
 //
-//    - warpforge-error-executor-failed -- invocation of runc caused an error
-//    - warpforge-error-io -- i/o error occurred during setup of runc invocation
-func (rc *runcConfig) invokeRunc(ctx context.Context, logWriter io.Writer) (string, error) {
-	ctx, span := tracing.Start(ctx, "invokeRunc")
-	defer span.End()
-	rc.debug(ctx)
-	configBytes, err := json.Marshal(rc.spec)
-	if err != nil {
-		return "", wfapi.ErrorExecutorFailed("runc", wfapi.ErrorSerialization("failed to serialize runc config", err))
+//    - warpforge-error-io -- when the file can't be written
+func (rc *runcConfig) makeLiteralMount(ctx context.Context, lit wfapi.Literal, dest string) (specs.Mount, error) {
+	literalsPath := rc.literalsPath()
+	if err := os.MkdirAll(literalsPath, 0755); err != nil {
+		return specs.Mount{}, wfapi.ErrorIo("failed to create literals dir", literalsPath, err)
 	}
-
-	bundlePath, err := ioutil.TempDir(rc.runPath, "bundle-")
+	f, err := os.CreateTemp(literalsPath, "")
 	if err != nil {
-		return "", wfapi.ErrorIo("creating bundle tmpdir", bundlePath, err)
+		return specs.Mount{}, wfapi.ErrorIo("failed to create literal file", literalsPath, err)
 	}
-	configPath := filepath.Join(bundlePath, "config.json")
-	err = ioutil.WriteFile(configPath, configBytes, 0644)
-	if err != nil {
-		return "", wfapi.ErrorIo("writing config.json", configPath, err)
-	}
-
-	cmdCtx, cmdSpan := tracing.Start(ctx, "exec bundle", trace.WithAttributes(tracing.AttrFullExecNameRunc))
-	defer cmdSpan.End()
-	cmd := exec.CommandContext(cmdCtx, filepath.Join(rc.binPath, "runc"),
-		"--root", rc.rootPath,
-		"run",
-		"-b", bundlePath, // bundle path
-		fmt.Sprintf("warpforge-%d", time.Now().UTC().UnixNano()), // container id
-	)
-
-	// if the config has terminal enabled, and interactivity is requested,
-	// wire stdin to the contaniner
-	if rc.spec.Process.Terminal && rc.interactive {
-		cmd.Stdin = os.Stdin
-	}
-
-	// if a logWriter was provided, write output to it
-	// otherwise, capture stderr and stdout to buffers
-	var stderrBuf bytes.Buffer
-	var stdoutBuf bytes.Buffer
-	if logWriter != nil {
-		cmd.Stderr = io.MultiWriter(&stderrBuf, logWriter)
-		cmd.Stdout = io.MultiWriter(&stdoutBuf, logWriter)
-	} else {
-		cmd.Stderr = &stderrBuf
-		cmd.Stdout = &stdoutBuf
+	defer f.Close()
+	if _, err := f.WriteString(string(lit)); err != nil {
+		return specs.Mount{}, wfapi.ErrorIo("failed to write literal file", f.Name(), err)
 	}
-	err = cmd.Run()
-	tracing.EndWithStatus(cmdSpan, err)
-	if err != nil {
-		span.SetStatus(codes.Error, err.Error())
-		return "", wfapi.ErrorExecutorFailed("runc", fmt.Errorf("%s %s", stdoutBuf.String(), stderrBuf.String()))
+	if err := f.Chmod(actionReadableMode(false)); err != nil {
+		return specs.Mount{}, wfapi.ErrorIo("failed to write literal file", f.Name(), err)
 	}
-	// TODO what exitcode do we care about?
-	return stdoutBuf.String(), nil
+	return rc.makeBindPathMount(ctx, f.Name(), dest, true)
 }
 
-// Packs a given path within a container as a ware in the host system's warehouse
+// applyLimits sets the resource limits and timeout of the container.
+// A nil limits applies no limits at all.
 //
 // Errors:
 //
-//    - warpforge-error-executor-failed -- if runc execution fails
-//    - warpforge-error-ware-pack -- if rio pack of ware fails
-func (rc *runcConfig) rioPack(ctx context.Context, path string) (wfapi.WareID, error) {
-	ctx, span := tracing.Start(ctx, "rioPack")
-	defer span.End()
-	rc.spec.Process.Args = []string{
-		filepath.Join(containerBinPath(), "rio"),
-		"pack",
-		"--format=json",
-		"--filters=uid=0,gid=0",
-		"--target=ca+file://" + containerWarehousePath(),
-		"tar",
-		path,
+//    - warpforge-error-formula-invalid -- when a limit is negative
+func (rc *runcConfig) applyLimits(limits *wfapi.ResourceLimits) error {
+	if limits == nil {
+		return nil
 	}
-
-	outStr, err := rc.invokeRunc(ctx, nil)
-	if err != nil {
-		// TODO: this should figure out of the rio op failed, or if runc failed and throw different errors
-		return wfapi.WareID{}, wfapi.ErrorExecutorFailed(fmt.Sprintf("invoke runc for rio pack of %s failed", path), err)
+	for name, v := range map[string]*int{
+		"memory":    limits.Memory,
+		"cpuShares": limits.CpuShares,
+		"cpuQuota":  limits.CpuQuota,
+		"cpuPeriod": limits.CpuPeriod,
+		"pids":      limits.Pids,
+		"timeout":   limits.Timeout,
+	} {
+		if v != nil && *v < 0 {
+			return wfapi.ErrorFormulaInvalid(fmt.Sprintf("limit %q must not be negative", name))
+		}
 	}
 
-	out := RioOutput{}
-	for _, line := range strings.Split(outStr, "\n") {
-		err := json.Unmarshal([]byte(line), &out)
-		if err != nil {
-			return wfapi.WareID{}, wfapi.ErrorWarePack(path,
-				wfapi.ErrorSerialization("deserializing rio pack output", err))
+	// copy the resources, so that the limits can be removed again by restoring the original pointer
+	res := &specs.LinuxResources{}
+	if rc.spec.Linux.Resources != nil {
+		*res = *rc.spec.Linux.Resources
+	}
+	rc.spec.Linux.Resources = res
+	if limits.Memory != nil {
+		memory := int64(*limits.Memory)
+		res.Memory = &specs.LinuxMemory{Limit: &memory}
+	}
+	if limits.CpuShares != nil || limits.CpuQuota != nil || limits.CpuPeriod != nil {
+		res.CPU = &specs.LinuxCPU{}
+		if limits.CpuShares != nil {
+			shares := uint64(*limits.CpuShares)
+			res.CPU.Shares = &shares
+		}
+		if limits.CpuQuota != nil {
+			quota := int64(*limits.CpuQuota)
+			res.CPU.Quota = &quota
 		}
-		if out.Result.WareId != "" {
-			// found wareId
-			span.AddEvent("Found ware ID", trace.WithAttributes(attribute.String(tracing.AttrKeyWarpforgeWareId, out.Result.WareId)))
-			break
+		if limits.CpuPeriod != nil {
+			period := uint64(*limits.CpuPeriod)
+			res.CPU.Period = &period
 		}
 	}
-	if out.Result.WareId == "" {
-		return wfapi.WareID{}, wfapi.ErrorWarePack(path, fmt.Errorf("empty WareID value from rio pack"))
+	if limits.Pids != nil {
+		res.Pids = &specs.LinuxPids{Limit: int64(*limits.Pids)}
+	}
+	if limits.Timeout != nil {
+		rc.timeout = time.Duration(*limits.Timeout) * time.Second
+	}
+	return nil
+}
+
+// debugShell launches an interactive shell in the container of an action which has failed.
+// The shell has the same mounts as the action -- including the upperdirs of overlays,
+// which hold any changes the action made -- as well as the same environment, working directory and user.
+// This is a new process, so any shell state of the action (such as variables set by a script) is gone.
+// The exit code of the shell is ignored; the action has failed regardless.
+//
+// Errors:
+//
+//    - warpforge-error-executor-failed -- when the shell can't be run
+func (rc *runcConfig) debugShell(ctx context.Context, shell string) error {
+	logger := logging.Ctx(ctx)
+	if !rc.spec.Process.Terminal {
+		logger.Info(LOG_TAG, "no terminal available, not starting a debug shell")
+		return nil
+	}
+	logger.Info(LOG_TAG, "starting debug shell:\t%s = %s\t(exit the shell to end the run)",
+		color.HiBlueString("shell"),
+		color.WhiteString(shell))
+
+	rc.spec.Process.Args = []string{shell}
+	rc.interactive = true
+	// the shell can take as long as it's needed for
+	rc.timeout = 0
+
+	_, _, err := rc.executor.Run(ctx, rc, logger.RawWriter())
+	switch serum.Code(err) {
+	case "", wfapi.ECodeActionFailed:
+		// the last command run in the shell failing is unremarkable
+		return nil
+	default:
+		// Error Codes -= warpforge-error-action-failed
+		return err
 	}
-	wareId := wfapi.WareID{}
-	wareId.Packtype = wfapi.Packtype(strings.Split(out.Result.WareId, ":")[0])
-	wareId.Hash = strings.Split(out.Result.WareId, ":")[1]
-	return wareId, nil
 }
 
 // Internal function for executing a formula
@@ -766,11 +1051,15 @@ func (rc *runcConfig) rioPack(ctx context.Context, path string) (wfapi.WareID, e
 //
 // - warpforge-error-io -- when an IO operation fails
 // - warpforge-error-executor-failed -- when the execution step of the formula fails
+// - warpforge-error-action-failed -- when the action of the formula exits non-zero
+// - warpforge-error-action-timeout -- when the action of the formula runs for longer than its timeout
 // - warpforge-error-ware-unpack -- when a ware unpack operation fails for a formula input
 // - warpforge-error-ware-pack -- when a ware pack operation fails for a formula output
 // - warpforge-error-formula-invalid -- when an invalid formula is provided
 // - warpforge-error-serialization -- when serialization or deserialization of a memo fails
 // - warpforge-error-internal -- when copying the runc spec fails
+// - warpforge-error-missing -- when a variable gathered as an output was not set by the action
+// - warpforge-error-not-hermetic -- when strict mode is enabled, and the formula is not hermetic
 func execFormula(ctx context.Context, cfg internalConfig) (wfapi.RunRecord, error) {
 	logger := logging.Ctx(ctx)
 	ctx, span := tracing.Start(ctx, "execFormula")
@@ -781,14 +1070,42 @@ func execFormula(ctx context.Context, cfg internalConfig) (wfapi.RunRecord, erro
 		return rr, wfapi.ErrorFormulaInvalid("no v1 Formula in FormulaCapsule")
 	}
 	formula := cfg.FormulaAndContext.Formula.Formula
+	varOutputs, err := gatherVars(*formula)
+	if err != nil {
+		return rr, err
+	}
+	if err := checkGatherPaths(*formula); err != nil {
+		return rr, err
+	}
+	if err := cfg.checkHermetic(*formula); err != nil {
+		return rr, err
+	}
+	networkPolicies, err := cfg.networkPolicies(*formula)
+	if err != nil {
+		return rr, err
+	}
+	cfg.executor, err = NewExecutor(cfg.executorName(), cfg.BinPath)
+	if err != nil {
+		return rr, err
+	}
+	if len(cfg.UidMappings) > 0 && len(cfg.GidMappings) > 0 && !cfg.keepOwnership() {
+		logger.Info(LOG_TAG, "executor %q doesn't support user namespaces: ignoring uid and gid mappings, so files will be owned by root", cfg.executor.Name())
+	}
 
 	context := wfapi.FormulaContext{}
 	if cfg.FormulaAndContext.Context != nil && cfg.FormulaAndContext.Context.FormulaContext != nil {
 		context = *cfg.FormulaAndContext.Context.FormulaContext
 	}
+	cfg.addRemoteWarehouse(*formula, &context)
 
 	// convert formula to node
-	nFormula := bindnode.Wrap(cfg.FormulaAndContext.Formula.Formula, wfapi.TypeSystem.TypeByName("Formula"))
+	// secret and cache inputs, and limits, are left out, so that they can't affect the formula ID.
+	// keeping ownership is made explicit, so that it does.
+	idFormula := formulaForID(*formula, cfg.keepOwnership())
+	nFormula, errRaw := wfapi.HashableNode(bindnode.Wrap(&idFormula, wfapi.TypeSystem.TypeByName("Formula")).(schema.TypedNode))
+	if errRaw != nil {
+		panic(fmt.Sprintf("Fatal IPLD Error: failed to copy Formula for hashing: %s", errRaw))
+	}
 
 	// set up the runrecord result
 	rr.Guid = uuid.New().String()
@@ -799,7 +1116,7 @@ func execFormula(ctx context.Context, cfg internalConfig) (wfapi.RunRecord, erro
 		Codec:    0x71, // 0x71 means "dag-cbor" -- See the multicodecs table: https://github.com/multiformats/multicodec/
 		MhType:   0x20, // 0x20 means "sha2-384" -- See the multicodecs table: https://github.com/multiformats/multicodec/
 		MhLength: 48,   // sha2-384 hash has a 48-byte sum.
-	}}, nFormula.(schema.TypedNode).Representation())
+	}}, nFormula)
 	if errRaw != nil {
 		// panic! this should never fail unless IPLD is broken
 		panic(fmt.Sprintf("Fatal IPLD Error: lsys.ComputeLink failed for Formula: %s", errRaw))
@@ -812,9 +1129,11 @@ func execFormula(ctx context.Context, cfg internalConfig) (wfapi.RunRecord, erro
 	span.SetAttributes(attribute.String(tracing.AttrKeyWarpforgeFormulaId, fid))
 	logger.Info(LOG_TAG_START, "")
 
-	if memo, err := cfg.loadMemo(ctx, fid); err != nil {
+	memo, err := cfg.loadMemo(ctx, fid)
+	if err != nil {
 		return rr, err
-	} else if memo != nil {
+	}
+	if memo != nil && !cfg.FormulaExecConfig.Explain && cfg.bundlePath == "" {
 		logger.PrintRunRecord(LOG_TAG, *memo, true)
 		logger.Info(LOG_TAG_END, "")
 		return *memo, nil
@@ -840,15 +1159,26 @@ func execFormula(ctx context.Context, cfg internalConfig) (wfapi.RunRecord, erro
 	if errRaw != nil {
 		return rr, wfapi.ErrorIo("failed to create temp run directory", cfg.RunPathBase, errRaw)
 	}
+	// the lock is held for the whole run, so that `warpforge cleanup` leaves the run directory alone
+	runPathLock, err := lockRunPath(runPath)
+	if err != nil {
+		os.RemoveAll(runPath)
+		return rr, err
+	}
+	defer runPathLock.Close()
 	if !cfg.KeepRunDir {
-		defer os.RemoveAll(runPath)
+		defer func() {
+			if err := removeRunPath(runPath); err != nil {
+				logger.Info(LOG_TAG, "failed to remove rundir (`warpforge cleanup` will remove it later): %s", err)
+			}
+		}()
 		logger.Debug(LOG_TAG, "using rundir %q", runPath)
 	} else {
 		logger.Info(LOG_TAG, "using rundir %q", runPath)
 	}
 	cfg.debug(ctx)
 
-	baseSpec, err := newRuncSpec(ctx, runPath, cfg.BinPath)
+	baseSpec, err := cfg.executor.Spec(ctx, runPath)
 	if err != nil {
 		return rr, err
 	}
@@ -860,30 +1190,48 @@ func execFormula(ctx context.Context, cfg internalConfig) (wfapi.RunRecord, erro
 		return rr, err
 	}
 
-	// loop over formula inputs
-	for port, input := range formula.Inputs.Values {
-		// get the FormulaInputSimple and FilterMap for this input
+	// loop over formula inputs, parents first, so that inputs within another input's path are mounted on top of it
+	for _, port := range sortedPorts(formula.Inputs.Values) {
+		input := formula.Inputs.Values[port]
+		// get the FormulaInputSimple, FilterMap and subpath for this input
 		inputSimple := input.Basis()
 		var filters wfapi.FilterMap
-		if input.FormulaInputComplex != nil {
-			filters = input.FormulaInputComplex.Filters
+		var subpath string
+		layered := false
+		if inputComplex := input.FormulaInputComplex; inputComplex != nil {
+			if inputComplex.Filters != nil {
+				filters = *inputComplex.Filters
+			}
+			if inputComplex.Subpath != nil {
+				subpath = *inputComplex.Subpath
+			}
+			layered = inputComplex.Layers != nil
+		}
+		if (subpath != "" || layered) && (inputSimple.WareID == nil || port.SandboxPath == nil) {
+			return rr, wfapi.ErrorFormulaInvalid(fmt.Sprintf("input %q: only wares mounted at a path can have a subpath or layers", PortString(port)))
 		}
 
-		if port.SandboxVar != nil {
-			// construct the string for this env var
-			varStr := fmt.Sprintf("%s=%s", *port.SandboxVar, *inputSimple.Literal)
+		if inputSimple.Secret != nil {
+			if err := execConfig.addSecret(ctx, port, *inputSimple.Secret, cfg.FormulaDirectory); err != nil {
+				return rr, err
+			}
+			continue
+		}
+		if inputSimple.CacheName != nil {
+			if err := execConfig.addCacheMount(ctx, port, *inputSimple.CacheName, cfg.RootWs); err != nil {
+				return rr, err
+			}
+			continue
+		}
 
+		if port.SandboxVar != nil {
+			if inputSimple.Literal == nil {
+				return rr, wfapi.ErrorFormulaInvalid(fmt.Sprintf("input %q is a variable, so must be a literal", PortString(port)))
+			}
 			// insert the variable to the container spec, de-duplicating any existing variables
 			// note that the runc default config has PATH and TERM defined, so this allows
 			// for overriding those defaults
-			oldVars := execConfig.spec.Process.Env
-			execConfig.spec.Process.Env = []string{}
-			for _, v := range oldVars {
-				if strings.Split(v, "=")[0] != string(*port.SandboxVar) {
-					execConfig.spec.Process.Env = append(execConfig.spec.Process.Env, v)
-				}
-			}
-			execConfig.spec.Process.Env = append(execConfig.spec.Process.Env, varStr)
+			execConfig.setEnv(string(*port.SandboxVar), string(*inputSimple.Literal))
 		} else if port.SandboxPath != nil {
 			var mnt specs.Mount
 			// create a temporary config for setting up the mount
@@ -945,16 +1293,42 @@ func execFormula(ctx context.Context, cfg internalConfig) (wfapi.RunRecord, erro
 					return rr, err
 				}
 
+			case inputSimple.Literal != nil:
+				// literal file
+				logger.Info(LOG_TAG,
+					"literal file:\t%s = %d bytes\t%s = %s",
+					color.HiBlueString("literal"),
+					len(*inputSimple.Literal),
+					color.HiBlueString("destPath"),
+					color.WhiteString(destPath))
+				mnt, err = tmpConfig.makeLiteralMount(ctx, *inputSimple.Literal, destPath)
+				if err != nil {
+					return rr, err
+				}
 			case inputSimple.WareID != nil:
-				// ware mount
+				// ware mount, with any layers stacked on top
+				var wareIds []wfapi.WareID
+				var wareIdStrs []string
+				for _, layer := range input.Layers() {
+					if layer.WareID == nil {
+						return rr, wfapi.ErrorFormulaInvalid(fmt.Sprintf("input %q: every layer must be a ware", PortString(port)))
+					}
+					wareIds = append(wareIds, *layer.WareID)
+					wareIdStrs = append(wareIdStrs, layer.WareID.String())
+				}
 				destPath = filepath.Join("/", string(*port.SandboxPath))
 				logger.Info(LOG_TAG,
 					"ware mount:\t%s = %s\t%s = %s",
 					color.HiBlueString("wareId"),
-					color.WhiteString(inputSimple.WareID.String()),
+					color.WhiteString(strings.Join(wareIdStrs, " + ")),
 					color.HiBlueString("destPath"),
 					color.WhiteString(destPath))
-				mnt, err = tmpConfig.makeWareMount(ctx, *inputSimple.WareID, destPath, &context, filters)
+				if subpath != "" {
+					logger.Info(LOG_TAG, "\t%s = %s",
+						color.HiBlueString("subpath"),
+						color.WhiteString(subpath))
+				}
+				mnt, err = tmpConfig.makeWareMount(ctx, wareIds, subpath, destPath, &context, filters)
 				if err != nil {
 					return rr, err
 				}
@@ -973,20 +1347,32 @@ func execFormula(ctx context.Context, cfg internalConfig) (wfapi.RunRecord, erro
 	}
 
 	// add network mounts if networking is enabled, otherwise disable networking
-	enableNet := false
-	switch {
-	case formula.Action.Exec != nil:
-		if formula.Action.Exec.Network != nil {
-			enableNet = *formula.Action.Exec.Network
-		}
-	case formula.Action.Script != nil:
-		if formula.Action.Script.Network != nil {
-			enableNet = *formula.Action.Script.Network
-		}
-	}
-	if enableNet {
+	var proxy *networkProxy
+	if formula.Action.NetworkEnabled() {
 		execConfig.spec.Mounts = append(execConfig.spec.Mounts, getNetworkMounts()...)
 		logger.Debug(LOG_TAG, "networking enabled")
+		for _, policy := range networkPolicies {
+			logger.Info(LOG_TAG, "network limited by policy:\t%s = %s",
+				color.HiBlueString("allow"),
+				color.WhiteString("%q", policy.Allow))
+		}
+		switch {
+		case len(networkPolicies) == 0:
+		case cfg.bundlePath != "":
+			// the proxy only runs alongside warpforge, so nothing would enforce the policy
+			return rr, wfapi.ErrorFormulaInvalid("network policies can't be enforced by an exported bundle, so actions with one can't be exported")
+		case !cfg.FormulaExecConfig.Explain:
+			hookBin, err := os.Executable()
+			if err != nil {
+				return rr, wfapi.ErrorIo("failed to find warpforge binary for network proxy hook", "", err)
+			}
+			proxy = newNetworkProxy(networkPolicies)
+			if err := proxy.acceptHandoffs(filepath.Join(runPath, proxyHandoffName)); err != nil {
+				return rr, err
+			}
+			defer proxy.close()
+			execConfig.useNetworkProxy(hookBin, filepath.Join(runPath, proxyHandoffName))
+		}
 	} else {
 		// create empty network namespace to disable network
 		execConfig.spec.Linux.Namespaces = append(execConfig.spec.Linux.Namespaces,
@@ -1002,7 +1388,12 @@ func execFormula(ctx context.Context, cfg internalConfig) (wfapi.RunRecord, erro
 	case formula.Action.Exec != nil:
 		logger.Info(LOG_TAG, "executing command: %q", strings.Join(formula.Action.Exec.Command, " "))
 		execConfig.spec.Process.Args = formula.Action.Exec.Command
-		execConfig.spec.Process.Cwd = "/"
+		if err := execConfig.configureProcess(formula.Action.Exec.Cwd, formula.Action.Exec.Userinfo); err != nil {
+			return rr, err
+		}
+		if err := execConfig.configureDeterministic(*formula); err != nil {
+			return rr, err
+		}
 	case formula.Action.Script != nil:
 		// the script action creates a seperate "entry" file for each element in the script contents
 		// and creates a "run" file which executes these in order within the the current shell process.
@@ -1025,6 +1416,10 @@ func execFormula(ctx context.Context, cfg internalConfig) (wfapi.RunRecord, erro
 			return rr, wfapi.ErrorIo("failed to open script file for writing", scriptFilePath, errRaw)
 		}
 		defer scriptFile.Close()
+		_, errRaw = scriptFile.WriteString(scriptPreludeSrc)
+		if errRaw != nil {
+			return rr, wfapi.ErrorIo("error writing script file", scriptFilePath, errRaw)
+		}
 
 		// iterate over each item in script contents
 		for n, entry := range formula.Action.Script.Contents {
@@ -1042,17 +1437,42 @@ func execFormula(ctx context.Context, cfg internalConfig) (wfapi.RunRecord, erro
 				return rr, wfapi.ErrorIo("error writing entry file", entryFilePath, err)
 			}
 
-			// write a line to execute this entry into the main script file
+			// write the lines to execute this entry into the main script file
 			// we use the POSIX standard `. filename` to cause the entry file to be executed
 			// within the current shell process (also known as `source` in bash)
-			entrySrc := fmt.Sprintf(". %s\n",
-				filepath.Join(containerScriptPath(), fmt.Sprintf("entry-%d", n)))
-			_, err = scriptFile.WriteString(entrySrc)
+			_, err = scriptFile.WriteString(scriptEntrySrc(n))
 			if err != nil {
 				return rr, wfapi.ErrorIo("error writing entry to script file", scriptFilePath, err)
 			}
 		}
 
+		// if any variables are gathered as outputs, write them out once all entries have run.
+		// the exit code of the entries is preserved, so that writing the variables can't mask a failure.
+		if len(varOutputs) > 0 {
+			varsPath := filepath.Join(scriptPath, "vars")
+			errRaw = os.MkdirAll(varsPath, 0755)
+			if errRaw != nil {
+				return rr, wfapi.ErrorIo("failed to create vars dir", varsPath, errRaw)
+			}
+			errRaw = os.Chmod(varsPath, actionWritableMode(true))
+			if errRaw != nil {
+				return rr, wfapi.ErrorIo("failed to set permissions of vars dir", varsPath, errRaw)
+			}
+			gatherSrc := ""
+			for _, v := range varOutputs {
+				gatherSrc += fmt.Sprintf("if [ -n \"${%s+set}\" ]; then printf '%%s' \"$%s\" > %s; fi\n",
+					v, v, filepath.Join(containerVarsPath(), string(v)))
+			}
+			_, errRaw = scriptFile.WriteString(gatherSrc)
+			if errRaw != nil {
+				return rr, wfapi.ErrorIo("error writing variable gathering to script file", scriptFilePath, errRaw)
+			}
+		}
+		_, errRaw = scriptFile.WriteString(scriptExitSrc)
+		if errRaw != nil {
+			return rr, wfapi.ErrorIo("error writing script file", scriptFilePath, errRaw)
+		}
+
 		// create a mount for the script file
 		scriptMount, err := execConfig.makeBindPathMount(ctx, scriptPath, containerScriptPath(), false)
 		if err != nil {
@@ -1064,32 +1484,147 @@ func execFormula(ctx context.Context, cfg internalConfig) (wfapi.RunRecord, erro
 		execConfig.spec.Process.Args = []string{formula.Action.Script.Interpreter,
 			filepath.Join(containerScriptPath(), "run"),
 		}
-		execConfig.spec.Process.Cwd = "/"
+		if err := execConfig.configureProcess(formula.Action.Script.Cwd, formula.Action.Script.Userinfo); err != nil {
+			return rr, err
+		}
+		if err := execConfig.configureDeterministic(*formula); err != nil {
+			return rr, err
+		}
+	case formula.Action.Noop != nil:
+		// the noop action launches no process at all.
+		// the outputs are packed directly from the inputs mounted in the container, with their filters applied.
+		logger.Info(LOG_TAG, "noop action, gathering outputs from inputs")
 	default:
 		return rr, wfapi.ErrorFormulaInvalid("unsupported action, or no action defined")
 	}
 
+	// apply resource limits and timeout to the action only; packing outputs is not limited.
+	unlimitedResources := execConfig.spec.Linux.Resources
+	if err := execConfig.applyLimits(formula.Limits); err != nil {
+		return rr, err
+	}
+
 	// determine initeractivity output formatting.
 	// if interactive, do not apply any special formatting and wire stdin to container
 	// otherwiise, pretty-format the output and do not wire stdin
+	// secrets are redacted from the output, except on an interactive terminal,
+	// where holding output back until the end of a line would get in the way.
 	var runcWriter io.Writer
+	var redactors []*redactingWriter
 	if cfg.FormulaExecConfig.Interactive {
 		runcWriter = logger.RawWriter()
 		execConfig.interactive = true
 	} else {
 		runcWriter = logger.OutputWriter(LOG_TAG_OUTPUT)
 		execConfig.interactive = false
+		if len(execConfig.secrets) > 0 {
+			redactor := newRedactingWriter(runcWriter, execConfig.secrets)
+			redactors = append(redactors, redactor)
+			runcWriter = redactor
+		}
+	}
+
+	// when explaining, everything is now resolved, and nothing more is done.
+	// a memo's results are what a run would (not) produce, so they're passed on.
+	if cfg.FormulaExecConfig.Explain {
+		if memo != nil {
+			rr.Results = memo.Results
+		}
+		return rr, cfg.explainFormula(ctx, *formula, &execConfig, memo != nil)
+	}
+	if cfg.bundlePath != "" {
+		return rr, cfg.writeBundle(ctx, *formula, &execConfig)
 	}
 
 	// run the action
-	logger.Output(LOG_TAG_OUTPUT_START, "")
-	_, err = execConfig.invokeRunc(ctx, runcWriter)
-	logger.Output(LOG_TAG_OUTPUT_END, "")
-	if err != nil {
-		return rr, err
+	if formula.Action.Noop == nil {
+		// keep the action's output, so it can be looked at after the run (see `warpforge logs`)
+		logFile, err := cfg.createLog(ctx, rr)
+		if err != nil {
+			return rr, err
+		}
+		if logFile != nil {
+			defer logFile.Close()
+			logRef := workspace.LogRef(rr.FormulaID, rr.Guid)
+			rr.Log = &logRef
+			var logWriter io.Writer = logFile
+			if len(execConfig.secrets) > 0 {
+				redactor := newRedactingWriter(logFile, execConfig.secrets)
+				redactors = append(redactors, redactor)
+				logWriter = redactor
+			}
+			runcWriter = io.MultiWriter(runcWriter, logWriter)
+		}
+
+		// script actions report the progress of each entry as they run
+		var scriptReport *scriptReporter
+		if formula.Action.Script != nil {
+			scriptReport, err = newScriptReporter(filepath.Join(runPath, "script", "status"))
+			if err != nil {
+				return rr, err
+			}
+		}
+
+		logger.Output(LOG_TAG_OUTPUT_START, "")
+		_, exitCode, err := execConfig.executor.Run(ctx, &execConfig, runcWriter)
+		for _, redactor := range redactors {
+			redactor.Flush()
+		}
+		logger.Output(LOG_TAG_OUTPUT_END, "")
+		rr.Exitcode = exitCode
+		if proxy != nil {
+			accessed := proxy.records()
+			rr.NetworkAccess = &accessed
+		}
+		if scriptReport != nil {
+			entries := scriptReport.finish(exitCode)
+			rr.ScriptEntries = &entries
+			var logWriter io.Writer
+			if logFile != nil {
+				logWriter = logFile
+			}
+			reportScriptEntries(ctx, formula.Action.Script, entries, logWriter)
+		}
+		if err != nil {
+			switch serum.Code(err) {
+			case wfapi.ECodeActionFailed:
+				logger.Info(LOG_TAG, "action failed:\t%s = %s",
+					color.HiBlueString("exitcode"),
+					color.WhiteString(fmt.Sprintf("%d", exitCode)))
+			case wfapi.ECodeActionTimeout:
+				logger.Info(LOG_TAG, "action timed out:\t%s = %s",
+					color.HiBlueString("timeout"),
+					color.WhiteString(execConfig.timeout.String()))
+			}
+			switch serum.Code(err) {
+			case wfapi.ECodeActionFailed, wfapi.ECodeActionTimeout:
+				// failed runs are part of the history too, but failing to record one shouldn't hide why it failed
+				if histErr := cfg.recordHistory(ctx, rr); histErr != nil {
+					logger.Info(LOG_TAG, "unable to record failed run in history: %s", histErr)
+				}
+			}
+			if cfg.FormulaExecConfig.DebugOnFailure {
+				switch serum.Code(err) {
+				case wfapi.ECodeActionFailed, wfapi.ECodeActionTimeout:
+					shell := "/bin/sh"
+					if formula.Action.Script != nil {
+						shell = formula.Action.Script.Interpreter
+					}
+					if err := execConfig.debugShell(ctx, shell); err != nil {
+						return rr, err
+					}
+				}
+			}
+			return rr, err
+		}
 	}
-	// TODO exit code?
-	rr.Exitcode = 0
+
+	// the limits, user, clock, and network proxy of the action don't apply to the containers used for packing outputs
+	execConfig.timeout = 0
+	execConfig.timeNamespace = false
+	execConfig.spec.Linux.Resources = unlimitedResources
+	execConfig.spec.Process.User = specs.User{}
+	execConfig.spec.Hooks = nil
 
 	// collect outputs
 	rr.Results.Values = make(map[wfapi.OutputName]wfapi.FormulaInputSimple)
@@ -1097,7 +1632,13 @@ func execFormula(ctx context.Context, cfg internalConfig) (wfapi.RunRecord, erro
 		switch {
 		case gather.From.SandboxPath != nil:
 			path := string(*gather.From.SandboxPath)
-			wareId, err := execConfig.rioPack(ctx, path)
+			// checkGatherPaths has already checked the packtype and filters
+			packtype := *gather.Packtype
+			filters := wfapi.FilterMap{}
+			if gather.Filters != nil {
+				filters = *gather.Filters
+			}
+			wareId, err := packerFor(packtype).Pack(ctx, &execConfig, packtype, path, filters)
 			if err != nil {
 				return rr, wfapi.ErrorWarePack(path, err)
 			}
@@ -1110,7 +1651,21 @@ func execFormula(ctx context.Context, cfg internalConfig) (wfapi.RunRecord, erro
 				color.HiBlueString("wareId"),
 				color.WhiteString(wareId.String()))
 		case gather.From.SandboxVar != nil:
-			panic("SandboxVar output type not supported")
+			// gatherVars has already checked that this is a script action
+			lit, err := readGatheredVar(filepath.Join(runPath, "script"), *gather.From.SandboxVar)
+			if err != nil {
+				return rr, err
+			}
+			// results are logged and memoized, so they mustn't carry secrets
+			lit = wfapi.Literal(execConfig.redact(string(lit)))
+			rr.Results.Keys = append(rr.Results.Keys, name)
+			rr.Results.Values[name] = wfapi.FormulaInputSimple{Literal: &lit}
+			logger.Info(LOG_TAG, "gathered %q:\t%s = %s\t%s = %s",
+				name,
+				color.HiBlueString("var"),
+				color.WhiteString("$"+string(*gather.From.SandboxVar)),
+				color.HiBlueString("literal"),
+				color.WhiteString(string(lit)))
 		default:
 			return rr, wfapi.ErrorFormulaInvalid(fmt.Sprintf("invalid gather directive provided for output %q", name))
 		}
@@ -1119,6 +1674,9 @@ func execFormula(ctx context.Context, cfg internalConfig) (wfapi.RunRecord, erro
 	logger.PrintRunRecord(LOG_TAG, rr, false)
 	logger.Info(LOG_TAG_END, "")
 
+	if err := cfg.recordHistory(ctx, rr); err != nil {
+		return rr, err
+	}
 	if err := cfg.storeMemo(ctx, rr); err != nil {
 		return rr, err
 	}
@@ -1131,11 +1689,15 @@ func execFormula(ctx context.Context, cfg internalConfig) (wfapi.RunRecord, erro
 // Errors:
 //
 //     - warpforge-error-executor-failed -- when the execution step of the formula fails
+//     - warpforge-error-action-failed -- when the action of the formula exits non-zero
+//     - warpforge-error-action-timeout -- when the action of the formula runs for longer than its timeout
 //     - warpforge-error-formula-execution-failed -- when an error occurs during formula execution
 //     - warpforge-error-formula-invalid -- when an invalid formula is provided
 //     - warpforge-error-serialization -- when serialization or deserialization of a memo fails
 //     - warpforge-error-ware-pack -- when a ware pack operation fails for a formula output
 //     - warpforge-error-ware-unpack -- when a ware unpack operation fails for a formula input
+//     - warpforge-error-missing -- when a variable gathered as an output was not set by the action
+//     - warpforge-error-not-hermetic -- when strict mode is enabled, and the formula is not hermetic
 func Exec(ctx context.Context, cfg ExecConfig, root *workspace.Workspace, frmCtx wfapi.FormulaAndContext, frmCfg wfapi.FormulaExecConfig) (result wfapi.RunRecord, err error) {
 	ctx, span := tracing.StartFn(ctx, "Exec")
 	defer func() { tracing.EndWithStatus(span, err) }()
@@ -1,17 +1,23 @@
 package formulaexec
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/codec/json"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/serum-errors/go-serum"
 	"github.com/warpfork/go-testmark"
 
+	"github.com/warptools/warpforge/pkg/logging"
 	_ "github.com/warptools/warpforge/pkg/testutil"
 	"github.com/warptools/warpforge/pkg/workspace"
 	"github.com/warptools/warpforge/wfapi"
@@ -55,7 +61,13 @@ func evaluateDoc(t *testing.T, doc *testmark.Document) {
 					qt.Assert(t, err, qt.IsNil)
 
 					rr, err := Exec(ctx, wfCfg, rootWs, frmAndCtx, wfapi.FormulaExecConfig{})
-					qt.Assert(t, err, qt.IsNil)
+					// if an expected error code is present, check for it
+					if dir.Children["errorcode"] != nil {
+						code := strings.TrimSpace(string(dir.Children["errorcode"].Hunk.Body))
+						qt.Assert(t, serum.Code(err), qt.Equals, code)
+					} else {
+						qt.Assert(t, err, qt.IsNil)
+					}
 
 					rrSerial, err := ipld.Marshal(json.Encode, &rr, wfapi.TypeSystem.TypeByName("RunRecord"))
 					qt.Assert(t, err, qt.IsNil)
@@ -73,6 +85,13 @@ func evaluateDoc(t *testing.T, doc *testmark.Document) {
 						rrExample.Guid = "abcd"
 						rr.Time = 1234
 						rrExample.Time = 1234
+						for _, r := range []*wfapi.RunRecord{&rr, &rrExample} {
+							if r.ScriptEntries != nil {
+								for i := range *r.ScriptEntries {
+									(*r.ScriptEntries)[i].Duration = 0
+								}
+							}
+						}
 						// assert the example is correct
 						qt.Assert(t, rr, qt.CmpEquals(), rrExample)
 					}
@@ -101,3 +120,359 @@ func TestFormulaScriptFixtures(t *testing.T) {
 
 	evaluateDoc(t, doc)
 }
+
+// The fake executor runs nothing, so this works even without container privileges.
+// Outputs get placeholder ware IDs, which must be repeatable, and must never be memoized.
+func TestFakeExecutor(t *testing.T) {
+	doc, err := testmark.ReadFile("../../examples/110-formula-usage/example-formula-exec.md")
+	qt.Assert(t, err, qt.IsNil)
+	doc.BuildDirIndex()
+	serial := doc.DirEnt.Children["pack"].Children["formula"].Hunk.Body
+
+	ctx := context.Background()
+	wfCfg, rootWs := newTestConfig(t)
+	wfCfg.Executor = ExecutorFake
+
+	var results []wfapi.RunRecord
+	for i := 0; i < 2; i++ {
+		frmAndCtx := wfapi.FormulaAndContext{}
+		_, err := ipld.Unmarshal(serial, json.Decode, &frmAndCtx, wfapi.TypeSystem.TypeByName("FormulaAndContext"))
+		qt.Assert(t, err, qt.IsNil)
+		rr, err := Exec(ctx, wfCfg, rootWs, frmAndCtx, wfapi.FormulaExecConfig{})
+		qt.Assert(t, err, qt.IsNil)
+		results = append(results, rr)
+	}
+
+	qt.Assert(t, results[0].Exitcode, qt.Equals, 0)
+	qt.Assert(t, results[0].Results.Values["test"].WareID, qt.IsNotNil)
+	qt.Assert(t, strings.HasPrefix(results[0].Results.Values["test"].WareID.Hash, "fake"), qt.IsTrue)
+	qt.Assert(t, results[1].Results.Values["test"], qt.DeepEquals, results[0].Results.Values["test"])
+	// a memoized result would have had the same guid.
+	qt.Assert(t, results[1].Guid, qt.Not(qt.Equals), results[0].Guid)
+}
+
+// Explaining a formula resolves it and describes its container, but runs nothing and produces no results.
+func TestExplain(t *testing.T) {
+	doc, err := testmark.ReadFile("../../examples/110-formula-usage/example-formula-exec.md")
+	qt.Assert(t, err, qt.IsNil)
+	doc.BuildDirIndex()
+	serial := doc.DirEnt.Children["pack"].Children["formula"].Hunk.Body
+	runrecord := wfapi.RunRecord{}
+	_, err = ipld.Unmarshal(doc.DirEnt.Children["pack"].Children["runrecord"].Hunk.Body, json.Decode, &runrecord, wfapi.TypeSystem.TypeByName("RunRecord"))
+	qt.Assert(t, err, qt.IsNil)
+
+	var out, errOut bytes.Buffer
+	ctx := logging.NewLogger(&out, &errOut, false, false, false).WithContext(context.Background())
+	wfCfg, rootWs := newTestConfig(t)
+	wfCfg.Executor = ExecutorFake
+
+	frmAndCtx := wfapi.FormulaAndContext{}
+	_, err = ipld.Unmarshal(serial, json.Decode, &frmAndCtx, wfapi.TypeSystem.TypeByName("FormulaAndContext"))
+	qt.Assert(t, err, qt.IsNil)
+	rr, err := Exec(ctx, wfCfg, rootWs, frmAndCtx, wfapi.FormulaExecConfig{Explain: true, ExplainSpec: true})
+	qt.Assert(t, err, qt.IsNil)
+
+	qt.Check(t, rr.FormulaID, qt.Equals, runrecord.FormulaID)
+	qt.Check(t, rr.Results.Values, qt.HasLen, 0)
+	explanation := errOut.String()
+	qt.Check(t, explanation, qt.Contains, "nothing will be run")
+	qt.Check(t, explanation, qt.Contains, `["/bin/sh" "-c" "mkdir /out; echo hello from warpforge! > /out/test"]`)
+	qt.Check(t, explanation, qt.Contains, "config.json:")
+	qt.Check(t, explanation, qt.Not(qt.Contains), "RunRecord")
+}
+
+// Limits are left out of the formula ID, so changing them doesn't change it.
+func TestLimitsFormulaID(t *testing.T) {
+	doc, err := testmark.ReadFile("../../examples/110-formula-usage/example-formula-exec.md")
+	qt.Assert(t, err, qt.IsNil)
+	doc.BuildDirIndex()
+	serial := doc.DirEnt.Children["pack"].Children["formula"].Hunk.Body
+	runrecord := wfapi.RunRecord{}
+	_, err = ipld.Unmarshal(doc.DirEnt.Children["pack"].Children["runrecord"].Hunk.Body, json.Decode, &runrecord, wfapi.TypeSystem.TypeByName("RunRecord"))
+	qt.Assert(t, err, qt.IsNil)
+
+	ctx := context.Background()
+	wfCfg, rootWs := newTestConfig(t)
+	wfCfg.Executor = ExecutorFake
+
+	frmAndCtx := wfapi.FormulaAndContext{}
+	_, err = ipld.Unmarshal(serial, json.Decode, &frmAndCtx, wfapi.TypeSystem.TypeByName("FormulaAndContext"))
+	qt.Assert(t, err, qt.IsNil)
+	timeout, pids := 30, 100
+	frmAndCtx.Formula.Formula.Limits = &wfapi.ResourceLimits{Timeout: &timeout, Pids: &pids}
+	rr, err := Exec(ctx, wfCfg, rootWs, frmAndCtx, wfapi.FormulaExecConfig{Explain: true})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, rr.FormulaID, qt.Equals, runrecord.FormulaID)
+	qt.Check(t, frmAndCtx.Formula.Formula.Limits, qt.IsNotNil)
+}
+
+// Keeping the ownership of files is part of the formula ID, as if the formula had said to keep it,
+// but only applies when the executor supports user namespaces.
+func TestOwnershipFormulaID(t *testing.T) {
+	parse := func(serial string) wfapi.Formula {
+		formula := wfapi.Formula{}
+		_, err := ipld.Unmarshal([]byte(serial), json.Decode, &formula, wfapi.TypeSystem.TypeByName("Formula"))
+		qt.Assert(t, err, qt.IsNil)
+		return formula
+	}
+	idSerial := func(formula wfapi.Formula) string {
+		serial, err := ipld.Marshal(json.Encode, &formula, wfapi.TypeSystem.TypeByName("Formula"))
+		qt.Assert(t, err, qt.IsNil)
+		return string(serial)
+	}
+	formula := parse(`{
+		"inputs": {
+			"/": "ware:tar:4z9DCTxoKkStqXQRwtf9nimpfQQ36dbndDsAPCQgECfbXt3edanUrsVKCjE9TkX2v9",
+			"/src": {"basis": "ware:tar:4z9DCTxoKkStqXQRwtf9nimpfQQ36dbndDsAPCQgECfbXt3edanUrsVKCjE9TkX2v9", "filters": {"uid": "0", "mtime": "@0"}},
+			"$X": "literal:x"
+		},
+		"action": {"exec": {"command": ["true"]}},
+		"outputs": {"out": {"from": "/out", "packtype": "tar"}}
+	}`)
+	explicit := parse(`{
+		"inputs": {
+			"/": {"basis": "ware:tar:4z9DCTxoKkStqXQRwtf9nimpfQQ36dbndDsAPCQgECfbXt3edanUrsVKCjE9TkX2v9", "filters": {"gid": "keep", "uid": "keep"}},
+			"/src": {"basis": "ware:tar:4z9DCTxoKkStqXQRwtf9nimpfQQ36dbndDsAPCQgECfbXt3edanUrsVKCjE9TkX2v9", "filters": {"gid": "keep", "mtime": "@0", "uid": "0"}},
+			"$X": "literal:x"
+		},
+		"action": {"exec": {"command": ["true"]}},
+		"outputs": {"out": {"from": "/out", "packtype": "tar", "filters": {"gid": "keep", "uid": "keep"}}}
+	}`)
+	original := idSerial(formula)
+	qt.Check(t, idSerial(formulaForID(formula, true)), qt.Equals, idSerial(formulaForID(explicit, false)))
+	qt.Check(t, idSerial(formulaForID(formula, false)), qt.Not(qt.Equals), idSerial(formulaForID(formula, true)))
+	qt.Check(t, idSerial(formula), qt.Equals, original) // the formula itself is left as it was
+
+	// the fake executor has no user namespaces, so mappings don't apply, and the ID is unchanged
+	doc, err := testmark.ReadFile("../../examples/110-formula-usage/example-formula-exec.md")
+	qt.Assert(t, err, qt.IsNil)
+	doc.BuildDirIndex()
+	runrecord := wfapi.RunRecord{}
+	_, err = ipld.Unmarshal(doc.DirEnt.Children["pack"].Children["runrecord"].Hunk.Body, json.Decode, &runrecord, wfapi.TypeSystem.TypeByName("RunRecord"))
+	qt.Assert(t, err, qt.IsNil)
+	frmAndCtx := wfapi.FormulaAndContext{}
+	_, err = ipld.Unmarshal(doc.DirEnt.Children["pack"].Children["formula"].Hunk.Body, json.Decode, &frmAndCtx, wfapi.TypeSystem.TypeByName("FormulaAndContext"))
+	qt.Assert(t, err, qt.IsNil)
+	wfCfg, rootWs := newTestConfig(t)
+	wfCfg.Executor = ExecutorFake
+	wfCfg.UidMappings = []specs.LinuxIDMapping{{ContainerID: 0, HostID: 1000, Size: 1}}
+	wfCfg.GidMappings = []specs.LinuxIDMapping{{ContainerID: 0, HostID: 1000, Size: 1}}
+	rr, err := Exec(context.Background(), wfCfg, rootWs, frmAndCtx, wfapi.FormulaExecConfig{Explain: true})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, rr.FormulaID, qt.Equals, runrecord.FormulaID)
+}
+
+// Literals become environment variables, or read-only files, and variables can only be literals.
+func TestLiteralInputs(t *testing.T) {
+	ctx := context.Background()
+	wfCfg, rootWs := newTestConfig(t)
+	wfCfg.Executor = ExecutorFake
+	exec := func(inputs string) (wfapi.RunRecord, error) {
+		frmAndCtx := wfapi.FormulaAndContext{}
+		serial := `{"formula": {"formula.v1": {"inputs": ` + inputs + `, "action": {"exec": {"command": ["true"]}}, "outputs": {}}}}`
+		_, err := ipld.Unmarshal([]byte(serial), json.Decode, &frmAndCtx, wfapi.TypeSystem.TypeByName("FormulaAndContext"))
+		qt.Assert(t, err, qt.IsNil)
+		return Exec(ctx, wfCfg, rootWs, frmAndCtx, wfapi.FormulaExecConfig{})
+	}
+
+	t.Run("files", func(t *testing.T) {
+		single, err := exec(`{"/etc/greeting": "literal:hello\nworld\n"}`)
+		qt.Assert(t, err, qt.IsNil)
+		lines, err := exec(`{"/etc/greeting": ["hello", "world"]}`)
+		qt.Assert(t, err, qt.IsNil)
+		// the same content, but written differently, so a different formula.
+		qt.Check(t, lines.FormulaID, qt.Not(qt.Equals), single.FormulaID)
+	})
+	t.Run("variable", func(t *testing.T) {
+		_, err := exec(`{"$GREETING": "ware:tar:4z9DCTxoKkStqXQRwtf9nimpfQQ36dbndDsAPCQgECfbXt3edanUrsVKCjE9TkX2v9"}`)
+		qt.Check(t, serum.Code(err), qt.Equals, wfapi.ECodeFormulaInvalid)
+	})
+}
+
+// Inputs within another input's path are mounted after it, so they aren't shadowed by it,
+// whatever order the inputs happen to be in.
+func TestInputMountOrder(t *testing.T) {
+	var out, errOut bytes.Buffer
+	ctx := logging.NewLogger(&out, &errOut, false, false, false).WithContext(context.Background())
+	wfCfg, rootWs := newTestConfig(t)
+	wfCfg.Executor = ExecutorFake
+	serial := `{"formula": {"formula.v1": {"inputs": {
+		"/src/.npmrc": "literal:registry=https://registry.example.org/",
+		"/src": "ware:tar:5vqDjb3DaK5TrXT1jtNkcrqF5XQZqBCnj6BLMEqEaAd9bJR1gbyUtCgHiAcbKGBBqr",
+		"/root/.cache/npm": "cache:npm",
+		"/root": "ware:tar:5vqDjb3DaK5TrXT1jtNkcrqF5XQZqBCnj6BLMEqEaAd9bJR1gbyUtCgHiAcbKGBBqr",
+		"/": "ware:tar:4z9DCTxoKkStqXQRwtf9nimpfQQ36dbndDsAPCQgECfbXt3edanUrsVKCjE9TkX2v9"
+	}, "action": {"exec": {"command": ["true"]}}, "outputs": {}}}}`
+
+	for i := 0; i < 10; i++ {
+		frmAndCtx := wfapi.FormulaAndContext{}
+		_, err := ipld.Unmarshal([]byte(serial), json.Decode, &frmAndCtx, wfapi.TypeSystem.TypeByName("FormulaAndContext"))
+		qt.Assert(t, err, qt.IsNil)
+		errOut.Reset()
+		_, err = Exec(ctx, wfCfg, rootWs, frmAndCtx, wfapi.FormulaExecConfig{Explain: true, ExplainSpec: true})
+		qt.Assert(t, err, qt.IsNil)
+		config := errOut.String()
+		position := func(dest string) int {
+			i := strings.Index(config, `"destination": "`+dest+`"`)
+			qt.Assert(t, i, qt.Not(qt.Equals), -1, qt.Commentf("no mount at %s", dest))
+			return i
+		}
+		qt.Check(t, position("/") < position("/src"), qt.IsTrue)
+		qt.Check(t, position("/src") < position("/src/.npmrc"), qt.IsTrue)
+		qt.Check(t, position("/root") < position("/root/.cache/npm"), qt.IsTrue)
+	}
+}
+
+func TestSortedPorts(t *testing.T) {
+	inputs := map[wfapi.SandboxPort]wfapi.FormulaInput{}
+	for _, name := range []string{"/src/.npmrc", "$PATH", "/", "/src", "/bin", "/src/lib/x"} {
+		port := wfapi.SandboxPort{}
+		_, err := ipld.Unmarshal([]byte(`"`+name+`"`), json.Decode, &port, wfapi.TypeSystem.TypeByName("SandboxPort"))
+		qt.Assert(t, err, qt.IsNil)
+		inputs[port] = wfapi.FormulaInput{}
+	}
+	var order []string
+	for _, port := range sortedPorts(inputs) {
+		order = append(order, PortString(port))
+	}
+	qt.Check(t, order, qt.DeepEquals, []string{"$PATH", "/", "/bin", "/src", "/src/.npmrc", "/src/lib/x"})
+}
+
+func TestMakeLiteralMount(t *testing.T) {
+	rc := &runcConfig{runPath: t.TempDir()}
+	mnt, err := rc.makeLiteralMount(context.Background(), wfapi.Literal("registry=https://registry.example.org/\n"), "/root/.npmrc")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, mnt.Destination, qt.Equals, "/root/.npmrc")
+	qt.Check(t, mnt.Options, qt.Contains, "ro")
+	qt.Check(t, filepath.Dir(mnt.Source), qt.Equals, rc.literalsPath())
+	content, err := os.ReadFile(mnt.Source)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(content), qt.Equals, "registry=https://registry.example.org/\n")
+	fi, err := os.Stat(mnt.Source)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, fi.Mode().Perm(), qt.Equals, os.FileMode(0444))
+}
+
+// Wares are stacked bottom first, which overlayfs wants listed top first.
+func TestMakeWareMount(t *testing.T) {
+	rc := &runcConfig{runPath: t.TempDir(), cachePath: t.TempDir()}
+	base := wfapi.WareID{Packtype: "tar", Hash: "4z9DCTxoKkStqXQRwtf9nimpfQQ36dbndDsAPCQgECfbXt3edanUrsVKCjE9TkX2v9"}
+	addon := wfapi.WareID{Packtype: "tar", Hash: "5vqDjb3DaK5TrXT1jtNkcrqF5XQZqBCnj6BLMEqEaAd9bJR1gbyUtCgHiAcbKGBBqr"}
+	for _, wareId := range []wfapi.WareID{base, addon} {
+		qt.Assert(t, os.MkdirAll(filepath.Join(wareCachePath(rc.cachePath, wareId), "usr", "lib"), 0755), qt.IsNil)
+	}
+	qt.Assert(t, os.Symlink("/etc", filepath.Join(wareCachePath(rc.cachePath, addon), "etc")), qt.IsNil)
+	lowerdir := func(m specs.Mount) string {
+		v, _ := mountOption(m, "lowerdir")
+		return v
+	}
+
+	whole, err := rc.makeWareMount(context.Background(), []wfapi.WareID{base}, "", "/", nil, wfapi.FilterMap{})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, lowerdir(whole), qt.Equals, wareCachePath(rc.cachePath, base))
+
+	stacked, err := rc.makeWareMount(context.Background(), []wfapi.WareID{base, addon}, "/usr/lib", "/usr/lib", nil, wfapi.FilterMap{})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, lowerdir(stacked), qt.Equals,
+		filepath.Join(wareCachePath(rc.cachePath, addon), "usr/lib")+":"+filepath.Join(wareCachePath(rc.cachePath, base), "usr/lib"))
+	upper, _ := mountOption(stacked, "upperdir")
+	wholeUpper, _ := mountOption(whole, "upperdir")
+	qt.Check(t, upper, qt.Not(qt.Equals), wholeUpper)
+
+	for _, subpath := range []string{"../usr", "usr/share", "etc"} {
+		_, err := rc.makeWareMount(context.Background(), []wfapi.WareID{base, addon}, subpath, "/x", nil, wfapi.FilterMap{})
+		qt.Check(t, serum.Code(err), qt.Equals, wfapi.ECodeFormulaInvalid, qt.Commentf("subpath %q", subpath))
+	}
+}
+
+// unpackRecorder pretends to unpack wares, recording the filters of each unpack,
+// and reporting a different ware ID whenever there were filters other than the defaults.
+type unpackRecorder struct {
+	fakeExecutor
+	filters []string
+}
+
+func (e *unpackRecorder) Run(ctx context.Context, rc *runcConfig, logWriter io.Writer) (string, int, error) {
+	wareId := rc.spec.Process.Args[len(rc.spec.Process.Args)-2]
+	for _, arg := range rc.spec.Process.Args {
+		if filters := strings.TrimPrefix(arg, "--filters="); filters != arg {
+			e.filters = append(e.filters, filters)
+			if filters != unpackFilterArg(wfapi.FilterMap{}, false) {
+				wareId = "tar:filtered"
+			}
+		}
+	}
+	return fakeRioOutput(wareId), 0, nil
+}
+
+// A ware that's already in the cache is still unpacked when there are filters,
+// and the filtered tree is what gets mounted.
+func TestMakeWareMountFilters(t *testing.T) {
+	executor := &unpackRecorder{}
+	rc := &runcConfig{
+		runPath:       t.TempDir(),
+		cachePath:     t.TempDir(),
+		warehousePath: t.TempDir(),
+		executor:      executor,
+		spec:          specs.Spec{Process: &specs.Process{}},
+	}
+	wareId := wfapi.WareID{Packtype: "tar", Hash: "4z9DCTxoKkStqXQRwtf9nimpfQQ36dbndDsAPCQgECfbXt3edanUrsVKCjE9TkX2v9"}
+	qt.Assert(t, os.MkdirAll(wareCachePath(rc.cachePath, wareId), 0755), qt.IsNil)
+
+	mnt, err := rc.makeWareMount(context.Background(), []wfapi.WareID{wareId}, "", "/", nil, wfapi.FilterMap{})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, executor.filters, qt.HasLen, 0)
+	lowerdir, _ := mountOption(mnt, "lowerdir")
+	qt.Check(t, lowerdir, qt.Equals, wareCachePath(rc.cachePath, wareId))
+
+	filters := wfapi.FilterMap{Keys: []string{"mtime"}, Values: map[string]string{"mtime": "@1"}}
+	mnt, err = rc.makeWareMount(context.Background(), []wfapi.WareID{wareId}, "", "/", nil, filters)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, executor.filters, qt.DeepEquals, []string{"uid=0,gid=0,mtime=@1"})
+	lowerdir, _ = mountOption(mnt, "lowerdir")
+	qt.Check(t, lowerdir, qt.Equals, wareCachePath(rc.cachePath, wfapi.WareID{Packtype: "tar", Hash: "filtered"}))
+}
+
+func TestLayeredInputsInvalid(t *testing.T) {
+	ctx := context.Background()
+	wfCfg, rootWs := newTestConfig(t)
+	wfCfg.Executor = ExecutorFake
+	for _, inputs := range []string{
+		`{"/x": {"basis": "literal:hello", "subpath": "usr"}}`,
+		`{"/x": {"basis": "ware:tar:4z9DCTxoKkStqXQRwtf9nimpfQQ36dbndDsAPCQgECfbXt3edanUrsVKCjE9TkX2v9", "layers": ["literal:hello"]}}`,
+	} {
+		frmAndCtx := wfapi.FormulaAndContext{}
+		serial := `{"formula": {"formula.v1": {"inputs": ` + inputs + `, "action": {"exec": {"command": ["true"]}}, "outputs": {}}}}`
+		_, err := ipld.Unmarshal([]byte(serial), json.Decode, &frmAndCtx, wfapi.TypeSystem.TypeByName("FormulaAndContext"))
+		qt.Assert(t, err, qt.IsNil)
+		_, err = Exec(ctx, wfCfg, rootWs, frmAndCtx, wfapi.FormulaExecConfig{})
+		qt.Check(t, serum.Code(err), qt.Equals, wfapi.ECodeFormulaInvalid, qt.Commentf("inputs %s", inputs))
+	}
+}
+
+func TestCheckGatherPaths(t *testing.T) {
+	for _, tc := range []struct {
+		outputs string
+		valid   bool
+	}{
+		{`{"out": {"from": "/out", "packtype": "tar"}}`, true},
+		{`{"out": {"from": "/out", "packtype": "tar", "filters": {"uid": "keep", "mtime": "@0"}}}`, true},
+		{`{"out": {"from": "/out"}}`, false},
+		// git wares can be unpacked, but outputs can't be packed as them
+		{`{"out": {"from": "/out", "packtype": "git"}}`, false},
+		{`{"out": {"from": "/out", "packtype": "nope"}}`, false},
+		{`{"out": {"from": "/out", "packtype": "tar", "filters": {"nope": "keep"}}}`, false},
+		{`{"out": {"from": "/out", "packtype": "tar", "filters": {"setid": "nope"}}}`, false},
+	} {
+		formula := wfapi.Formula{}
+		serial := `{"inputs": {}, "action": {"exec": {"command": ["true"]}}, "outputs": ` + tc.outputs + `}`
+		_, err := ipld.Unmarshal([]byte(serial), json.Decode, &formula, wfapi.TypeSystem.TypeByName("Formula"))
+		qt.Assert(t, err, qt.IsNil)
+		err = checkGatherPaths(formula)
+		if tc.valid {
+			qt.Check(t, err, qt.IsNil, qt.Commentf("outputs %s", tc.outputs))
+		} else {
+			qt.Check(t, serum.Code(err), qt.Equals, wfapi.ECodeFormulaInvalid, qt.Commentf("outputs %s", tc.outputs))
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package formulaexec
+
+import (
+	"fmt"
+
+	"github.com/warptools/warpforge/pkg/workspace"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// StrictEnabled returns true if formulas and plots must be hermetic:
+// either because strict mode was asked for in the exec config,
+// or because the policy of the root workspace requires it.
+//
+// Errors:
+//
+//    - warpforge-error-io -- when the workspace's policy can't be read
+//    - warpforge-error-serialization -- when the workspace's policy can't be parsed
+func StrictEnabled(root *workspace.Workspace, frmCfg wfapi.FormulaExecConfig) (bool, error) {
+	if frmCfg.Strict {
+		return true, nil
+	}
+	if root == nil {
+		return false, nil
+	}
+	policy, err := root.GetPolicy()
+	if err != nil {
+		return false, err
+	}
+	return policy.GetStrict(), nil
+}
+
+// PortString returns a SandboxPort as it would appear in a formula:
+// either an absolute path, or a "$"-prefixed variable name.
+func PortString(port wfapi.SandboxPort) string {
+	switch {
+	case port.SandboxPath != nil:
+		return "/" + string(*port.SandboxPath)
+	case port.SandboxVar != nil:
+		return "$" + string(*port.SandboxVar)
+	default:
+		panic("unreachable")
+	}
+}
+
+// ActionViolations lists the ways in which an action is not hermetic.
+func ActionViolations(action wfapi.Action) []string {
+	if action.NetworkEnabled() {
+		return []string{"action has network access"}
+	}
+	return nil
+}
+
+// formulaViolations lists every way in which a formula (and the way it's being run) is not hermetic.
+// Mounts, secrets and cache mounts expose the host, network access exposes whatever is on the network,
+// and interactive stdin exposes whoever is at the keyboard -- none of which are captured by the formula ID.
+func formulaViolations(formula wfapi.Formula, frmCfg wfapi.FormulaExecConfig) []string {
+	var violations []string
+	for _, port := range formula.Inputs.Keys {
+		input := formula.Inputs.Values[port]
+		for _, layer := range input.Layers() {
+			switch {
+			case layer.Mount != nil:
+				violations = append(violations, fmt.Sprintf("input %q is a mount", PortString(port)))
+			case layer.Secret != nil:
+				violations = append(violations, fmt.Sprintf("input %q is a secret", PortString(port)))
+			case layer.CacheName != nil:
+				violations = append(violations, fmt.Sprintf("input %q is a cache mount", PortString(port)))
+			}
+		}
+	}
+	violations = append(violations, ActionViolations(formula.Action)...)
+	if frmCfg.Interactive {
+		violations = append(violations, "stdin is interactive")
+	}
+	return violations
+}
+
+// checkHermetic refuses to run a formula which is not hermetic, if strict mode is enabled.
+//
+// Errors:
+//
+//    - warpforge-error-not-hermetic -- when strict mode is enabled, and the formula is not hermetic
+//    - warpforge-error-io -- when the workspace's policy can't be read
+//    - warpforge-error-serialization -- when the workspace's policy can't be parsed
+func (cfg *internalConfig) checkHermetic(formula wfapi.Formula) error {
+	strict, err := StrictEnabled(cfg.RootWs, cfg.FormulaExecConfig)
+	if err != nil {
+		return err
+	}
+	if !strict {
+		return nil
+	}
+	if violations := formulaViolations(formula, cfg.FormulaExecConfig); len(violations) > 0 {
+		return wfapi.ErrorNotHermetic(violations)
+	}
+	return nil
+}
@@ -0,0 +1,367 @@
+package formulaexec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// An action with network access can be limited by network policies: its own, the workspace's, or both.
+// When any apply, a filtering HTTP proxy is run on the host for as long as the action runs,
+// and the action is pointed at it using the environment variables most tools honor.
+// The proxy refuses connections to hosts which aren't allowed by every policy,
+// and keeps track of every host the action tried to reach, so that it can be recorded in the RunRecord.
+//
+// The proxy is the only way out of the container: it gets its own network namespace,
+// with nothing in it but a loopback interface, so anything which doesn't use the proxy can't connect at all.
+// The proxy is made reachable from within that namespace by a createContainer hook (see NetworkProxyHook),
+// which the executor runs in the container's namespaces before the action starts.
+// The hook listens on containerProxyAddr, and hands the listening socket over to the proxy
+// through a unix socket in the run dir, so that the proxy on the host accepts connections made within the container.
+
+// containerProxyAddr is where the proxy is reachable within the container's network namespace.
+// Since the namespace is the container's own, any port will do.
+const containerProxyAddr = "127.0.0.1:3128"
+
+// proxyHandoffName is the name of the unix socket in the run dir which the hook hands the proxy's listener over through.
+const proxyHandoffName = "netproxy.sock"
+
+// proxyEnvVars are the environment variables which tell tools to use a proxy.
+// Both cases are set, since different tools look for different ones.
+var proxyEnvVars = []string{"HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY", "http_proxy", "https_proxy", "all_proxy"}
+
+// hopHeaders are the headers which only apply to a single connection, and so aren't passed on by the proxy.
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// networkPolicies returns the network policies which apply to the action of a formula.
+// Only actions with network access have any.
+//
+// Errors:
+//
+//    - warpforge-error-formula-invalid -- when the action has a network policy, but no network access
+//    - warpforge-error-io -- when the workspace's policy can't be read
+//    - warpforge-error-serialization -- when the workspace's policy can't be parsed
+func (cfg *internalConfig) networkPolicies(formula wfapi.Formula) ([]wfapi.NetworkPolicy, error) {
+	actionPolicy := formula.Action.GetNetworkPolicy()
+	if !formula.Action.NetworkEnabled() {
+		if actionPolicy != nil {
+			return nil, wfapi.ErrorFormulaInvalid("action has a network policy, but no network access")
+		}
+		return nil, nil
+	}
+	var policies []wfapi.NetworkPolicy
+	if actionPolicy != nil {
+		policies = append(policies, *actionPolicy)
+	}
+	if cfg.RootWs != nil {
+		policy, err := cfg.RootWs.GetPolicy()
+		if err != nil {
+			return nil, err
+		}
+		if policy.Network != nil {
+			policies = append(policies, *policy.Network)
+		}
+	}
+	return policies, nil
+}
+
+// useNetworkProxy gives the container its own network namespace, in which the only way out is the proxy,
+// and points the container's process at it, replacing any proxy it was given by its inputs.
+// hookBin is the warpforge binary which is run as the hook which makes the proxy reachable (see NetworkProxyHook),
+// and handoffPath is the socket which the proxy accepts the hook's listener on.
+func (rc *runcConfig) useNetworkProxy(hookBin string, handoffPath string) {
+	rc.spec.Linux.Namespaces = append(rc.spec.Linux.Namespaces, specs.LinuxNamespace{Type: specs.NetworkNamespace})
+	if rc.spec.Hooks == nil {
+		rc.spec.Hooks = &specs.Hooks{}
+	}
+	rc.spec.Hooks.CreateContainer = append(rc.spec.Hooks.CreateContainer, specs.Hook{
+		Path: hookBin,
+		Args: []string{"warpforge", NetworkProxyHookCommand, handoffPath, containerProxyAddr},
+	})
+	for _, v := range proxyEnvVars {
+		rc.setEnv(v, "http://"+containerProxyAddr)
+	}
+	rc.setEnv("NO_PROXY", "")
+	rc.setEnv("no_proxy", "")
+}
+
+// NetworkProxyHookCommand is the warpforge subcommand which runs NetworkProxyHook.
+const NetworkProxyHookCommand = "netproxy-hook"
+
+// NetworkProxyHook makes the network proxy of a run reachable within its container.
+// It's run by the executor as a createContainer hook, so it's in the container's network namespace,
+// but can still reach the run dir on the host.
+// It listens on addr, and hands the listening socket to the proxy over the unix socket at handoffPath.
+//
+// Errors:
+//
+//    - warpforge-error-io -- when listening fails, or the listener can't be handed over
+func NetworkProxyHook(handoffPath string, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return wfapi.ErrorIo("network proxy hook failed to listen", addr, err)
+	}
+	defer listener.Close()
+	f, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		return wfapi.ErrorIo("network proxy hook failed to get listener", addr, err)
+	}
+	defer f.Close()
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: handoffPath, Net: "unix"})
+	if err != nil {
+		return wfapi.ErrorIo("network proxy hook failed to connect to proxy", handoffPath, err)
+	}
+	defer conn.Close()
+	if _, _, err := conn.WriteMsgUnix([]byte{0}, syscall.UnixRights(int(f.Fd())), nil); err != nil {
+		return wfapi.ErrorIo("network proxy hook failed to hand over listener", handoffPath, err)
+	}
+	return nil
+}
+
+// networkProxy is an HTTP proxy which only connects to hosts allowed by its policies.
+// Plain HTTP requests are forwarded, and anything else is tunneled with CONNECT.
+type networkProxy struct {
+	policies []wfapi.NetworkPolicy
+	// dial makes the proxy's connections to the hosts it allows.
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	handoff   *net.UnixListener // accepts the listeners which hooks hand over (see NetworkProxyHook)
+	server    *http.Server
+	transport *http.Transport
+
+	mu       sync.Mutex
+	accessed map[string]bool       // host:port, and whether connecting was allowed
+	tunnels  map[net.Conn]struct{} // connections handed over to tunnels, which the server no longer tracks
+}
+
+func newNetworkProxy(policies []wfapi.NetworkPolicy) *networkProxy {
+	p := &networkProxy{
+		policies: policies,
+		dial:     (&net.Dialer{}).DialContext,
+		accessed: map[string]bool{},
+		tunnels:  map[net.Conn]struct{}{},
+	}
+	p.transport = &http.Transport{DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return p.dial(ctx, network, addr)
+	}}
+	p.server = &http.Server{Handler: p}
+	return p
+}
+
+// serve serves proxy requests made to a listener, until the proxy is closed.
+func (p *networkProxy) serve(listener net.Listener) {
+	go p.server.Serve(listener)
+}
+
+// acceptHandoffs listens on a unix socket for listeners handed over by hooks (see NetworkProxyHook),
+// and serves each of them, until the proxy is closed.
+// There may be more than one, since each container started for the action runs the hook (e.g. a debug shell).
+//
+// Errors:
+//
+//    - warpforge-error-io -- when the proxy can't listen on the socket
+func (p *networkProxy) acceptHandoffs(handoffPath string) error {
+	handoff, err := net.ListenUnix("unix", &net.UnixAddr{Name: handoffPath, Net: "unix"})
+	if err != nil {
+		return wfapi.ErrorIo("failed to start network proxy", handoffPath, err)
+	}
+	// the hook may run as whichever host user the container's root is mapped to
+	if err := os.Chmod(handoffPath, 0777); err != nil {
+		handoff.Close()
+		return wfapi.ErrorIo("failed to start network proxy", handoffPath, err)
+	}
+	p.handoff = handoff
+	go func() {
+		for {
+			conn, err := handoff.AcceptUnix()
+			if err != nil {
+				return // closed
+			}
+			listener, err := receiveListener(conn)
+			conn.Close()
+			if err != nil {
+				continue // the hook reports its own failure, which stops the container from starting
+			}
+			p.serve(listener)
+		}
+	}()
+	return nil
+}
+
+// receiveListener receives a listening socket sent over a unix socket connection by NetworkProxyHook.
+func receiveListener(conn *net.UnixConn) (net.Listener, error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) != 1 {
+		return nil, fmt.Errorf("expected a single socket, got %d messages", len(msgs))
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(fds) != 1 {
+		return nil, fmt.Errorf("expected a single socket, got %d", len(fds))
+	}
+	f := os.NewFile(uintptr(fds[0]), "netproxy")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// close stops the proxy, and ends any connections still going through it.
+func (p *networkProxy) close() {
+	if p.handoff != nil {
+		p.handoff.Close()
+	}
+	p.server.Close()
+	p.transport.CloseIdleConnections()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for conn := range p.tunnels {
+		conn.Close()
+	}
+}
+
+// check returns true if every policy allows connecting to host and port, and records the attempt.
+func (p *networkProxy) check(host string, port string) bool {
+	allowed := true
+	for _, policy := range p.policies {
+		if !policy.Allows(host, port) {
+			allowed = false
+			break
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.accessed[net.JoinHostPort(strings.ToLower(host), port)] = allowed
+	return allowed
+}
+
+// records returns every host that connecting to was attempted, sorted by host.
+func (p *networkProxy) records() []wfapi.NetworkAccessRecord {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	records := []wfapi.NetworkAccessRecord{}
+	for host, allowed := range p.accessed {
+		records = append(records, wfapi.NetworkAccessRecord{Host: host, Allowed: allowed})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Host < records[j].Host })
+	return records
+}
+
+func (p *networkProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.serveTunnel(w, r)
+		return
+	}
+	if r.URL.Host == "" {
+		http.Error(w, "warpforge network proxy: only proxy requests are served", http.StatusBadRequest)
+		return
+	}
+	port := r.URL.Port()
+	if port == "" {
+		port = "80"
+		if r.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	if !p.check(r.URL.Hostname(), port) {
+		http.Error(w, fmt.Sprintf("warpforge network proxy: %s is not allowed by the network policy", r.URL.Host), http.StatusForbidden)
+		return
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("warpforge network proxy: %s", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// serveTunnel connects a CONNECT request to its host, and then passes bytes both ways until either side is done.
+func (p *networkProxy) serveTunnel(w http.ResponseWriter, r *http.Request) {
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("warpforge network proxy: invalid host %q", r.Host), http.StatusBadRequest)
+		return
+	}
+	if !p.check(host, port) {
+		http.Error(w, fmt.Sprintf("warpforge network proxy: %s is not allowed by the network policy", r.Host), http.StatusForbidden)
+		return
+	}
+	upstream, err := p.dial(r.Context(), "tcp", r.Host)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("warpforge network proxy: %s", err), http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "warpforge network proxy: tunneling is not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	p.mu.Lock()
+	p.tunnels[conn] = struct{}{}
+	p.tunnels[upstream] = struct{}{}
+	p.mu.Unlock()
+	defer func() {
+		conn.Close()
+		upstream.Close()
+		p.mu.Lock()
+		delete(p.tunnels, conn)
+		delete(p.tunnels, upstream)
+		p.mu.Unlock()
+	}()
+
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		return
+	}
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, buf)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+}
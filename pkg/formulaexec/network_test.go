@@ -0,0 +1,172 @@
+package formulaexec
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/json"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/serum-errors/go-serum"
+
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// The proxy is tested against local stand-ins for the hosts it connects to:
+// whatever host a request is for, it's sent to one of the test servers.
+func TestNetworkProxy(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello from "+r.Host)
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+
+	proxy := newNetworkProxy([]wfapi.NetworkPolicy{
+		{Allow: []string{"*.example.org", "allowed.example.net"}},
+		{Allow: []string{"*.example.org", "allowed.example.net:80"}},
+	})
+	proxy.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		_, port, _ := net.SplitHostPort(addr)
+		if port == "443" {
+			return net.Dial(network, secure.Listener.Addr().String())
+		}
+		return net.Dial(network, plain.Listener.Addr().String())
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	qt.Assert(t, err, qt.IsNil)
+	proxy.serve(listener)
+	defer proxy.close()
+
+	proxyUrl, err := url.Parse("http://" + listener.Addr().String())
+	qt.Assert(t, err, qt.IsNil)
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyUrl),
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	get := func(u string) (int, string) {
+		resp, err := client.Get(u)
+		if err != nil {
+			return 0, err.Error()
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := get("http://www.example.org/")
+	qt.Check(t, status, qt.Equals, http.StatusOK)
+	qt.Check(t, body, qt.Equals, "hello from www.example.org")
+	status, body = get("https://www.example.org/")
+	qt.Check(t, status, qt.Equals, http.StatusOK)
+	qt.Check(t, body, qt.Equals, "hello from www.example.org")
+	status, _ = get("http://allowed.example.net/")
+	qt.Check(t, status, qt.Equals, http.StatusOK)
+	// only allowed on port 80 by the second policy
+	status, body = get("https://allowed.example.net/")
+	qt.Check(t, status, qt.Equals, 0)
+	qt.Check(t, body, qt.Contains, "Forbidden")
+	status, _ = get("http://example.com/")
+	qt.Check(t, status, qt.Equals, http.StatusForbidden)
+
+	qt.Check(t, proxy.records(), qt.DeepEquals, []wfapi.NetworkAccessRecord{
+		{Host: "allowed.example.net:443", Allowed: false},
+		{Host: "allowed.example.net:80", Allowed: true},
+		{Host: "example.com:80", Allowed: false},
+		{Host: "www.example.org:443", Allowed: true},
+		{Host: "www.example.org:80", Allowed: true},
+	})
+}
+
+// The hook hands its listener over to the proxy, which then serves connections made to it.
+// (Within a container, the hook's listener is in the container's network namespace.)
+func TestNetworkProxyHandoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer server.Close()
+	proxy := newNetworkProxy([]wfapi.NetworkPolicy{{Allow: []string{"example.org"}}})
+	proxy.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return net.Dial(network, server.Listener.Addr().String())
+	}
+	handoffPath := filepath.Join(t.TempDir(), proxyHandoffName)
+	qt.Assert(t, proxy.acceptHandoffs(handoffPath), qt.IsNil)
+	defer proxy.close()
+
+	// find a free port for the hook to listen on
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	qt.Assert(t, err, qt.IsNil)
+	addr := free.Addr().String()
+	qt.Assert(t, free.Close(), qt.IsNil)
+	qt.Assert(t, NetworkProxyHook(handoffPath, addr), qt.IsNil)
+
+	proxyUrl, err := url.Parse("http://" + addr)
+	qt.Assert(t, err, qt.IsNil)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)}}
+	resp, err := client.Get("http://example.org/")
+	qt.Assert(t, err, qt.IsNil)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	qt.Check(t, string(body), qt.Equals, "hello")
+	qt.Check(t, proxy.records(), qt.DeepEquals, []wfapi.NetworkAccessRecord{{Host: "example.org:80", Allowed: true}})
+}
+
+// The container gets its own network namespace, which the hook makes the proxy reachable in.
+func TestUseNetworkProxy(t *testing.T) {
+	rc := runcConfig{spec: specs.Spec{Process: &specs.Process{Env: []string{"HTTP_PROXY=http://elsewhere", "NO_PROXY=example.org"}}, Linux: &specs.Linux{}}}
+	rc.useNetworkProxy("/bin/warpforge", "/run/netproxy.sock")
+	qt.Check(t, rc.spec.Linux.Namespaces, qt.DeepEquals, []specs.LinuxNamespace{{Type: specs.NetworkNamespace}})
+	qt.Check(t, rc.spec.Hooks.CreateContainer, qt.DeepEquals, []specs.Hook{{
+		Path: "/bin/warpforge",
+		Args: []string{"warpforge", NetworkProxyHookCommand, "/run/netproxy.sock", containerProxyAddr},
+	}})
+	qt.Check(t, rc.spec.Process.Env, qt.Contains, "HTTP_PROXY=http://"+containerProxyAddr)
+	qt.Check(t, rc.spec.Process.Env, qt.Contains, "NO_PROXY=")
+}
+
+func TestNetworkPolicyExec(t *testing.T) {
+	ctx := context.Background()
+	wfCfg, rootWs := newTestConfig(t)
+	wfCfg.Executor = ExecutorFake
+	exec := func(action string) (wfapi.RunRecord, error) {
+		frmAndCtx := wfapi.FormulaAndContext{}
+		serial := `{"formula": {"formula.v1": {"inputs": {}, "action": {"exec": ` + action + `}, "outputs": {}}}}`
+		_, err := ipld.Unmarshal([]byte(serial), json.Decode, &frmAndCtx, wfapi.TypeSystem.TypeByName("FormulaAndContext"))
+		qt.Assert(t, err, qt.IsNil)
+		return Exec(ctx, wfCfg, rootWs, frmAndCtx, wfapi.FormulaExecConfig{})
+	}
+
+	t.Run("limited", func(t *testing.T) {
+		rr, err := exec(`{"command": ["true"], "network": true, "networkPolicy": {"allow": ["example.org"]}}`)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, rr.NetworkAccess, qt.IsNotNil)
+		qt.Check(t, *rr.NetworkAccess, qt.HasLen, 0)
+	})
+	t.Run("unlimited", func(t *testing.T) {
+		rr, err := exec(`{"command": ["true"], "network": true}`)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, rr.NetworkAccess, qt.IsNil)
+	})
+	t.Run("no network", func(t *testing.T) {
+		_, err := exec(`{"command": ["true"], "networkPolicy": {"allow": ["example.org"]}}`)
+		qt.Check(t, serum.Code(err), qt.Equals, wfapi.ECodeFormulaInvalid)
+	})
+	t.Run("bundle", func(t *testing.T) {
+		// nothing would enforce the policy when the bundle is run
+		frmAndCtx := wfapi.FormulaAndContext{}
+		serial := `{"formula": {"formula.v1": {"inputs": {}, "action": {"exec": {"command": ["true"], "network": true, "networkPolicy": {"allow": ["example.org"]}}}, "outputs": {}}}}`
+		_, err := ipld.Unmarshal([]byte(serial), json.Decode, &frmAndCtx, wfapi.TypeSystem.TypeByName("FormulaAndContext"))
+		qt.Assert(t, err, qt.IsNil)
+		err = ExportBundle(ctx, wfCfg, rootWs, frmAndCtx, wfapi.FormulaExecConfig{}, filepath.Join(t.TempDir(), "bundle"))
+		qt.Check(t, serum.Code(err), qt.Equals, wfapi.ECodeFormulaInvalid)
+	})
+}
@@ -0,0 +1,396 @@
+package formulaexec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/serum-errors/go-serum"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/warptools/warpforge/pkg/logging"
+	"github.com/warptools/warpforge/pkg/tracing"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// A Packer moves wares between the warehouse and the filesystem:
+// it unpacks wares into the cache so they can be mounted as inputs,
+// and packs paths within the container into the warehouse as outputs.
+//
+// Use packerFor to get the Packer for a packtype.
+type Packer interface {
+	// Unpack places the contents of a ware in the cache (see wareCachePath),
+	// and returns the ID it was cached under.
+	// Filters may mean this differs from the ware ID requested.
+	//
+	// Errors:
+	//
+	//     - warpforge-error-io -- when IO error occurs during setup
+	//     - warpforge-error-executor-failed -- when the container running the unpack fails
+	//     - warpforge-error-ware-unpack -- when the unpack operation fails
+	Unpack(ctx context.Context, rc *runcConfig, wareId wfapi.WareID, context *wfapi.FormulaContext, filters wfapi.FilterMap) (wfapi.WareID, error)
+
+	// Pack packs a path within the container into the warehouse as a ware of the given packtype.
+	// The filters are applied on top of the defaults, which set uid and gid to zero
+	// (or keep them, if ids are mapped into the container; see ownershipFilters).
+	//
+	// Errors:
+	//
+	//     - warpforge-error-executor-failed -- when the container running the pack fails
+	//     - warpforge-error-ware-pack -- when the pack operation fails
+	Pack(ctx context.Context, rc *runcConfig, packtype wfapi.Packtype, path string, filters wfapi.FilterMap) (wfapi.WareID, error)
+}
+
+// packerFor returns the Packer to use for wares of the given packtype.
+//
+// rio is used for every packtype for now.
+// An in-process packer for tar wares would save launching a container for each
+// input and output, but it has to produce exactly the ware IDs rio does,
+// which means reproducing rio's fileset hashing.
+// Until that can be checked against rio, we keep using it for tar as well.
+func packerFor(packtype wfapi.Packtype) Packer {
+	return rioPacker{}
+}
+
+// packFilters holds the packtypes which outputs can be packed as,
+// and for each of them, the filters which apply when packing it,
+// with a check of whether a value is valid for each filter.
+// Other packtypes (e.g. git) can only be unpacked.
+var packFilters = map[wfapi.Packtype]map[string]func(string) bool{
+	"tar": {
+		"uid":    isKeepOrId,
+		"gid":    isKeepOrId,
+		"mtime":  isKeepOrTime,
+		"sticky": func(v string) bool { return v == "keep" || v == "zero" },
+		"setid":  func(v string) bool { return v == "keep" || v == "zero" || v == "reject" },
+	},
+}
+
+// packablePacktypes returns the packtypes which outputs can be packed as, sorted.
+func packablePacktypes() []string {
+	packtypes := make([]string, 0, len(packFilters))
+	for packtype := range packFilters {
+		packtypes = append(packtypes, string(packtype))
+	}
+	sort.Strings(packtypes)
+	return packtypes
+}
+
+type RioResult struct {
+	WareId string `json:"wareID"`
+}
+type RioOutput struct {
+	Result RioResult `json:"result"`
+}
+
+// parseRioOutput finds the ware ID in the JSON lines output by rio.
+// An empty WareID is returned if rio didn't report one.
+//
+// Errors:
+//
+//    - warpforge-error-serialization -- when the output can't be parsed
+func parseRioOutput(outStr string) (wfapi.WareID, error) {
+	out := RioOutput{}
+	for _, line := range strings.Split(outStr, "\n") {
+		err := json.Unmarshal([]byte(line), &out)
+		if err != nil {
+			return wfapi.WareID{}, wfapi.ErrorSerialization("deserializing rio output", err)
+		}
+		if out.Result.WareId != "" {
+			// found wareId
+			break
+		}
+	}
+	if out.Result.WareId == "" {
+		return wfapi.WareID{}, nil
+	}
+	wareIdSplit := strings.SplitN(out.Result.WareId, ":", 2)
+	if len(wareIdSplit) != 2 {
+		return wfapi.WareID{}, wfapi.ErrorSerialization("deserializing rio output", fmt.Errorf("invalid ware ID %q", out.Result.WareId))
+	}
+	return wfapi.WareID{Packtype: wfapi.Packtype(wareIdSplit[0]), Hash: wareIdSplit[1]}, nil
+}
+
+// ownershipFilters returns the default uid and gid filters for packing and unpacking.
+// Without a uid/gid mapping, root is the only user within the container (see runc issue 1800),
+// so ownership is normalized to root.  With a mapping, the real ownership of files is kept.
+func ownershipFilters(keepOwnership bool) [][2]string {
+	if keepOwnership {
+		return [][2]string{{"uid", "keep"}, {"gid", "keep"}}
+	}
+	return [][2]string{{"uid", "0"}, {"gid", "0"}}
+}
+
+// filterArg returns filters in rio's "k=v,k=v" syntax.
+// The defaults come first, in order, overridden by any filters given.
+// They're followed by the other filters, in the order they were given (or sorted, if no order was given).
+func filterArg(defaults [][2]string, filters wfapi.FilterMap) string {
+	values := map[string]string{}
+	keys := []string{}
+	for _, kv := range defaults {
+		keys = append(keys, kv[0])
+		values[kv[0]] = kv[1]
+	}
+	filterKeys := filters.Keys
+	if len(filterKeys) == 0 {
+		for k := range filters.Values {
+			filterKeys = append(filterKeys, k)
+		}
+		sort.Strings(filterKeys)
+	}
+	for _, k := range filterKeys {
+		if _, exists := values[k]; !exists {
+			keys = append(keys, k)
+		}
+		values[k] = filters.Values[k]
+	}
+	args := make([]string, 0, len(keys))
+	for _, k := range keys {
+		args = append(args, k+"="+values[k])
+	}
+	return strings.Join(args, ",")
+}
+
+// packFilterArg returns the filters to pack with, in rio's "k=v,k=v" syntax.
+// Ownership defaults as described by ownershipFilters.
+func packFilterArg(filters wfapi.FilterMap, keepOwnership bool) string {
+	return filterArg(ownershipFilters(keepOwnership), filters)
+}
+
+// unpackFilterArg returns the filters to unpack with, in rio's "k=v,k=v" syntax.
+// Ownership defaults as described by ownershipFilters, and mtimes are those of the ware.
+func unpackFilterArg(filters wfapi.FilterMap, keepOwnership bool) string {
+	return filterArg(append(ownershipFilters(keepOwnership), [2]string{"mtime", "follow"}), filters)
+}
+
+// rioPacker runs `rio unpack` and `rio pack` in a container using the runcConfig's executor.
+type rioPacker struct{}
+
+// unpackAttempts is how many times unpacking from a remote warehouse is tried, before moving on to the next one.
+// Warehouses on the host are only tried once, since trying again wouldn't change anything.
+const unpackAttempts = 2
+
+// unpackRetryDelay is how long to wait before trying a remote warehouse again.
+var unpackRetryDelay = 2 * time.Second
+
+// unpackSources returns the warehouses to try fetching a ware from, in order:
+// the local warehouse, then those the formula's context gives for the ware.
+// Warehouses on the host which don't have the ware are left out,
+// unless that leaves nothing, in which case the local warehouse is still tried (and rio reports what's wrong).
+func unpackSources(rc *runcConfig, wareId wfapi.WareID, context *wfapi.FormulaContext) []wfapi.WarehouseAddr {
+	local := wfapi.WarehouseAddr("ca+file://" + rc.warehousePath)
+	candidates := []wfapi.WarehouseAddr{local}
+	if context != nil {
+		for _, addr := range context.WarehouseAddrs(wareId) {
+			if addr != local {
+				candidates = append(candidates, addr)
+			}
+		}
+	}
+	var sources []wfapi.WarehouseAddr
+	for _, addr := range candidates {
+		proto, hostPath, _ := strings.Cut(string(addr), "://")
+		switch proto {
+		case "ca+file":
+			if !wareInWarehouseDir(hostPath, wareId) {
+				continue
+			}
+		case "file":
+			if _, err := os.Stat(hostPath); err != nil {
+				continue
+			}
+		}
+		sources = append(sources, addr)
+	}
+	if len(sources) == 0 {
+		return []wfapi.WarehouseAddr{local}
+	}
+	return sources
+}
+
+// Unpack tries each warehouse the ware could be fetched from in turn (see unpackSources),
+// running `rio unpack` with no placer, which unpacks the ware into the cache and stops.
+// Remote warehouses are tried again (see unpackAttempts) before moving on to the next,
+// so that one flaky or dead mirror doesn't fail the whole run.
+// The warehouse which served the ware is logged.
+//
+// Errors:
+//
+//     - warpforge-error-io -- when IO error occurs during setup
+//     - warpforge-error-executor-failed -- when runc execution of `rio unpack` fails
+//     - warpforge-error-ware-unpack -- when `rio unpack` fails for every warehouse
+func (rioPacker) Unpack(ctx context.Context, rc *runcConfig, wareId wfapi.WareID, context *wfapi.FormulaContext, filters wfapi.FilterMap) (wfapi.WareID, error) {
+	ctx, span := tracing.Start(ctx, "rioUnpack")
+	defer span.End()
+	logger := logging.Ctx(ctx)
+
+	baseMounts := rc.spec.Mounts
+	defer func() { rc.spec.Mounts = baseMounts }()
+
+	sources := unpackSources(rc, wareId, context)
+	var lastErr error
+	for i, source := range sources {
+		attempts := unpackAttempts
+		if proto, _, _ := strings.Cut(string(source), "://"); proto == "ca+file" || proto == "file" {
+			attempts = 1
+		}
+		for attempt := 1; attempt <= attempts; attempt++ {
+			if attempt > 1 {
+				select {
+				case <-ctx.Done():
+					return wfapi.WareID{}, wfapi.ErrorWareUnpack(wareId, ctx.Err())
+				case <-time.After(unpackRetryDelay):
+				}
+			}
+			rc.spec.Mounts = append([]specs.Mount{}, baseMounts...)
+			cacheWareId, err := rc.rioUnpackFrom(ctx, wareId, source, i, filters)
+			if err == nil {
+				logger.Info(LOG_TAG, "unpacked %s from %s", wareId, source)
+				span.SetAttributes(attribute.String(tracing.AttrKeyWarpforgeWarehouse, string(source)))
+				return cacheWareId, nil
+			}
+			if serum.Code(err) != wfapi.ECodeWareUnpack {
+				// Error Codes -= warpforge-error-ware-unpack
+				return wfapi.WareID{}, err
+			}
+			lastErr = err
+			if len(sources) > 1 || attempts > 1 {
+				logger.Info(LOG_TAG, "failed to unpack %s from %s (attempt %d of %d): %s", wareId, source, attempt, attempts, err)
+			}
+		}
+	}
+	if len(sources) == 1 {
+		return wfapi.WareID{}, lastErr
+	}
+	tried := make([]string, len(sources))
+	for i, source := range sources {
+		tried[i] = string(source)
+	}
+	return wfapi.WareID{}, wfapi.ErrorWareUnpack(wareId, fmt.Errorf("no warehouse could provide it (tried %s): %w", strings.Join(tried, ", "), lastErr))
+}
+
+// rioUnpackFrom runs `rio unpack` for a ware, with a single warehouse as its source.
+// Warehouses on the host are mounted into the container (the local warehouse already is).
+// index tells apart the mounts of different warehouses.
+//
+// Errors:
+//
+//     - warpforge-error-io -- when IO error occurs during setup
+//     - warpforge-error-executor-failed -- when runc execution of `rio unpack` fails
+//     - warpforge-error-ware-unpack -- when `rio unpack` operation fails
+func (rc *runcConfig) rioUnpackFrom(ctx context.Context, wareId wfapi.WareID, addr wfapi.WarehouseAddr, index int, filters wfapi.FilterMap) (wfapi.WareID, error) {
+	src := string(addr)
+	proto, hostPath, ok := strings.Cut(src, "://")
+	if !ok {
+		return wfapi.WareID{}, wfapi.ErrorWareUnpack(wareId, fmt.Errorf("warehouse address %q has no protocol", addr))
+	}
+	switch {
+	case addr == wfapi.WarehouseAddr("ca+file://"+rc.warehousePath):
+		src = "ca+file://" + containerWarehousePath()
+	case proto == "ca+file" || proto == "file":
+		// a warehouse (or single ware) on the host, which needs to be mounted into the container
+		containerPath := filepath.Join(CONTAINER_BASE_PATH, "warehouses", fmt.Sprintf("%d", index))
+		mnt, err := rc.makeBindPathMount(ctx, hostPath, containerPath, true)
+		if err != nil {
+			return wfapi.WareID{}, err
+		}
+		rc.spec.Mounts = append(rc.spec.Mounts, mnt)
+		src = proto + "://" + containerPath
+	}
+
+	// unpacking may require fetching from a remote source, which may
+	// require network access. since we do this in an empty container,
+	// we need a resolv.conf for DNS configuration and /etc/ssl/certs
+	// for trusted CAs
+	rc.spec.Mounts = append(rc.spec.Mounts, getNetworkMounts()...)
+
+	// perform a rio unpack with no placer. this will unpack the contents
+	// to the RIO_CACHE dir and stop. we will then overlay mount the cache
+	// dir when executing the formula.
+	rc.spec.Process.Env = []string{"RIO_CACHE=" + containerCachePath(rc.keepOwnership)}
+	rc.spec.Process.Args = []string{
+		filepath.Join(containerBinPath(), "rio"),
+		"unpack",
+		fmt.Sprintf("--source=%s", src),
+		// unless ids are mapped, force uid and gid to zero since these are the values in the container
+		// note that the resulting hash used for placing this in the cache dir
+		// will end up being different if a tar doesn't only use uid/gid 0!
+		// these *must* be zero due to runc issue 1800 unless there's a mapping,
+		// otherwise we would choose a more sane value
+		"--filters=" + unpackFilterArg(filters, rc.keepOwnership),
+		"--placer=none",
+		"--format=json",
+		wareId.String(),
+		"/null",
+	}
+
+	outStr, _, err := rc.executor.Run(ctx, rc, nil)
+	if err != nil {
+		switch serum.Code(err) {
+		case wfapi.ECodeActionFailed:
+			// runc was fine, but rio itself failed
+			return wfapi.WareID{}, wfapi.ErrorWareUnpack(wareId, err)
+		default:
+			// Error Codes -= warpforge-error-action-failed
+			return wfapi.WareID{}, err
+		}
+	}
+	// UID/GID filters can mean the ware ID changes.
+	cacheWareId, err := parseRioOutput(outStr)
+	if err != nil {
+		return wfapi.WareID{}, wfapi.ErrorWareUnpack(wareId, err)
+	}
+	if cacheWareId.Hash == "" {
+		return wfapi.WareID{}, wfapi.ErrorWareUnpack(wareId, fmt.Errorf("rio unpack resulted in empty WareID output"))
+	}
+	return cacheWareId, nil
+}
+
+// Pack runs `rio pack` to pack a given path within a container as a ware in the host system's warehouse.
+//
+// Errors:
+//
+//    - warpforge-error-executor-failed -- if runc execution fails
+//    - warpforge-error-ware-pack -- if rio pack of ware fails
+func (rioPacker) Pack(ctx context.Context, rc *runcConfig, packtype wfapi.Packtype, path string, filters wfapi.FilterMap) (wfapi.WareID, error) {
+	ctx, span := tracing.Start(ctx, "rioPack")
+	defer span.End()
+	rc.spec.Process.Args = []string{
+		filepath.Join(containerBinPath(), "rio"),
+		"pack",
+		"--format=json",
+		"--filters=" + packFilterArg(filters, rc.keepOwnership),
+		"--target=ca+file://" + containerWarehousePath(),
+		string(packtype),
+		path,
+	}
+
+	outStr, _, err := rc.executor.Run(ctx, rc, nil)
+	if err != nil {
+		switch serum.Code(err) {
+		case wfapi.ECodeActionFailed:
+			// runc was fine, but rio itself failed
+			return wfapi.WareID{}, wfapi.ErrorWarePack(path, err)
+		default:
+			// Error Codes -= warpforge-error-action-failed
+			return wfapi.WareID{}, wfapi.ErrorExecutorFailed(fmt.Sprintf("invoke runc for rio pack of %s failed", path), err)
+		}
+	}
+
+	wareId, err := parseRioOutput(outStr)
+	if err != nil {
+		return wfapi.WareID{}, wfapi.ErrorWarePack(path, err)
+	}
+	if wareId.Hash == "" {
+		return wfapi.WareID{}, wfapi.ErrorWarePack(path, fmt.Errorf("empty WareID value from rio pack"))
+	}
+	span.AddEvent("Found ware ID", trace.WithAttributes(attribute.String(tracing.AttrKeyWarpforgeWareId, wareId.String())))
+	return wareId, nil
+}
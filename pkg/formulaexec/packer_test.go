@@ -0,0 +1,136 @@
+package formulaexec
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/serum-errors/go-serum"
+
+	"github.com/warptools/warpforge/wfapi"
+)
+
+func TestParseRioOutput(t *testing.T) {
+	t.Run("ware id after log lines", func(t *testing.T) {
+		out := `{"log":{"msg":"unpacking"}}` + "\n" + `{"result":{"wareID":"tar:4z9DCTxoKkStqXQRwtf9nimpfQQ36dbndDsAPCQgECfbXt3edanUrsVKCjE9TkX2v9"}}`
+		wareId, err := parseRioOutput(out)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, wareId, qt.Equals, wfapi.WareID{Packtype: "tar", Hash: "4z9DCTxoKkStqXQRwtf9nimpfQQ36dbndDsAPCQgECfbXt3edanUrsVKCjE9TkX2v9"})
+	})
+	t.Run("no ware id", func(t *testing.T) {
+		wareId, err := parseRioOutput(`{"log":{"msg":"unpacking"}}`)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, wareId.Hash, qt.Equals, "")
+	})
+	t.Run("garbage", func(t *testing.T) {
+		_, err := parseRioOutput("not json")
+		qt.Check(t, serum.Code(err), qt.Equals, wfapi.ECodeSerialization)
+	})
+	t.Run("ware id without packtype", func(t *testing.T) {
+		_, err := parseRioOutput(`{"result":{"wareID":"4z9DCT"}}`)
+		qt.Check(t, serum.Code(err), qt.Equals, wfapi.ECodeSerialization)
+	})
+}
+
+func TestPackFilterArg(t *testing.T) {
+	qt.Check(t, packFilterArg(wfapi.FilterMap{}, false), qt.Equals, "uid=0,gid=0")
+	qt.Check(t, packFilterArg(wfapi.FilterMap{
+		Keys:   []string{"mtime", "uid"},
+		Values: map[string]string{"mtime": "@0", "uid": "keep"},
+	}, false), qt.Equals, "uid=keep,gid=0,mtime=@0")
+	qt.Check(t, packFilterArg(wfapi.FilterMap{
+		Values: map[string]string{"sticky": "zero", "mtime": "keep"},
+	}, false), qt.Equals, "uid=0,gid=0,mtime=keep,sticky=zero")
+	qt.Check(t, packFilterArg(wfapi.FilterMap{}, true), qt.Equals, "uid=keep,gid=keep")
+	qt.Check(t, packFilterArg(wfapi.FilterMap{
+		Values: map[string]string{"uid": "1000"},
+	}, true), qt.Equals, "uid=1000,gid=keep")
+}
+
+func TestUnpackFilterArg(t *testing.T) {
+	qt.Check(t, unpackFilterArg(wfapi.FilterMap{}, false), qt.Equals, "uid=0,gid=0,mtime=follow")
+	qt.Check(t, unpackFilterArg(wfapi.FilterMap{}, true), qt.Equals, "uid=keep,gid=keep,mtime=follow")
+	qt.Check(t, unpackFilterArg(wfapi.FilterMap{
+		Values: map[string]string{"uid": "5", "setid": "zero"},
+	}, false), qt.Equals, "uid=5,gid=0,mtime=follow,setid=zero")
+}
+
+// sourceFailingExecutor fails `rio unpack` from some sources, and records every source it was run with.
+type sourceFailingExecutor struct {
+	fakeExecutor
+	failing map[string]bool
+	tried   []string
+}
+
+func (e *sourceFailingExecutor) Run(ctx context.Context, rc *runcConfig, logWriter io.Writer) (string, int, error) {
+	for _, arg := range rc.spec.Process.Args {
+		if src := strings.TrimPrefix(arg, "--source="); src != arg {
+			e.tried = append(e.tried, src)
+			if e.failing[src] {
+				return "", 1, wfapi.ErrorActionFailed(1)
+			}
+		}
+	}
+	return e.fakeExecutor.Run(ctx, rc, logWriter)
+}
+
+func TestUnpackFallback(t *testing.T) {
+	defer func(delay time.Duration) { unpackRetryDelay = delay }(unpackRetryDelay)
+	unpackRetryDelay = 0
+	ctx := context.Background()
+	wareId := wfapi.WareID{Packtype: "tar", Hash: "4z9DCTxoKkStqXQRwtf9nimpfQQ36dbndDsAPCQgECfbXt3edanUrsVKCjE9TkX2v9"}
+
+	// the ware is in one warehouse on the host, but not in the local warehouse or another one
+	hasWare, lacksWare := t.TempDir(), t.TempDir()
+	qt.Assert(t, os.MkdirAll(filepath.Join(hasWare, filepath.Dir(wareId.Subpath())), 0755), qt.IsNil)
+	qt.Assert(t, os.WriteFile(filepath.Join(hasWare, wareId.Subpath()), nil, 0644), qt.IsNil)
+	frmCtx := &wfapi.FormulaContext{}
+	frmCtx.AddWarehouses(wareId,
+		"ca+s3://dead.example.org/warehouse",
+		wfapi.WarehouseAddr("ca+file://"+lacksWare),
+		wfapi.WarehouseAddr("ca+file://"+hasWare),
+		"ca+https://unused.example.org/warehouse",
+	)
+	newRc := func(e Executor) *runcConfig {
+		return &runcConfig{executor: e, warehousePath: t.TempDir(), spec: specs.Spec{Process: &specs.Process{}}}
+	}
+	hostSource := "ca+file://" + filepath.Join(CONTAINER_BASE_PATH, "warehouses", "1")
+
+	t.Run("falls back", func(t *testing.T) {
+		e := &sourceFailingExecutor{failing: map[string]bool{"ca+s3://dead.example.org/warehouse": true}}
+		rc := newRc(e)
+		cacheWareId, err := rioPacker{}.Unpack(ctx, rc, wareId, frmCtx, wfapi.FilterMap{})
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, cacheWareId, qt.Equals, wareId)
+		// remote warehouses are retried, and those on the host are skipped if they don't have the ware
+		qt.Check(t, e.tried, qt.DeepEquals, []string{
+			"ca+s3://dead.example.org/warehouse",
+			"ca+s3://dead.example.org/warehouse",
+			hostSource,
+		})
+		qt.Check(t, rc.spec.Mounts, qt.HasLen, 0)
+	})
+	t.Run("all fail", func(t *testing.T) {
+		e := &sourceFailingExecutor{failing: map[string]bool{
+			"ca+s3://dead.example.org/warehouse":      true,
+			hostSource:                                true,
+			"ca+https://unused.example.org/warehouse": true,
+		}}
+		_, err := rioPacker{}.Unpack(ctx, newRc(e), wareId, frmCtx, wfapi.FilterMap{})
+		qt.Check(t, serum.Code(err), qt.Equals, wfapi.ECodeWareUnpack)
+		qt.Check(t, err.Error(), qt.Contains, "ca+s3://dead.example.org/warehouse")
+		qt.Check(t, e.tried, qt.HasLen, 5)
+	})
+	t.Run("local warehouse", func(t *testing.T) {
+		e := &sourceFailingExecutor{}
+		_, err := rioPacker{}.Unpack(ctx, newRc(e), wareId, nil, wfapi.FilterMap{})
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, e.tried, qt.DeepEquals, []string{"ca+file://" + containerWarehousePath()})
+	})
+}
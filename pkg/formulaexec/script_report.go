@@ -0,0 +1,185 @@
+package formulaexec
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+
+	"github.com/warptools/warpforge/pkg/logging"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// fifo within the script directory, which a script action reports the progress of its entries to
+func containerScriptStatusPath() string {
+	return filepath.Join(containerScriptPath(), "status")
+}
+
+// scriptPreludeSrc is the start of the script "run" file, before any entries.
+// The exit code of the last entry is kept, so that the reporting between entries can't change
+// what each entry sees as `$?`, nor the exit code of the script.
+const scriptPreludeSrc = "__warpforge_exitcode=0\n" +
+	"__warpforge_status() { return \"$1\"; }\n"
+
+// scriptEntrySrc returns the lines of the script "run" file which run one entry.
+// The entry is sourced, so it runs within the script's own shell process,
+// and its start and end are reported to the status fifo.
+// Every entry is run, whether or not the ones before it failed, as in any other shell script.
+func scriptEntrySrc(n int) string {
+	status := containerScriptStatusPath()
+	return fmt.Sprintf("printf 'start %d\\n' > %s\n", n, status) +
+		"__warpforge_status \"$__warpforge_exitcode\"\n" +
+		fmt.Sprintf(". %s\n", filepath.Join(containerScriptPath(), fmt.Sprintf("entry-%d", n))) +
+		"__warpforge_exitcode=$?\n" +
+		fmt.Sprintf("printf 'end %d %%d\\n' \"$__warpforge_exitcode\" > %s\n", n, status)
+}
+
+// scriptExitSrc is the end of the script "run" file, which exits with the exit code of the last entry.
+const scriptExitSrc = "exit \"$__warpforge_exitcode\"\n"
+
+// scriptReporter collects the progress of a script action's entries while it runs.
+// The script writes a line to a fifo as each entry starts and ends, and these are
+// timestamped as they're read -- so the script needs no tools of its own to time entries.
+type scriptReporter struct {
+	fifo   *os.File
+	done   chan struct{}
+	events []scriptEvent
+}
+
+type scriptEvent struct {
+	start    bool // otherwise, this is the end of the entry
+	entry    int
+	exitcode int
+	at       time.Time
+}
+
+// newScriptReporter creates the status fifo at path, and starts reading from it.
+//
+// Errors:
+//
+//    - warpforge-error-io -- when the fifo can't be created or opened
+func newScriptReporter(path string) (*scriptReporter, error) {
+	if err := syscall.Mkfifo(path, uint32(actionWritableMode(false))); err != nil {
+		return nil, wfapi.ErrorIo("failed to create script status fifo", path, err)
+	}
+	// chmod too, since mkfifo is subject to the umask
+	if err := os.Chmod(path, actionWritableMode(false)); err != nil {
+		return nil, wfapi.ErrorIo("failed to set permissions of script status fifo", path, err)
+	}
+	// opening the fifo for writing as well as reading means that opening it doesn't wait for the script,
+	// and that reads don't see EOF each time the script closes its end.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, wfapi.ErrorIo("failed to open script status fifo", path, err)
+	}
+	r := &scriptReporter{
+		fifo: f,
+		done: make(chan struct{}),
+	}
+	go r.read()
+	return r, nil
+}
+
+func (r *scriptReporter) read() {
+	defer close(r.done)
+	scanner := bufio.NewScanner(r.fifo)
+	for scanner.Scan() {
+		ev := scriptEvent{at: time.Now()}
+		fields := strings.Fields(scanner.Text())
+		var err error
+		switch {
+		case len(fields) == 1 && fields[0] == "done":
+			return
+		case len(fields) == 2 && fields[0] == "start":
+			ev.start = true
+			ev.entry, err = strconv.Atoi(fields[1])
+		case len(fields) == 3 && fields[0] == "end":
+			ev.entry, err = strconv.Atoi(fields[1])
+			if err == nil {
+				ev.exitcode, err = strconv.Atoi(fields[2])
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		r.events = append(r.events, ev)
+	}
+}
+
+// finish stops reading, and returns a record of each entry which was run.
+// An entry which started but never reported its end (e.g. because it called `exit`)
+// gets the exit code of the whole script.
+func (r *scriptReporter) finish(exitcode int) []wfapi.ScriptEntryRecord {
+	end := time.Now()
+	// anything the script wrote is already in the fifo, ahead of this.
+	// if this write fails, the fifo is broken and reading will stop anyway.
+	_, _ = r.fifo.WriteString("done\n")
+	<-r.done
+	r.fifo.Close()
+
+	records := []wfapi.ScriptEntryRecord{}
+	var started *scriptEvent
+	for i, ev := range r.events {
+		switch {
+		case ev.start:
+			started = &r.events[i]
+		case started != nil && ev.entry == started.entry:
+			records = append(records, wfapi.ScriptEntryRecord{
+				Exitcode: ev.exitcode,
+				Duration: ev.at.Sub(started.at).Milliseconds(),
+			})
+			started = nil
+		}
+	}
+	if started != nil {
+		records = append(records, wfapi.ScriptEntryRecord{
+			Exitcode: exitcode,
+			Duration: end.Sub(started.at).Milliseconds(),
+		})
+	}
+	return records
+}
+
+// reportScriptEntries logs how each entry of a script went.
+// Entries which succeeded are only logged in verbose mode; an entry which failed is always
+// logged, along with its contents, so it's clear which part of the script broke.
+// The report is also appended to the action's log file, if there is one.
+func reportScriptEntries(ctx context.Context, script *wfapi.Action_Script, records []wfapi.ScriptEntryRecord, logFile io.Writer) {
+	logger := logging.Ctx(ctx)
+	for n, record := range records {
+		duration := time.Duration(record.Duration) * time.Millisecond
+		if logFile != nil {
+			fmt.Fprintf(logFile, "warpforge: script entry %d: exitcode %d after %s\n", n, record.Exitcode, duration)
+		}
+		if record.Exitcode == 0 {
+			logger.Debug(LOG_TAG, "script entry %d:\t%s = %s\t%s = %s",
+				n,
+				color.HiBlueString("exitcode"),
+				color.WhiteString(strconv.Itoa(record.Exitcode)),
+				color.HiBlueString("duration"),
+				color.WhiteString(duration.String()))
+			continue
+		}
+		if n >= len(script.Contents) {
+			continue
+		}
+		logger.Info(LOG_TAG, "script entry %d failed:\t%s = %s\t%s = %s\t%s = %s",
+			n,
+			color.HiBlueString("exitcode"),
+			color.WhiteString(strconv.Itoa(record.Exitcode)),
+			color.HiBlueString("duration"),
+			color.WhiteString(duration.String()),
+			color.HiBlueString("entry"),
+			color.WhiteString(fmt.Sprintf("%q", script.Contents[n])))
+	}
+}
@@ -0,0 +1,75 @@
+package formulaexec
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestScriptReporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status")
+	r, err := newScriptReporter(path)
+	qt.Assert(t, err, qt.IsNil)
+
+	// write as the script would, through a separate handle
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = f.WriteString("start 0\nend 0 0\nstart 1\nend 1 3\n")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, f.Close(), qt.IsNil)
+
+	records := r.finish(3)
+	qt.Assert(t, records, qt.HasLen, 2)
+	qt.Check(t, records[0].Exitcode, qt.Equals, 0)
+	qt.Check(t, records[1].Exitcode, qt.Equals, 3)
+
+	t.Run("entry which never ends", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "status")
+		r, err := newScriptReporter(path)
+		qt.Assert(t, err, qt.IsNil)
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		qt.Assert(t, err, qt.IsNil)
+		_, err = f.WriteString("start 0\n")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, f.Close(), qt.IsNil)
+
+		records := r.finish(7)
+		qt.Assert(t, records, qt.HasLen, 1)
+		qt.Check(t, records[0].Exitcode, qt.Equals, 7)
+	})
+}
+
+// Every entry runs, even after one fails, and sees the exit code of the entry before it as `$?`.
+// The script exits with the exit code of its last entry.
+func TestScriptRun(t *testing.T) {
+	dir := t.TempDir()
+	entries := []string{"false", "test $? -eq 1", "(exit 4)"}
+	run := scriptPreludeSrc
+	for n, entry := range entries {
+		qt.Assert(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("entry-%d", n)), []byte(entry+"\n"), 0644), qt.IsNil)
+		run += scriptEntrySrc(n)
+	}
+	run += scriptExitSrc
+	// the script is run on the host, rather than in a container, so it's moved to where the container would see it.
+	run = strings.ReplaceAll(run, containerScriptPath(), dir)
+	qt.Assert(t, os.WriteFile(filepath.Join(dir, "run"), []byte(run), 0644), qt.IsNil)
+
+	r, err := newScriptReporter(filepath.Join(dir, "status"))
+	qt.Assert(t, err, qt.IsNil)
+	err = exec.Command("/bin/sh", filepath.Join(dir, "run")).Run()
+	var exitErr *exec.ExitError
+	qt.Assert(t, errors.As(err, &exitErr), qt.IsTrue)
+	qt.Check(t, exitErr.ExitCode(), qt.Equals, 4)
+
+	records := r.finish(exitErr.ExitCode())
+	qt.Assert(t, records, qt.HasLen, 3)
+	qt.Check(t, records[0].Exitcode, qt.Equals, 1)
+	qt.Check(t, records[1].Exitcode, qt.Equals, 0)
+	qt.Check(t, records[2].Exitcode, qt.Equals, 4)
+}
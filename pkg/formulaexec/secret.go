@@ -0,0 +1,249 @@
+package formulaexec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/serum-errors/go-serum"
+
+	"github.com/warptools/warpforge/pkg/logging"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// Secret inputs are read from the host when a formula runs, and never become part of the formula:
+// they're left out when computing the formula ID, so they don't affect memoization,
+// and their values are redacted from everything warpforge shows or stores --
+// the container config, the action's output, and any literals gathered as outputs.
+
+// redacted replaces the value of a secret, wherever it would otherwise be shown.
+const redacted = "[redacted]"
+
+// secretInput is a secret input, as it was given to the container.
+type secretInput struct {
+	port  wfapi.SandboxPort
+	value string
+}
+
+// formulaForID returns a copy of a formula without any of its secret or cache inputs, nor its limits,
+// which are meant to make no difference to what the formula computes.
+// This is what the formula ID is computed from.
+//
+// Keeping the real ownership of files (see ExecConfig.UidMappings) does make a difference,
+// so if it's kept, the ownership filters are made explicit: every ware input and packed output
+// keeps uid and gid, unless it says otherwise.  The ID is then the same as if the formula had said so itself.
+func formulaForID(formula wfapi.Formula, keepOwnership bool) wfapi.Formula {
+	inputs := formula.Inputs
+	formula.Inputs.Keys = nil
+	formula.Inputs.Values = make(map[wfapi.SandboxPort]wfapi.FormulaInput, len(inputs.Values))
+	for _, port := range inputs.Keys {
+		input := inputs.Values[port]
+		if input.Basis().Secret != nil || input.Basis().CacheName != nil {
+			continue
+		}
+		if keepOwnership && input.Basis().WareID != nil {
+			complex := wfapi.FormulaInputComplex{Basis: *input.Basis()}
+			if input.FormulaInputComplex != nil {
+				complex = *input.FormulaInputComplex
+			}
+			complex.Filters = withOwnershipKept(complex.Filters)
+			input = wfapi.FormulaInput{FormulaInputComplex: &complex}
+		}
+		formula.Inputs.Keys = append(formula.Inputs.Keys, port)
+		formula.Inputs.Values[port] = input
+	}
+	if keepOwnership {
+		outputs := formula.Outputs
+		formula.Outputs.Values = make(map[wfapi.OutputName]wfapi.GatherDirective, len(outputs.Values))
+		for name, gather := range outputs.Values {
+			if gather.From.SandboxPath != nil {
+				gather.Filters = withOwnershipKept(gather.Filters)
+			}
+			formula.Outputs.Values[name] = gather
+		}
+	}
+	formula.Limits = nil
+	return formula
+}
+
+// withOwnershipKept returns a copy of filters which keeps uid and gid, unless the filters already set them.
+func withOwnershipKept(filters *wfapi.FilterMap) *wfapi.FilterMap {
+	kept := wfapi.FilterMap{Values: map[string]string{}}
+	for _, kv := range ownershipFilters(true) {
+		kept.Values[kv[0]] = kv[1]
+	}
+	if filters != nil {
+		for k, v := range filters.Values {
+			kept.Values[k] = v
+		}
+	}
+	for k := range kept.Values {
+		kept.Keys = append(kept.Keys, k)
+	}
+	sort.Strings(kept.Keys)
+	return &kept
+}
+
+// readSecret reads the value of a secret from the host.
+// Relative file paths are relative to the formula's directory, as they are for mounts.
+//
+// Errors:
+//
+//    - warpforge-error-missing -- when the environment variable isn't set, or the file doesn't exist
+//    - warpforge-error-io -- when the file can't be read
+//    - warpforge-error-formula-invalid -- when the secret's source is unknown
+func readSecret(secret wfapi.Secret, formulaDir string) (string, error) {
+	switch secret.Source {
+	case wfapi.SecretSource_Env:
+		value, ok := os.LookupEnv(secret.Ref)
+		if !ok {
+			return "", serum.Error(wfapi.ECodeMissing,
+				serum.WithMessageTemplate("secret environment variable {{name|q}} is not set"),
+				serum.WithDetail("name", secret.Ref),
+			)
+		}
+		return value, nil
+	case wfapi.SecretSource_File:
+		path := secret.Ref
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(formulaDir, path)
+		}
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return "", wfapi.ErrorFileMissing(path)
+		}
+		if err != nil {
+			return "", wfapi.ErrorIo("failed to read secret file", path, err)
+		}
+		return string(content), nil
+	default:
+		return "", wfapi.ErrorFormulaInvalid(fmt.Sprintf("unknown secret source %q", secret.Source))
+	}
+}
+
+// addSecret reads a secret input and gives it to the container:
+// as an environment variable for a SandboxVar, or as a read-only file for a SandboxPath.
+// A trailing newline is trimmed from file contents used as a variable, since secret files usually have one.
+// When explaining, the secret isn't read, and its value is only ever shown as redacted.
+//
+// Errors:
+//
+//    - warpforge-error-missing -- when the environment variable isn't set, or the file doesn't exist
+//    - warpforge-error-io -- when the secret can't be read, or written for the container
+//    - warpforge-error-formula-invalid -- when the secret's source is unknown
+func (rc *runcConfig) addSecret(ctx context.Context, port wfapi.SandboxPort, secret wfapi.Secret, formulaDir string) error {
+	logger := logging.Ctx(ctx)
+	value := redacted
+	if !rc.explain {
+		var err error
+		value, err = readSecret(secret, formulaDir)
+		if err != nil {
+			return err
+		}
+	}
+	logger.Info(LOG_TAG,
+		"secret:\t%s = %s\t%s = %s",
+		color.HiBlueString("source"),
+		color.WhiteString(secret.String()),
+		color.HiBlueString("dest"),
+		color.WhiteString(PortString(port)))
+
+	switch {
+	case port.SandboxVar != nil:
+		if secret.Source == wfapi.SecretSource_File {
+			value = strings.TrimSuffix(value, "\n")
+		}
+		rc.setEnv(string(*port.SandboxVar), value)
+	case port.SandboxPath != nil:
+		secretsPath := filepath.Join(rc.runPath, "secrets")
+		if err := os.MkdirAll(secretsPath, 0700); err != nil {
+			return wfapi.ErrorIo("failed to create secrets dir", secretsPath, err)
+		}
+		// the file is readable by the action's user, so the directory it's in keeps it from anyone else on the host.
+		secretPath := filepath.Join(secretsPath, fmt.Sprintf("%d", len(rc.secrets)))
+		if err := os.WriteFile(secretPath, []byte(value), actionReadableMode(false)); err != nil {
+			return wfapi.ErrorIo("failed to write secret file", secretPath, err)
+		}
+		mnt, err := rc.makeBindPathMount(ctx, secretPath, filepath.Join("/", string(*port.SandboxPath)), true)
+		if err != nil {
+			return err
+		}
+		rc.spec.Mounts = append(rc.spec.Mounts, mnt)
+	}
+	rc.secrets = append(rc.secrets, secretInput{port: port, value: value})
+	return nil
+}
+
+// redact replaces the value of every secret in a string.
+func (rc *runcConfig) redact(s string) string {
+	return redactSecrets(s, rc.secrets)
+}
+
+// redactedSpec returns a copy of the container config, with secrets redacted from the process's environment,
+// so that it can be shown.
+func (rc *runcConfig) redactedSpec() specs.Spec {
+	spec := rc.spec
+	if spec.Process == nil || len(rc.secrets) == 0 {
+		return spec
+	}
+	process := *spec.Process
+	process.Env = make([]string, len(spec.Process.Env))
+	for i, v := range spec.Process.Env {
+		process.Env[i] = rc.redact(v)
+	}
+	spec.Process = &process
+	return spec
+}
+
+func redactSecrets(s string, secrets []secretInput) string {
+	for _, secret := range secrets {
+		// an empty value would match everywhere, and there's nothing to hide anyway
+		if secret.value == "" || secret.value == redacted {
+			continue
+		}
+		s = strings.ReplaceAll(s, secret.value, redacted)
+	}
+	return s
+}
+
+// redactingWriter redacts secrets from everything written through it.
+// Output is passed on a line at a time, so that a secret split across writes is still redacted;
+// Flush passes on whatever is left once nothing more will be written.
+type redactingWriter struct {
+	w       io.Writer
+	secrets []secretInput
+	buf     []byte
+}
+
+func newRedactingWriter(w io.Writer, secrets []secretInput) *redactingWriter {
+	return &redactingWriter{w: w, secrets: secrets}
+}
+
+func (rw *redactingWriter) Write(p []byte) (int, error) {
+	rw.buf = append(rw.buf, p...)
+	i := bytes.LastIndexByte(rw.buf, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	if _, err := io.WriteString(rw.w, redactSecrets(string(rw.buf[:i+1]), rw.secrets)); err != nil {
+		return 0, err
+	}
+	rw.buf = append(rw.buf[:0], rw.buf[i+1:]...)
+	return len(p), nil
+}
+
+func (rw *redactingWriter) Flush() error {
+	if len(rw.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(rw.w, redactSecrets(string(rw.buf), rw.secrets))
+	rw.buf = rw.buf[:0]
+	return err
+}
@@ -0,0 +1,124 @@
+package formulaexec
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/json"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/serum-errors/go-serum"
+
+	"github.com/warptools/warpforge/pkg/logging"
+	"github.com/warpfork/go-testmark"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// withSecrets parses a formula, and adds secret inputs to it.
+func withSecrets(t *testing.T, serial []byte, secrets map[string]string) wfapi.FormulaAndContext {
+	frmAndCtx := wfapi.FormulaAndContext{}
+	_, err := ipld.Unmarshal(serial, json.Decode, &frmAndCtx, wfapi.TypeSystem.TypeByName("FormulaAndContext"))
+	qt.Assert(t, err, qt.IsNil)
+	formula := frmAndCtx.Formula.Formula
+	for port, secret := range secrets {
+		input := wfapi.FormulaInput{}
+		_, err := ipld.Unmarshal([]byte(`"`+secret+`"`), json.Decode, &input, wfapi.TypeSystem.TypeByName("FormulaInput"))
+		qt.Assert(t, err, qt.IsNil)
+		key := wfapi.SandboxPort{}
+		_, err = ipld.Unmarshal([]byte(`"`+port+`"`), json.Decode, &key, wfapi.TypeSystem.TypeByName("SandboxPort"))
+		qt.Assert(t, err, qt.IsNil)
+		formula.Inputs.Keys = append(formula.Inputs.Keys, key)
+		formula.Inputs.Values[key] = input
+	}
+	return frmAndCtx
+}
+
+// Secret inputs are left out of the formula ID, and their values never show up in the logs.
+func TestSecretInputs(t *testing.T) {
+	doc, err := testmark.ReadFile("../../examples/110-formula-usage/example-formula-exec.md")
+	qt.Assert(t, err, qt.IsNil)
+	doc.BuildDirIndex()
+	serial := doc.DirEnt.Children["pack"].Children["formula"].Hunk.Body
+	runrecord := wfapi.RunRecord{}
+	_, err = ipld.Unmarshal(doc.DirEnt.Children["pack"].Children["runrecord"].Hunk.Body, json.Decode, &runrecord, wfapi.TypeSystem.TypeByName("RunRecord"))
+	qt.Assert(t, err, qt.IsNil)
+
+	t.Setenv("WARPFORGE_TEST_SECRET", "hunter2-env")
+	secretFile := filepath.Join(t.TempDir(), "token")
+	qt.Assert(t, os.WriteFile(secretFile, []byte("hunter2-file\n"), 0600), qt.IsNil)
+	frmAndCtx := withSecrets(t, serial, map[string]string{
+		"$TOKEN":      "secret:env:WARPFORGE_TEST_SECRET",
+		"$FILE_TOKEN": "secret:file:" + secretFile,
+		"/run/token":  "secret:file:" + secretFile,
+	})
+
+	var out, errOut bytes.Buffer
+	ctx := logging.NewLogger(&out, &errOut, false, false, true).WithContext(context.Background())
+	wfCfg, rootWs := newTestConfig(t)
+	wfCfg.Executor = ExecutorFake
+
+	t.Run("formula id", func(t *testing.T) {
+		rr, err := Exec(ctx, wfCfg, rootWs, frmAndCtx, wfapi.FormulaExecConfig{})
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, rr.FormulaID, qt.Equals, runrecord.FormulaID)
+		qt.Check(t, errOut.String(), qt.Contains, "secret:env:WARPFORGE_TEST_SECRET")
+		qt.Check(t, errOut.String(), qt.Not(qt.Contains), "hunter2")
+		qt.Check(t, out.String(), qt.Not(qt.Contains), "hunter2")
+	})
+	t.Run("explain", func(t *testing.T) {
+		errOut.Reset()
+		_, err := Exec(ctx, wfCfg, rootWs, frmAndCtx, wfapi.FormulaExecConfig{Explain: true, ExplainSpec: true})
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, errOut.String(), qt.Contains, "TOKEN="+redacted)
+		qt.Check(t, errOut.String(), qt.Not(qt.Contains), "hunter2")
+	})
+	t.Run("strict", func(t *testing.T) {
+		_, err := Exec(ctx, wfCfg, rootWs, frmAndCtx, wfapi.FormulaExecConfig{Strict: true})
+		qt.Check(t, serum.Code(err), qt.Equals, wfapi.ECodeNotHermetic)
+	})
+	t.Run("missing", func(t *testing.T) {
+		frmAndCtx := withSecrets(t, serial, map[string]string{"$TOKEN": "secret:env:WARPFORGE_TEST_SECRET_UNSET"})
+		_, err := Exec(ctx, wfCfg, rootWs, frmAndCtx, wfapi.FormulaExecConfig{})
+		qt.Check(t, serum.Code(err), qt.Equals, wfapi.ECodeMissing)
+	})
+}
+
+func TestAddSecret(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "token")
+	qt.Assert(t, os.WriteFile(secretFile, []byte("hunter2\n"), 0600), qt.IsNil)
+	varPort, pathPort := wfapi.SandboxVar("TOKEN"), wfapi.SandboxPath("run/token")
+	secret := wfapi.Secret{Source: wfapi.SecretSource_File, Ref: "token"}
+
+	rc := &runcConfig{runPath: t.TempDir()}
+	rc.spec.Process = &specs.Process{Env: []string{"TOKEN=old"}}
+	qt.Assert(t, rc.addSecret(context.Background(), wfapi.SandboxPort{SandboxVar: &varPort}, secret, filepath.Dir(secretFile)), qt.IsNil)
+	qt.Assert(t, rc.addSecret(context.Background(), wfapi.SandboxPort{SandboxPath: &pathPort}, secret, filepath.Dir(secretFile)), qt.IsNil)
+
+	qt.Check(t, rc.spec.Process.Env, qt.DeepEquals, []string{"TOKEN=hunter2"})
+	qt.Assert(t, rc.spec.Mounts, qt.HasLen, 1)
+	qt.Check(t, rc.spec.Mounts[0].Destination, qt.Equals, "/run/token")
+	qt.Check(t, rc.spec.Mounts[0].Options, qt.Contains, "ro")
+	content, err := os.ReadFile(rc.spec.Mounts[0].Source)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(content), qt.Equals, "hunter2\n")
+	qt.Check(t, rc.redact("TOKEN=hunter2\n"), qt.Equals, "TOKEN="+redacted+"\n")
+}
+
+func TestRedactingWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := newRedactingWriter(&buf, []secretInput{{value: "hunter2"}, {value: ""}})
+	for _, chunk := range []string{"the password is hun", "ter2\nand again: hunter", "2"} {
+		n, err := w.Write([]byte(chunk))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, n, qt.Equals, len(chunk))
+	}
+	qt.Check(t, buf.String(), qt.Equals, "the password is [redacted]\n")
+	qt.Assert(t, w.Flush(), qt.IsNil)
+	qt.Check(t, buf.String(), qt.Equals, "the password is [redacted]\nand again: [redacted]")
+	qt.Check(t, strings.Count(buf.String(), "hunter2"), qt.Equals, 0)
+}
@@ -0,0 +1,133 @@
+package formulaexec
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/warptools/warpforge/pkg/logging"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// remoteWareTimeout limits how long we wait to hear whether a remote warehouse has a ware.
+const remoteWareTimeout = 10 * time.Second
+
+// localWarehousePath returns the host path of the warehouse which outputs are packed into.
+func (cfg *internalConfig) localWarehousePath() string {
+	if warehousePath, ok := cfg.warehousePathOverride(); ok {
+		return warehousePath
+	}
+	return filepath.Join("/", cfg.RootWs.WarehousePath())
+}
+
+// wareInWarehouseDir returns true if a ware is stored in a warehouse directory on the host.
+func wareInWarehouseDir(dir string, wareId wfapi.WareID) bool {
+	if len(wareId.Hash) < 7 {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(dir, wareId.Subpath()))
+	return err == nil
+}
+
+// wareInRemoteWarehouse asks a warehouse whether it has a ware.
+// Content-addressed warehouses on the host ("ca+file") are checked directly;
+// those reached over http(s) ("ca+https" or "ca+http") are asked with a HEAD request.
+// Other kinds of warehouse (e.g. "ca+s3") can't be checked, which is returned as an error naming the scheme.
+// Any failure to get an answer is returned as an error, and the ware should be assumed missing.
+func wareInRemoteWarehouse(ctx context.Context, addr wfapi.WarehouseAddr, wareId wfapi.WareID) (bool, error) {
+	if len(wareId.Hash) < 7 {
+		return false, nil
+	}
+	proto, location, ok := strings.Cut(string(addr), "://")
+	if !ok {
+		return false, fmt.Errorf("warehouse address %q has no protocol", addr)
+	}
+	switch proto {
+	case "ca+file":
+		return wareInWarehouseDir(location, wareId), nil
+	case "ca+https", "ca+http":
+		ctx, cancel := context.WithTimeout(ctx, remoteWareTimeout)
+		defer cancel()
+		url := strings.TrimPrefix(proto, "ca+") + "://" + strings.TrimSuffix(location, "/") + "/" + wareId.Subpath()
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		if err != nil {
+			return false, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return false, err
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return true, nil
+		case http.StatusNotFound:
+			return false, nil
+		default:
+			return false, fmt.Errorf("unexpected status from %q: %s", url, resp.Status)
+		}
+	default:
+		return false, fmt.Errorf("checking warehouses of type %q is not supported", proto)
+	}
+}
+
+// wareStored returns true if a ware is in the local warehouse,
+// or failing that, in the remote warehouse (if one is configured).
+func (cfg *internalConfig) wareStored(ctx context.Context, wareId wfapi.WareID) bool {
+	if wareInWarehouseDir(cfg.localWarehousePath(), wareId) {
+		return true
+	}
+	if cfg.RemoteWarehouse == nil {
+		return false
+	}
+	found, err := wareInRemoteWarehouse(ctx, *cfg.RemoteWarehouse, wareId)
+	if err != nil {
+		logger := logging.Ctx(ctx)
+		logger.Debug(LOG_TAG, "unable to check remote warehouse %q for ware %q: %s", *cfg.RemoteWarehouse, wareId, err)
+		return false
+	}
+	return found
+}
+
+// missingMemoResults returns the wares in a memoized RunRecord's results which can no longer be found.
+// A memo whose results are missing is stale: reusing it would just make the next unpack of those wares fail.
+// Literal results, and the placeholder for outputs which were empty, aren't stored anywhere, so are never missing.
+func (cfg *internalConfig) missingMemoResults(ctx context.Context, memo wfapi.RunRecord) []wfapi.WareID {
+	var missing []wfapi.WareID
+	for _, name := range memo.Results.Keys {
+		result := memo.Results.Values[name]
+		if result.WareID == nil || result.WareID.Hash == "-" {
+			continue
+		}
+		if !cfg.wareStored(ctx, *result.WareID) {
+			missing = append(missing, *result.WareID)
+		}
+	}
+	return missing
+}
+
+// addRemoteWarehouse adds the remote warehouse (if one is configured) as the last place to fetch
+// any input ware which isn't in the local warehouse.
+// This is what lets a formula use the results of a memo which was only found to be valid remotely.
+func (cfg *internalConfig) addRemoteWarehouse(formula wfapi.Formula, frmContext *wfapi.FormulaContext) {
+	if cfg.RemoteWarehouse == nil {
+		return
+	}
+	for _, port := range formula.Inputs.Keys {
+		input := formula.Inputs.Values[port]
+		for _, layer := range input.Layers() {
+			wareId := layer.WareID
+			if wareId == nil {
+				continue
+			}
+			if wareInWarehouseDir(cfg.localWarehousePath(), *wareId) {
+				continue
+			}
+			frmContext.AddWarehouses(*wareId, *cfg.RemoteWarehouse)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package formulaexec
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/warptools/warpforge/wfapi"
+)
+
+func TestMissingMemoResults(t *testing.T) {
+	ctx := context.Background()
+	present := wfapi.WareID{Packtype: "tar", Hash: "4z9DCTxoKkStqXQRwtf9nimpfQQ36dbndDsAPCQgECfbXt3edanUrsVKCjE9TkX2v9"}
+	remote := wfapi.WareID{Packtype: "tar", Hash: "3vmwry1wdxQjTaCjmoJnvGbdpg9ucTvCpWzGzvtujbLQSwvPPAECTm3YxrsHnERtzg"}
+	gone := wfapi.WareID{Packtype: "tar", Hash: "2En3zD1ho1qNeLpPryZVM1UTGnqPvnt48WY36TzCGJwSCudxPXkDtN3UuS4J3AYWAM"}
+	literal := wfapi.Literal("v1.2.3")
+
+	whPath := t.TempDir()
+	qt.Assert(t, os.MkdirAll(filepath.Join(whPath, filepath.Dir(present.Subpath())), 0755), qt.IsNil)
+	qt.Assert(t, os.WriteFile(filepath.Join(whPath, present.Subpath()), nil, 0644), qt.IsNil)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead && r.URL.Path == "/warehouse/"+remote.Subpath() {
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	memo := wfapi.RunRecord{}
+	memo.Results.Values = map[wfapi.OutputName]wfapi.FormulaInputSimple{}
+	for name, result := range map[wfapi.OutputName]wfapi.FormulaInputSimple{
+		"present": {WareID: &present},
+		"remote":  {WareID: &remote},
+		"gone":    {WareID: &gone},
+		"empty":   {WareID: &wfapi.WareID{Packtype: "tar", Hash: "-"}},
+		"literal": {Literal: &literal},
+	} {
+		memo.Results.Keys = append(memo.Results.Keys, name)
+		memo.Results.Values[name] = result
+	}
+
+	cfg := internalConfig{ExecConfig: ExecConfig{WhPathOverride: &whPath}}
+	qt.Check(t, cfg.missingMemoResults(ctx, memo), qt.ContentEquals, []wfapi.WareID{remote, gone})
+
+	remoteAddr := wfapi.WarehouseAddr("ca+" + srv.URL + "/warehouse")
+	cfg.RemoteWarehouse = &remoteAddr
+	qt.Check(t, cfg.missingMemoResults(ctx, memo), qt.DeepEquals, []wfapi.WareID{gone})
+}
+
+func TestWareInRemoteWarehouse(t *testing.T) {
+	ctx := context.Background()
+	wareId := wfapi.WareID{Packtype: "tar", Hash: "4z9DCTxoKkStqXQRwtf9nimpfQQ36dbndDsAPCQgECfbXt3edanUrsVKCjE9TkX2v9"}
+
+	// s3 warehouses are left to rio, and not rewritten into https ones
+	found, err := wareInRemoteWarehouse(ctx, "ca+s3://bucket.example.org/warehouse", wareId)
+	qt.Check(t, found, qt.IsFalse)
+	qt.Check(t, err, qt.ErrorMatches, `.*"ca\+s3".*`)
+}
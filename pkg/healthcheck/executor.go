@@ -0,0 +1,67 @@
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/serum-errors/go-serum"
+
+	"github.com/warptools/warpforge/pkg/config"
+	"github.com/warptools/warpforge/pkg/formulaexec"
+)
+
+// ExecutorCheck checks whether an executor is usable on this host.
+type ExecutorCheck struct {
+	Name string
+}
+
+func (c *ExecutorCheck) String() string {
+	return fmt.Sprintf("Executor Check: %q", c.Name)
+}
+
+// selected reports whether this executor is the one that will be used by default.
+func (c *ExecutorCheck) selected() bool {
+	name := config.Executor()
+	if name == "" {
+		name = formulaexec.DefaultExecutor
+	}
+	return name == c.Name
+}
+
+// Run checks that the executor can be used.
+// An unusable executor is only a failure if it's the selected executor.
+//
+// Errors:
+//
+//    - warpforge-error-healthcheck-run-okay -- when the executor is usable
+//    - warpforge-error-healthcheck-run-fail -- when the selected executor is not usable
+//    - warpforge-error-healthcheck-run-ambiguous -- when an executor which isn't selected is not usable
+func (c *ExecutorCheck) Run(ctx context.Context) error {
+	selected := ""
+	if c.selected() {
+		selected = " (selected)"
+	}
+	binPath, err := config.BinPath()
+	if err != nil {
+		return serum.Error(CodeRunFailure, serum.WithCause(err),
+			serum.WithMessageLiteral("Could not find binary path"),
+		)
+	}
+	executor, err := formulaexec.NewExecutor(c.Name, binPath)
+	if err != nil {
+		return serum.Error(CodeRunFailure, serum.WithCause(err),
+			serum.WithMessageLiteral("Unknown executor"),
+		)
+	}
+	if err := executor.Check(ctx); err != nil {
+		code := CodeRunAmbiguous
+		if c.selected() {
+			code = CodeRunFailure
+		}
+		return serum.Error(code, serum.WithCause(err),
+			serum.WithMessageTemplate("not usable{{selected}}"),
+			serum.WithDetail("selected", selected),
+		)
+	}
+	return serum.Errorf(CodeRunOkay, "usable%s", selected)
+}
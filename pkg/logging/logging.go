@@ -205,7 +205,22 @@ func (l *Logger) PrintRunRecord(tag string, rr wfapi.RunRecord, memoized bool) {
 		)
 
 		for k, v := range rr.Results.Values {
-			l.Info(tag, "\t\t%s: %s", k, v.WareID)
+			switch {
+			case v.WareID != nil:
+				l.Info(tag, "\t\t%s: %s", k, v.WareID)
+			case v.Literal != nil:
+				l.Info(tag, "\t\t%s: literal:%s", k, *v.Literal)
+			}
+		}
+		if rr.NetworkAccess != nil {
+			l.Info(tag, "\t%s:", color.HiBlueString("NetworkAccess"))
+			for _, access := range *rr.NetworkAccess {
+				result := "allowed"
+				if !access.Allowed {
+					result = "refused"
+				}
+				l.Info(tag, "\t\t%s: %s", access.Host, result)
+			}
 		}
 	}
 }
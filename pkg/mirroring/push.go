@@ -68,12 +68,19 @@ func PushToWarehouseAddr(ctx context.Context, ws workspace.Workspace, cat worksp
 			}
 			for _, i := range rel.Items.Keys {
 				ref.ItemName = i
-				wareId, warehouseAddr, err := cat.GetWare(ref)
+				wareId, warehouseAddrs, err := cat.GetWare(ref)
 				if err != nil {
 					return err
 				}
-				if warehouseAddr == nil || *warehouseAddr != pushAddr {
-					// this ware's WarehouseAddr does not match the one we're pushing to,
+				mirrored := false
+				for _, addr := range warehouseAddrs {
+					if addr == pushAddr {
+						mirrored = true
+						break
+					}
+				}
+				if !mirrored {
+					// none of this ware's mirrors are the one we're pushing to,
 					// ignore this ware
 					continue
 				}
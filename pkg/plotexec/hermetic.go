@@ -0,0 +1,88 @@
+package plotexec
+
+import (
+	"fmt"
+
+	"github.com/warptools/warpforge/pkg/formulaexec"
+	"github.com/warptools/warpforge/pkg/workspace"
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// inputViolation describes how a plot input is not hermetic, or returns an empty string if it is.
+// Pipes, catalog references, wares and literals are all hermetic;
+// mounts, ingests, secrets and cache mounts take data from the host, which the plot doesn't capture.
+// Every layer of an input is checked.
+func inputViolation(input wfapi.PlotInput) string {
+	for _, layer := range input.Layers() {
+		switch {
+		case layer.Mount != nil:
+			return "is a mount"
+		case layer.Ingest != nil:
+			return "is an ingest"
+		case layer.Secret != nil:
+			return "is a secret"
+		case layer.CacheName != nil:
+			return "is a cache mount"
+		}
+	}
+	return ""
+}
+
+// plotViolations lists every way in which a plot, including all of its steps and subplots, is not hermetic.
+// Each violation is prefixed by the path of steps which leads to it.
+func plotViolations(plot wfapi.Plot, prefix string) []string {
+	var violations []string
+	for _, name := range plot.Inputs.Keys {
+		if v := inputViolation(plot.Inputs.Values[name]); v != "" {
+			violations = append(violations, fmt.Sprintf("%splot input %q %s", prefix, name, v))
+		}
+	}
+	for _, name := range plot.Steps.Keys {
+		step := plot.Steps.Values[name]
+		stepPrefix := fmt.Sprintf("%sstep %q: ", prefix, name)
+		switch {
+		case step.Plot != nil:
+			violations = append(violations, plotViolations(*step.Plot, stepPrefix)...)
+		case step.Protoformula != nil:
+			for _, port := range step.Protoformula.Inputs.Keys {
+				if v := inputViolation(step.Protoformula.Inputs.Values[port]); v != "" {
+					violations = append(violations, fmt.Sprintf("%sinput %q %s", stepPrefix, formulaexec.PortString(port), v))
+				}
+			}
+			for _, v := range formulaexec.ActionViolations(step.Protoformula.Action) {
+				violations = append(violations, stepPrefix+v)
+			}
+		}
+	}
+	return violations
+}
+
+// checkHermetic refuses to run a plot which is not hermetic, if strict mode is enabled.
+// The whole plot is checked before any of it runs, and every violation is reported at once.
+//
+// Errors:
+//
+//    - warpforge-error-not-hermetic -- when strict mode is enabled, and the plot is not hermetic
+//    - warpforge-error-io -- when the workspace's policy can't be read
+//    - warpforge-error-serialization -- when the workspace's policy can't be parsed
+func checkHermetic(wss workspace.WorkspaceSet, plot wfapi.Plot, pltCfg wfapi.PlotExecConfig) error {
+	var root *workspace.Workspace
+	if len(wss) > 0 {
+		root = wss.Root()
+	}
+	strict, err := formulaexec.StrictEnabled(root, pltCfg.FormulaExecConfig)
+	if err != nil {
+		return err
+	}
+	if !strict {
+		return nil
+	}
+	violations := plotViolations(plot, "")
+	if pltCfg.FormulaExecConfig.Interactive {
+		violations = append(violations, "stdin is interactive")
+	}
+	if len(violations) > 0 {
+		return wfapi.ErrorNotHermetic(violations)
+	}
+	return nil
+}
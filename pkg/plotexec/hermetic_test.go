@@ -0,0 +1,60 @@
+package plotexec
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/json"
+
+	"github.com/warptools/warpforge/wfapi"
+)
+
+func TestPlotViolations(t *testing.T) {
+	serial := `{
+	"inputs": {
+		"rootfs": "catalog:warpsys.org/busybox:v1.35.0:amd64-static",
+		"pwd": "mount:overlay:.",
+		"token": "secret:env:REGISTRY_TOKEN"
+	},
+	"steps": {
+		"outer": {
+			"plot": {
+				"inputs": {
+					"src": "ingest:git:.:HEAD"
+				},
+				"steps": {
+					"inner": {
+						"protoformula": {
+							"inputs": {
+								"/": "pipe::src",
+								"/root/.cargo": "cache:cargo"
+							},
+							"action": {
+								"script": {
+									"interpreter": "/bin/sh",
+									"contents": ["true"],
+									"network": true
+								}
+							},
+							"outputs": {}
+						}
+					}
+				},
+				"outputs": {}
+			}
+		}
+	},
+	"outputs": {}
+}`
+	plot := wfapi.Plot{}
+	_, err := ipld.Unmarshal([]byte(serial), json.Decode, &plot, wfapi.TypeSystem.TypeByName("Plot"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, plotViolations(plot, ""), qt.DeepEquals, []string{
+		`plot input "pwd" is a mount`,
+		`plot input "token" is a secret`,
+		`step "outer": plot input "src" is an ingest`,
+		`step "outer": step "inner": input "/root/.cargo" is a cache mount`,
+		`step "outer": step "inner": action has network access`,
+	})
+}
@@ -140,11 +140,10 @@ func orderSteps_visit(
 	}
 	inputPipes := []wfapi.Pipe{}
 	for _, i := range stepInputs {
-		if i.PlotInputSimple.Pipe != nil {
-			inputPipes = append(inputPipes, *i.PlotInputSimple.Pipe)
-
-		} else if i.PlotInputComplex != nil && i.PlotInputComplex.Basis.Pipe != nil {
-			inputPipes = append(inputPipes, *i.PlotInputComplex.Basis.Pipe)
+		for _, layer := range i.Layers() {
+			if layer.Pipe != nil {
+				inputPipes = append(inputPipes, *layer.Pipe)
+			}
 		}
 	}
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/fatih/color"
 	"github.com/go-git/go-git/v5"
@@ -67,9 +68,10 @@ func (m pipeMap) lookup(stepName wfapi.StepName, label wfapi.LocalLabel) (*wfapi
 	}
 }
 
-// Resolves a PlotInput to a WareID and optionally a WarehouseAddr.
+// Resolves a PlotInput to a FormulaInput.
 // This will resolve various input types (Pipes, CatalogRefs, etc...)
 // to allow them to be used in a Formula.
+// Where the wares it resolves to can be fetched from is added to frmCtx.
 //
 // Errors:
 //
@@ -86,32 +88,68 @@ func plotInputToFormulaInput(ctx context.Context,
 	wss workspace.WorkspaceSet,
 	plotInput wfapi.PlotInput,
 	plotConfig wfapi.PlotExecConfig,
-	pipeCtx pipeMap) (wfapi.FormulaInput, *wfapi.WarehouseAddr, error) {
+	pipeCtx pipeMap,
+	frmCtx *wfapi.FormulaContext) (wfapi.FormulaInput, error) {
 	ctx, span := tracing.Start(ctx, "plotInputToFormulaInput")
 	defer span.End()
 
-	basis, addr, err := plotInputToFormulaInputSimple(ctx, cfg, wss, plotInput, plotConfig, pipeCtx)
-	if err != nil {
-		return wfapi.FormulaInput{}, nil, err
+	if plotInput.PlotInputSimple == nil && plotInput.PlotInputComplex == nil && plotInput.LiteralLines == nil {
+		return wfapi.FormulaInput{}, wfapi.ErrorPlotInvalid("plot contains input that is neither PlotInputSimple or PlotInputComplex")
+	}
+
+	// the basis, and any layers stacked on top of it, are each resolved the same way
+	var layers []wfapi.FormulaInputSimple
+	for _, layer := range plotInput.Layers() {
+		simple, addrs, err := plotInputToFormulaInputSimple(ctx, cfg, wss, layer, plotConfig, pipeCtx)
+		if err != nil {
+			return wfapi.FormulaInput{}, err
+		}
+		if len(addrs) > 0 {
+			// input specifies where its ware can be fetched from, add it to the context
+			frmCtx.AddWarehouses(*simple.WareID, addrs...)
+		}
+		layers = append(layers, simple)
 	}
 
 	switch {
 	case plotInput.PlotInputSimple != nil:
 		return wfapi.FormulaInput{
-			FormulaInputSimple: &basis,
-		}, addr, nil
-	case plotInput.PlotInputComplex != nil:
+			FormulaInputSimple: &layers[0],
+		}, nil
+	case plotInput.LiteralLines != nil:
+		// kept as lines, so that the formula reads the same as the plot.
 		return wfapi.FormulaInput{
-			FormulaInputComplex: &wfapi.FormulaInputComplex{
-				Basis:   basis,
-				Filters: plotInput.PlotInputComplex.Filters,
-			}}, addr, nil
+			LiteralLines: plotInput.LiteralLines,
+		}, nil
 	default:
-		panic("unreachable")
+		inputComplex := &wfapi.FormulaInputComplex{
+			Basis:   layers[0],
+			Filters: plotInput.PlotInputComplex.Filters,
+			Subpath: plotInput.PlotInputComplex.Subpath,
+		}
+		if plotInput.PlotInputComplex.Layers != nil {
+			rest := layers[1:]
+			inputComplex.Layers = &rest
+		}
+		return wfapi.FormulaInput{FormulaInputComplex: inputComplex}, nil
 	}
 }
 
-// Converts a plot input into a FormulaInputSimple
+// workspaceWarehouses returns the warehouses of the workspaces in a workspace set, nearest first,
+// as places wares may be fetched from.
+// The root workspace's warehouse is left out, since it's always tried first when unpacking.
+func workspaceWarehouses(wss workspace.WorkspaceSet) []wfapi.WarehouseAddr {
+	if len(wss) == 0 {
+		return nil
+	}
+	var addrs []wfapi.WarehouseAddr
+	for _, ws := range wss[:len(wss)-1] {
+		addrs = append(addrs, ws.GetWarehouseAddress())
+	}
+	return addrs
+}
+
+// Converts the basis of a plot input (or one of its layers) into a FormulaInputSimple
 //
 // Errors:
 //
@@ -126,25 +164,13 @@ func plotInputToFormulaInput(ctx context.Context,
 func plotInputToFormulaInputSimple(ctx context.Context,
 	cfg ExecConfig,
 	wss workspace.WorkspaceSet,
-	plotInput wfapi.PlotInput,
+	basis wfapi.PlotInputSimple,
 	plotCfg wfapi.PlotExecConfig,
-	pipeCtx pipeMap) (wfapi.FormulaInputSimple, *wfapi.WarehouseAddr, error) {
+	pipeCtx pipeMap) (wfapi.FormulaInputSimple, []wfapi.WarehouseAddr, error) {
 	ctx, span := tracing.Start(ctx, "plotInputToFormulaInputSimple")
 	defer span.End()
 	logger := logging.Ctx(ctx)
 
-	var basis wfapi.PlotInputSimple
-
-	switch {
-	case plotInput.PlotInputSimple != nil:
-		basis = *plotInput.PlotInputSimple
-	case plotInput.PlotInputComplex != nil:
-		basis = plotInput.PlotInputComplex.Basis
-	default:
-		return wfapi.FormulaInputSimple{}, nil,
-			wfapi.ErrorPlotInvalid("plot contains input that is neither PlotInputSimple or PlotInputComplex")
-	}
-
 	switch {
 	case basis.WareID != nil:
 		logger.Info(LOG_TAG, "\t%s = %s\t%s = %s\t%s = %s",
@@ -159,7 +185,7 @@ func plotInputToFormulaInputSimple(ctx context.Context,
 		// convert WareID PlotInput to FormulaInput
 		return wfapi.FormulaInputSimple{
 			WareID: basis.WareID,
-		}, nil, nil
+		}, workspaceWarehouses(wss), nil
 	case basis.Mount != nil:
 		logger.Info(LOG_TAG, "\t%s = %s\t%s = %s\t%s = %s",
 			color.HiBlueString("type"),
@@ -174,6 +200,30 @@ func plotInputToFormulaInputSimple(ctx context.Context,
 		return wfapi.FormulaInputSimple{
 			Mount: basis.Mount,
 		}, nil, nil
+	case basis.Secret != nil:
+		logger.Info(LOG_TAG, "\t%s = %s\t%s = %s",
+			color.HiBlueString("type"),
+			color.WhiteString("secret"),
+			color.HiBlueString("source"),
+			color.WhiteString(basis.Secret.String()),
+		)
+
+		// secrets are passed through to the formula, which reads them when it runs
+		return wfapi.FormulaInputSimple{
+			Secret: basis.Secret,
+		}, nil, nil
+	case basis.CacheName != nil:
+		logger.Info(LOG_TAG, "\t%s = %s\t%s = %s",
+			color.HiBlueString("type"),
+			color.WhiteString("cache"),
+			color.HiBlueString("name"),
+			color.WhiteString(string(*basis.CacheName)),
+		)
+
+		// cache mounts are passed through to the formula, which mounts them when it runs
+		return wfapi.FormulaInputSimple{
+			CacheName: basis.CacheName,
+		}, nil, nil
 	case basis.CatalogRef != nil:
 		logger.Info(LOG_TAG, "\t%s = %s\n\t\t%s = %s",
 			color.HiBlueString("type"),
@@ -182,8 +232,8 @@ func plotInputToFormulaInputSimple(ctx context.Context,
 			color.WhiteString(basis.CatalogRef.String()),
 		)
 
-		// find the WareID and WareAddress for this catalog item
-		wareId, wareAddr, err := wss.GetCatalogWare(*basis.CatalogRef)
+		// find the WareID and the warehouses it's mirrored in for this catalog item
+		wareId, wareAddrs, err := wss.GetCatalogWare(*basis.CatalogRef)
 		if err != nil {
 			return wfapi.FormulaInputSimple{}, nil, serum.Error(wfapi.ECodeCatalogMissingEntry,
 				serum.WithMessageTemplate("could not find {{ catalogRef | q}}"),
@@ -201,8 +251,12 @@ func plotInputToFormulaInputSimple(ctx context.Context,
 		}
 
 		wareStr := "none"
-		if wareAddr != nil {
-			wareStr = string(*wareAddr)
+		if len(wareAddrs) > 0 {
+			strs := make([]string, len(wareAddrs))
+			for i, addr := range wareAddrs {
+				strs[i] = string(addr)
+			}
+			wareStr = strings.Join(strs, ", ")
 		}
 		logger.Info(LOG_TAG, "\t\t%s = %s\n\t\t%s = %s",
 			color.HiBlueString("wareId"),
@@ -213,7 +267,7 @@ func plotInputToFormulaInputSimple(ctx context.Context,
 
 		// resolve the replay
 		// TODO: unclear if this should happen here or elsewhere
-		if wareAddr == nil {
+		if len(wareAddrs) == 0 {
 			// check if the ware is already in the warehouse
 			root := wss.Root()
 			warehousePath := filepath.Join("/",
@@ -225,14 +279,21 @@ func plotInputToFormulaInputSimple(ctx context.Context,
 				if err != nil {
 					return wfapi.FormulaInputSimple{}, nil, err
 				}
-				if replay != nil {
+				if replay != nil && plotCfg.FormulaExecConfig.Explain {
+					// explaining doesn't run anything, replays included.
+					logger.Info(LOG_TAG, "replay for module = %s, release = %s would be run to produce this ware",
+						basis.CatalogRef.ModuleName, basis.CatalogRef.ReleaseName)
+				} else if replay != nil {
 					if !plotCfg.Recursive {
 						// recursion is not allowed, return error
 						return wfapi.FormulaInputSimple{}, nil, wfapi.ErrorMissingCatalogEntry(*basis.CatalogRef, true)
 					}
 					logger.Info(LOG_TAG, "resolving replay for module = %s, release = %s...",
 						basis.CatalogRef.ModuleName, basis.CatalogRef.ReleaseName)
-					result, err := execPlot(ctx, cfg, wss, *replay, plotCfg)
+					// the steps of a replay are recorded under the module it's a replay of
+					replayCfg := plotCfg
+					replayCfg.Module = basis.CatalogRef.ModuleName
+					result, err := execPlot(ctx, cfg, wss, *replay, replayCfg, nil, nil)
 					if err != nil {
 						return wfapi.FormulaInputSimple{}, nil, wfapi.ErrorPlotStepFailed("replay", err)
 					}
@@ -250,9 +311,10 @@ func plotInputToFormulaInputSimple(ctx context.Context,
 			}
 		}
 
+		// the warehouses of the workspaces are closer at hand than any mirror, so they're tried first
 		return wfapi.FormulaInputSimple{
 			WareID: wareId,
-		}, wareAddr, nil
+		}, append(workspaceWarehouses(wss), wareAddrs...), nil
 
 	case basis.Pipe != nil:
 		// resolve the pipe to a WareID using the pipeCtx
@@ -309,7 +371,7 @@ func plotInputToFormulaInputSimple(ctx context.Context,
 			// Error Codes -= warpforge-error-wareid-invalid
 			return input, nil, wfapi.ErrorPlotInvalid(fmt.Sprintf("plot contains invalid WareID %q", *input.WareID))
 		}
-		if _, errRaw = os.Stat(cachePath); os.IsNotExist(errRaw) {
+		if _, errRaw = os.Stat(cachePath); os.IsNotExist(errRaw) && !plotCfg.FormulaExecConfig.Explain {
 			gitCtx, gitSpan := tracing.Start(ctx, "checkout git ingest", trace.WithAttributes(tracing.AttrFullExecNameGit, tracing.AttrFullExecOperationGitClone))
 			defer gitSpan.End()
 			_, gitErr = git.PlainCloneContext(gitCtx, cachePath, false, &git.CloneOptions{
@@ -340,6 +402,8 @@ func plotInputToFormulaInputSimple(ctx context.Context,
 //    - warpforge-error-io -- when an IO error occurs
 //    - warpforge-error-formula-execution-failed -- when an error occurs during formula execution
 //    - warpforge-error-executor-failed -- when the execution step of the formula fails
+//    - warpforge-error-action-failed -- when the action of the formula exits non-zero
+//    - warpforge-error-action-timeout -- when the action of the formula runs for longer than its timeout
 //    - warpforge-error-ware-unpack -- when a ware unpack operation fails for a formula input
 //    - warpforge-error-ware-pack -- when a ware pack operation fails for a formula output
 //    - warpforge-error-formula-invalid -- when an invalid formula is provided
@@ -351,6 +415,7 @@ func plotInputToFormulaInputSimple(ctx context.Context,
 //    - warpforge-error-plot-step-failed -- when a replay fails
 //    - warpforge-error-serialization -- when serialization or deserialization of a memo fails
 //    - warpforge-error-workspace-missing -- when home workspace is missing or cannot open
+//    - warpforge-error-missing -- when a variable gathered as an output was not set by the action
 func execProtoformula(ctx context.Context,
 	cfg ExecConfig,
 	wss workspace.WorkspaceSet,
@@ -361,9 +426,39 @@ func execProtoformula(ctx context.Context,
 	ctx, span := tracing.Start(ctx, "execProtoformula")
 	defer span.End()
 
+	frmAndCtx, err := resolveProtoformula(ctx, cfg, wss, pf, formulaCtx, plotCfg, pipeCtx)
+	if err != nil {
+		return wfapi.RunRecord{}, err
+	}
+
+	// execute the derived formula
+	rr, err := formulaexec.Exec(ctx, formulaexec.ExecConfig(cfg), wss.Root(), frmAndCtx, plotCfg.FormulaExecConfig)
+	return rr, err
+}
+
+// Derives the Formula (and its context) of a protoformula within a Plot, by resolving its inputs
+//
+// Errors:
+//
+//    - warpforge-error-io -- when an IO error occurs during conversion
+//    - warpforge-error-git -- when an error handing a git ingest occurs
+//    - warpforge-error-catalog-parse -- when parsing of catalog files fails
+//    - warpforge-error-catalog-missing-entry -- when a referenced catalog entry cannot be found
+//    - warpforge-error-plot-invalid -- when the plot contains invalid data
+//    - warpforge-error-catalog-invalid -- when the catalog contains invalid data
+//    - warpforge-error-plot-step-failed -- when a replay fails
+//    - warpforge-error-workspace-missing -- when home workspace is missing or cannot open
+func resolveProtoformula(ctx context.Context,
+	cfg ExecConfig,
+	wss workspace.WorkspaceSet,
+	pf wfapi.Protoformula,
+	formulaCtx wfapi.FormulaContext,
+	plotCfg wfapi.PlotExecConfig,
+	pipeCtx pipeMap) (wfapi.FormulaAndContext, error) {
 	// create an empty Formula and FormulaContext
 	formula := wfapi.Formula{
 		Action: pf.Action,
+		Limits: pf.Limits,
 	}
 	formula.Inputs.Values = make(map[wfapi.SandboxPort]wfapi.FormulaInput)
 	formula.Outputs.Values = make(map[wfapi.OutputName]wfapi.GatherDirective)
@@ -371,16 +466,11 @@ func execProtoformula(ctx context.Context,
 	// convert Protoformula inputs (of type PlotInput) to FormulaInputs
 	for sbPort, plotInput := range pf.Inputs.Values {
 		formula.Inputs.Keys = append(formula.Inputs.Keys, sbPort)
-		input, wareAddr, err := plotInputToFormulaInput(ctx, cfg, wss, plotInput, plotCfg, pipeCtx)
+		input, err := plotInputToFormulaInput(ctx, cfg, wss, plotInput, plotCfg, pipeCtx, &formulaCtx)
 		if err != nil {
-			return wfapi.RunRecord{}, err
+			return wfapi.FormulaAndContext{}, err
 		}
 		formula.Inputs.Values[sbPort] = input
-		if wareAddr != nil {
-			// input specifies a WarehouseAddr, add it to the formula's context
-			formulaCtx.Warehouses.Keys = append(formulaCtx.Warehouses.Keys, *input.Basis().WareID)
-			formulaCtx.Warehouses.Values[*input.Basis().WareID] = *wareAddr
-		}
 	}
 
 	// convert Protoformula outputs to Formula outputs
@@ -390,17 +480,77 @@ func execProtoformula(ctx context.Context,
 		formula.Outputs.Values[label] = gatherDirective
 	}
 
-	// execute the derived formula
-	rr, err := formulaexec.Exec(ctx, formulaexec.ExecConfig(cfg), wss.Root(),
-		wfapi.FormulaAndContext{
-			Formula: wfapi.FormulaCapsule{Formula: &formula},
-			Context: &wfapi.FormulaContextCapsule{FormulaContext: &formulaCtx},
-		}, plotCfg.FormulaExecConfig)
-	return rr, err
+	return wfapi.FormulaAndContext{
+		Formula: wfapi.FormulaCapsule{Formula: &formula},
+		Context: &wfapi.FormulaContextCapsule{FormulaContext: &formulaCtx},
+	}, nil
+}
+
+// explainPlaceholderPrefix starts the hash (or literal) of every placeholder made by explainPlaceholder.
+const explainPlaceholderPrefix = "unknown-until-"
+
+// isExplainPlaceholder returns true if a formula input is a placeholder made by explainPlaceholder.
+func isExplainPlaceholder(input wfapi.FormulaInputSimple) bool {
+	switch {
+	case input.WareID != nil:
+		return strings.HasPrefix(input.WareID.Hash, explainPlaceholderPrefix)
+	case input.Literal != nil:
+		return strings.HasPrefix(string(*input.Literal), "<"+explainPlaceholderPrefix)
+	default:
+		return false
+	}
+}
+
+// explainPlaceholder stands in for an output of a step when explaining a plot.
+// What a step produces can't be known without running it, so any step which uses its outputs
+// is explained with a placeholder in their place: a WareID with a made-up hash, or a literal.
+func explainPlaceholder(name wfapi.StepName, label wfapi.LocalLabel, gather wfapi.GatherDirective) wfapi.FormulaInput {
+	placeholder := fmt.Sprintf("%s%s-runs-%s", explainPlaceholderPrefix, name, label)
+	if gather.Packtype == nil {
+		lit := wfapi.Literal("<" + placeholder + ">")
+		return wfapi.FormulaInput{FormulaInputSimple: &wfapi.FormulaInputSimple{Literal: &lit}}
+	}
+	return wfapi.FormulaInput{FormulaInputSimple: &wfapi.FormulaInputSimple{
+		WareID: &wfapi.WareID{Packtype: *gather.Packtype, Hash: placeholder},
+	}}
+}
+
+// storeStepRun records the run of a protoformula step in the root workspace,
+// so that the step's log can be found by its module and step path (see `warpforge logs`).
+// Only runs which got as far as running their action (or were memoized) have a log worth finding.
+//
+// Errors:
+//
+//    - warpforge-error-io -- when the step's record can't be written
+//    - warpforge-error-serialization -- when the step's record can't be serialized
+func storeStepRun(wss workspace.WorkspaceSet, module wfapi.ModuleName, stepPath []wfapi.StepName, rr wfapi.RunRecord, runErr error) error {
+	switch serum.Code(runErr) {
+	case "", wfapi.ECodeActionFailed, wfapi.ECodeActionTimeout:
+	default:
+		return nil
+	}
+	if wss.Root() == nil || rr.FormulaID == "" {
+		return nil
+	}
+	return wss.Root().StoreStepRun(rr, module, stepPath...)
+}
+
+// appendStep returns the step path of a step within a plot at the given step path.
+// It never modifies the step path it's given, so it can be shared between the steps of a plot.
+func appendStep(stepPath []wfapi.StepName, name wfapi.StepName) []wfapi.StepName {
+	return append(append([]wfapi.StepName{}, stepPath...), name)
+}
+
+// stepTarget is a protoformula step which execPlot stops at, resolving its formula instead of running it.
+type stepTarget struct {
+	name    wfapi.StepName
+	formula *wfapi.FormulaAndContext // set once the step has been resolved
 }
 
 // Execute a Plot using the provided WorkspaceSet
 // This is an internal function which takes a V1 plot and is called recursively
+// If a target is given, execution stops once it's reached, and the target's formula is resolved.
+// The step path is the names of the subplot steps which this plot is nested in, if any.
 //
 // Errors:
 //
@@ -412,9 +562,14 @@ func execProtoformula(ctx context.Context,
 //    - warpforge-error-catalog-invalid -- when the catalog contains invalid data
 //    - warpforge-error-plot-step-failed -- when execution of a plot step fails
 //    - warpforge-error-workspace-missing -- when home workspace is missing or cannot be opened
-func execPlot(ctx context.Context, cfg ExecConfig, wss workspace.WorkspaceSet, plot wfapi.Plot, pltCfg wfapi.PlotExecConfig) (wfapi.PlotResults, error) {
+//    - warpforge-error-serialization -- when a step's record can't be serialized
+//    - warpforge-error-not-hermetic -- when strict mode is enabled, and the plot is not hermetic
+func execPlot(ctx context.Context, cfg ExecConfig, wss workspace.WorkspaceSet, plot wfapi.Plot, pltCfg wfapi.PlotExecConfig, stepPath []wfapi.StepName, target *stepTarget) (wfapi.PlotResults, error) {
 	ctx, span := tracing.Start(ctx, "execPlot")
 	defer span.End()
+	if err := checkHermetic(wss, plot, pltCfg); err != nil {
+		return wfapi.PlotResults{}, err
+	}
 	pipeCtx := make(pipeMap)
 	results := wfapi.PlotResults{}
 	logger := logging.Ctx(ctx)
@@ -430,18 +585,13 @@ func execPlot(ctx context.Context, cfg ExecConfig, wss workspace.WorkspaceSet, p
 	logger.Info(LOG_TAG, "inputs:")
 	pipeCtx[""] = make(map[wfapi.LocalLabel]wfapi.FormulaInput)
 	inputContext := wfapi.FormulaContext{}
-	inputContext.Warehouses.Values = make(map[wfapi.WareID]wfapi.WarehouseAddr)
+	inputContext.Warehouses.Values = make(map[wfapi.WareID]wfapi.WarehouseSources)
 	for name, input := range plot.Inputs.Values {
-		input, wareAddr, err := plotInputToFormulaInput(ctx, cfg, wss, input, pltCfg, pipeCtx)
+		input, err := plotInputToFormulaInput(ctx, cfg, wss, input, pltCfg, pipeCtx, &inputContext)
 		if err != nil {
 			return results, err
 		}
 		pipeCtx[""][name] = input
-		if wareAddr != nil {
-			// input specifies an address, add it to the context
-			inputContext.Warehouses.Keys = append(inputContext.Warehouses.Keys, *input.Basis().WareID)
-			inputContext.Warehouses.Values[*input.Basis().WareID] = *wareAddr
-		}
 	}
 
 	// determine step execution order
@@ -460,12 +610,31 @@ func execPlot(ctx context.Context, cfg ExecConfig, wss workspace.WorkspaceSet, p
 				color.HiCyanString(string(name)),
 				color.WhiteString("evaluating protoformula"),
 			)
+			if target != nil && name == target.name {
+				frmAndCtx, err := resolveProtoformula(ctx, cfg, wss, *step.Protoformula, inputContext, pltCfg, pipeCtx)
+				if err != nil {
+					return results, wfapi.ErrorPlotStepFailed(name, err)
+				}
+				target.formula = &frmAndCtx
+				return results, nil
+			}
 			rr, err := execProtoformula(ctx, cfg, wss, *step.Protoformula, inputContext, pltCfg, pipeCtx)
+			if !pltCfg.FormulaExecConfig.Explain {
+				if err := storeStepRun(wss, pltCfg.Module, appendStep(stepPath, name), rr, err); err != nil {
+					return results, err
+				}
+			}
 			if err != nil {
 				return results, wfapi.ErrorPlotStepFailed(name, err)
 			}
 			// accumulate the results of the Protoformula our map of Pipes
 			pipeCtx[name] = make(map[wfapi.LocalLabel]wfapi.FormulaInput)
+			if pltCfg.FormulaExecConfig.Explain && rr.Results.Values == nil {
+				// an explained step which isn't memoized has no results, so later steps are explained with placeholders
+				for label, gather := range step.Protoformula.Outputs.Values {
+					pipeCtx[name][label] = explainPlaceholder(name, label, gather)
+				}
+			}
 			for result, input := range rr.Results.Values {
 				logger.Info(LOG_TAG, "(%s) %s %s:%s",
 					color.HiCyanString(string(name)),
@@ -487,7 +656,7 @@ func execPlot(ctx context.Context, cfg ExecConfig, wss workspace.WorkspaceSet, p
 				color.WhiteString("evaluating subplot"),
 			)
 
-			stepResults, err := execPlot(ctx, cfg, wss, *step.Plot, pltCfg)
+			stepResults, err := execPlot(ctx, cfg, wss, *step.Plot, pltCfg, appendStep(stepPath, name), nil)
 			if err != nil {
 				return results, wfapi.ErrorPlotStepFailed(name, err)
 			}
@@ -524,6 +693,10 @@ func execPlot(ctx context.Context, cfg ExecConfig, wss workspace.WorkspaceSet, p
 		if err != nil {
 			return results, err
 		}
+		if result.Basis().WareID == nil {
+			// e.g. a variable gathered from a step: these can be piped to other steps, but aren't wares
+			return results, wfapi.ErrorPlotInvalid(fmt.Sprintf("plot output %q must be a ware", name))
+		}
 		results.Keys = append(results.Keys, name)
 		results.Values[name] = *result.Basis().WareID
 	}
@@ -546,12 +719,65 @@ func execPlot(ctx context.Context, cfg ExecConfig, wss workspace.WorkspaceSet, p
 //    - warpforge-error-io -- when an IO error occurs during conversion
 //    - warpforge-error-plot-invalid -- when the provided plot input is invalid
 //    - warpforge-error-plot-step-failed -- when execution of a plot step fails
+//    - warpforge-error-serialization -- when a step's record can't be serialized
 //    - warpforge-error-workspace-missing -- when home workspace is missing or cannot be opened
+//    - warpforge-error-not-hermetic -- when strict mode is enabled, and the plot is not hermetic
 func Exec(ctx context.Context, cfg ExecConfig, wss workspace.WorkspaceSet, plotCapsule wfapi.PlotCapsule, pltCfg wfapi.PlotExecConfig) (result wfapi.PlotResults, err error) {
 	ctx, span := tracing.StartFn(ctx, "Exec")
 	defer func() { tracing.EndWithStatus(span, err) }()
 	if plotCapsule.Plot == nil {
 		return wfapi.PlotResults{}, wfapi.ErrorPlotInvalid("PlotCapsule does not contain a v1 plot")
 	}
-	return execPlot(ctx, cfg, wss, *plotCapsule.Plot, pltCfg)
+	return execPlot(ctx, cfg, wss, *plotCapsule.Plot, pltCfg, nil, nil)
+}
+
+// ResolveStep resolves the formula of a protoformula step of a plot, without running anything.
+// The steps before it are explained rather than run (see wfapi.FormulaExecConfig.Explain),
+// so the outputs of other steps which it uses must be memoized: otherwise they can't be known.
+//
+// Errors:
+//
+//    - warpforge-error-catalog-invalid -- when the catalog contains invalid data
+//    - warpforge-error-catalog-missing-entry -- when a referenced catalog reference cannot be found
+//    - warpforge-error-catalog-parse -- when parsing of catalog files fails
+//    - warpforge-error-git -- when a git related error occurs during a git ingest
+//    - warpforge-error-io -- when an IO error occurs during conversion
+//    - warpforge-error-plot-invalid -- when the plot has no such step, or the step uses outputs which aren't known yet
+//    - warpforge-error-plot-step-failed -- when resolving a step fails
+//    - warpforge-error-serialization -- when a step's record can't be serialized
+//    - warpforge-error-workspace-missing -- when home workspace is missing or cannot be opened
+//    - warpforge-error-not-hermetic -- when strict mode is enabled, and the plot is not hermetic
+func ResolveStep(ctx context.Context, cfg ExecConfig, wss workspace.WorkspaceSet, plotCapsule wfapi.PlotCapsule, pltCfg wfapi.PlotExecConfig, name wfapi.StepName) (result wfapi.FormulaAndContext, err error) {
+	ctx, span := tracing.StartFn(ctx, "ResolveStep")
+	defer func() { tracing.EndWithStatus(span, err) }()
+	if plotCapsule.Plot == nil {
+		return result, wfapi.ErrorPlotInvalid("PlotCapsule does not contain a v1 plot")
+	}
+	if step, ok := plotCapsule.Plot.Steps.Values[name]; !ok || step.Protoformula == nil {
+		return result, wfapi.ErrorPlotInvalid(fmt.Sprintf("plot has no protoformula step named %q", name))
+	}
+
+	pltCfg.FormulaExecConfig.Explain = true
+	target := &stepTarget{name: name}
+	if _, err := execPlot(ctx, cfg, wss, *plotCapsule.Plot, pltCfg, nil, target); err != nil {
+		return result, err
+	}
+	if target.formula == nil {
+		return result, wfapi.ErrorPlotInvalid(fmt.Sprintf("step %q was never reached", name))
+	}
+
+	formula := target.formula.Formula.Formula
+	for _, port := range formula.Inputs.Keys {
+		input := formula.Inputs.Values[port]
+		placeholder := false
+		for _, layer := range input.Layers() {
+			placeholder = placeholder || isExplainPlaceholder(layer)
+		}
+		if placeholder {
+			return result, wfapi.ErrorPlotInvalid(fmt.Sprintf(
+				"input %q of step %q uses an output of another step which hasn't been run (or isn't memoized); run the plot first",
+				formulaexec.PortString(port), name))
+		}
+	}
+	return *target.formula, nil
 }
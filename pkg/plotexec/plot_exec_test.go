@@ -5,13 +5,16 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/codec/json"
+	"github.com/serum-errors/go-serum"
 	"github.com/warpfork/go-testmark"
 
+	"github.com/warptools/warpforge/pkg/formulaexec"
 	_ "github.com/warptools/warpforge/pkg/testutil"
 	"github.com/warptools/warpforge/pkg/workspace"
 	"github.com/warptools/warpforge/wfapi"
@@ -72,7 +75,15 @@ func TestFormulaExecFixtures(t *testing.T) {
 					config := wfapi.PlotExecConfig{
 						Recursive: true,
 					}
+					if dir.Children["strict"] != nil {
+						config.FormulaExecConfig.Strict = strings.TrimSpace(string(dir.Children["strict"].Hunk.Body)) == "true"
+					}
 					results, err := Exec(ctx, wfCfg, wss, plotCapsule, config)
+					if dir.Children["errorcode"] != nil {
+						code := strings.TrimSpace(string(dir.Children["errorcode"].Hunk.Body))
+						qt.Assert(t, serum.Code(err), qt.Equals, code)
+						return
+					}
 					qt.Assert(t, err, qt.IsNil)
 
 					// print the serialized results, this can be copied into the testmark file
@@ -155,3 +166,92 @@ func TestCycleFails(t *testing.T) {
 	_, err = OrderSteps(ctx, p)
 	qt.Assert(t, err, qt.IsNotNil)
 }
+
+// Explaining a plot runs none of its steps, so steps which use the outputs of others
+// are explained with placeholders, which also become the plot's outputs.
+func TestExplainPlot(t *testing.T) {
+	serial := `{
+	"inputs": {
+		"rootfs": "ware:tar:4z9DCTxoKkStqXQRwtf9nimpfQQ36dbndDsAPCQgECfbXt3edanUrsVKCjE9TkX2v9"
+	},
+	"steps": {
+		"one": {
+			"protoformula": {
+				"inputs": {
+					"/": "pipe::rootfs"
+				},
+				"action": {
+					"script": {
+						"interpreter": "/bin/sh",
+						"contents": ["mkdir /out", "VERSION=1"]
+					}
+				},
+				"outputs": {
+					"out": {
+						"from": "/out",
+						"packtype": "tar"
+					},
+					"version": {
+						"from": "$VERSION"
+					}
+				}
+			}
+		},
+		"two": {
+			"protoformula": {
+				"inputs": {
+					"/": "pipe::rootfs",
+					"/in": "pipe:one:out",
+					"$VERSION": "pipe:one:version"
+				},
+				"action": {
+					"exec": {
+						"command": ["/bin/cp", "-r", "/in", "/out"]
+					}
+				},
+				"outputs": {
+					"out": {
+						"from": "/out",
+						"packtype": "tar"
+					}
+				}
+			}
+		}
+	},
+	"outputs": {
+		"out": "pipe:two:out"
+	}
+}
+`
+	ctx := context.Background()
+	p := wfapi.Plot{}
+	_, err := ipld.Unmarshal([]byte(serial), json.Decode, &p, wfapi.TypeSystem.TypeByName("Plot"))
+	qt.Assert(t, err, qt.IsNil)
+
+	cfg, wss := newTestConfig(t)
+	cfg.Executor = formulaexec.ExecutorFake
+	pltCfg := wfapi.PlotExecConfig{FormulaExecConfig: wfapi.FormulaExecConfig{Explain: true}}
+	results, err := Exec(ctx, cfg, wss, wfapi.PlotCapsule{Plot: &p}, pltCfg)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, results.Values["out"], qt.Equals, wfapi.WareID{Packtype: "tar", Hash: "unknown-until-two-runs-out"})
+}
+
+// Every layer of a plot input is resolved, and the input keeps its subpath.
+func TestPlotInputLayers(t *testing.T) {
+	cfg, wss := newTestConfig(t)
+	base := wfapi.WareID{Packtype: "tar", Hash: "4z9DCTxoKkStqXQRwtf9nimpfQQ36dbndDsAPCQgECfbXt3edanUrsVKCjE9TkX2v9"}
+	addon := wfapi.WareID{Packtype: "tar", Hash: "5vqDjb3DaK5TrXT1jtNkcrqF5XQZqBCnj6BLMEqEaAd9bJR1gbyUtCgHiAcbKGBBqr"}
+	pipeCtx := pipeMap{"build": {"out": wfapi.FormulaInput{FormulaInputSimple: &wfapi.FormulaInputSimple{WareID: &addon}}}}
+
+	plotInput := wfapi.PlotInput{}
+	serial := `{"basis": "ware:tar:` + base.Hash + `", "subpath": "usr", "layers": ["pipe:build:out"]}`
+	_, err := ipld.Unmarshal([]byte(serial), json.Decode, &plotInput, wfapi.TypeSystem.TypeByName("PlotInput"))
+	qt.Assert(t, err, qt.IsNil)
+
+	frmCtx := wfapi.FormulaContext{}
+	input, err := plotInputToFormulaInput(context.Background(), cfg, wss, plotInput, wfapi.PlotExecConfig{}, pipeCtx, &frmCtx)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, input.FormulaInputComplex, qt.IsNotNil)
+	qt.Check(t, *input.FormulaInputComplex.Subpath, qt.Equals, "usr")
+	qt.Check(t, input.Layers(), qt.DeepEquals, []wfapi.FormulaInputSimple{{WareID: &base}, {WareID: &addon}})
+}
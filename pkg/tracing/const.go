@@ -5,12 +5,14 @@ import "go.opentelemetry.io/otel/attribute"
 // Span attribute keys used by warpforge
 const (
 	AttrKeyWarpforgeErrorCode     = "warpforge.error.code"
+	AttrKeyWarpforgeExitCode      = "warpforge.exit.code"
 	AttrKeyWarpforgeFormulaId     = "warpforge.formula.id"
 	AttrKeyWarpforgeIngestHash    = "warpforge.ingest.hash"
 	AttrKeyWarpforgeIngestPath    = "warpforge.ingest.path"
 	AttrKeyWarpforgeIngestRev     = "warpforge.ingest.rev"
 	AttrKeyWarpforgeStepName      = "warpforge.step.name"
 	AttrKeyWarpforgeWareId        = "warpforge.ware.id"
+	AttrKeyWarpforgeWarehouse     = "warpforge.warehouse"
 	AttrKeyWarpforgeExecName      = "warpforge.exec.name"
 	AttrKeyWarpforgeExecOperation = "warpforge.exec.operation"
 )
@@ -363,10 +363,12 @@ func exec(ctx context.Context, pltCfg wfapi.PlotExecConfig, modulePathAbs string
 	defer span.End()
 	result := wfapi.PlotResults{}
 
-	// parse the module, even though it is not currently used
-	if _, err := dab.ModuleFromFile(os.DirFS("/"), modulePathAbs); err != nil {
+	// the module's name scopes the records of its steps
+	module, err := dab.ModuleFromFile(os.DirFS("/"), modulePathAbs)
+	if err != nil {
 		return result, err
 	}
+	pltCfg.Module = module.Name
 
 	moduleDirAbs := filepath.Dir(modulePathAbs)
 	plotPath := filepath.Join(moduleDirAbs, dab.MagicFilename_Plot)
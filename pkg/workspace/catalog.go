@@ -213,7 +213,10 @@ func (cat *Catalog) GetRelease(ref wfapi.CatalogRef) (*wfapi.CatalogRelease, err
 	return &release, nil
 }
 
-// Get a ware from a given catalog.
+// Get a ware from a given catalog, along with the warehouses it can be fetched from.
+// The warehouses are in the order they should be tried:
+// the mirrors for this particular ware (ByWare) first, then those for its module and packtype (ByModule).
+// Note that a ByModule mirror may not actually contain the ware.
 //
 // Errors:
 //
@@ -221,7 +224,7 @@ func (cat *Catalog) GetRelease(ref wfapi.CatalogRef) (*wfapi.CatalogRelease, err
 //     - warpforge-error-catalog-parse -- when ipld parsing of lineage or mirror files fails
 //     - warpforge-error-catalog-invalid -- when catalog files are not found
 //     - warpforge-error-catalog-missing-entry -- when catalog item is not found
-func (cat *Catalog) GetWare(ref wfapi.CatalogRef) (*wfapi.WareID, *wfapi.WarehouseAddr, error) {
+func (cat *Catalog) GetWare(ref wfapi.CatalogRef) (*wfapi.WareID, []wfapi.WarehouseAddr, error) {
 	release, err := cat.GetRelease(ref)
 	if err != nil {
 		return nil, nil, err
@@ -243,36 +246,33 @@ func (cat *Catalog) GetWare(ref wfapi.CatalogRef) (*wfapi.WareID, *wfapi.Warehou
 	if err != nil {
 		return nil, nil, err
 	}
-
-	// resolve which WarehouseAddr will get returned for this particular ware
-	// this is done by first looking for a specific ByWare mirror for the WareID
-	// if none exists, the ByModule address is returned if it exists
-	// TODO: handling of multiple mirrors
 	if mirror == nil {
-		// no mirror exists at all, return nil
+		// no mirror exists at all, which is not an error
 		return &wareId, nil, nil
 	}
 
-	// check ByWare for a matching WareId
-	if mirror.ByWare != nil {
-		if addrs, exists := mirror.ByWare.Values[wareId]; exists {
-			// match found, return it
-			return &wareId, &addrs[0], nil
+	var addrs []wfapi.WarehouseAddr
+	add := func(candidates []wfapi.WarehouseAddr) {
+		for _, c := range candidates {
+			dup := false
+			for _, a := range addrs {
+				if a == c {
+					dup = true
+					break
+				}
+			}
+			if !dup {
+				addrs = append(addrs, c)
+			}
 		}
 	}
-
-	// check if we can return a ByModule WarehouseAddress
-	// note, this address may not actually contain the ware we're looking for
+	if mirror.ByWare != nil {
+		add(mirror.ByWare.Values[wareId])
+	}
 	if mirror.ByModule != nil {
-		if len(mirror.ByModule.Values[ref.ModuleName].Values[wareId.Packtype]) > 0 {
-			return &wareId, &mirror.ByModule.Values[ref.ModuleName].Values[wareId.Packtype][0], nil
-		}
-		return &wareId, nil, nil
+		add(mirror.ByModule.Values[ref.ModuleName].Values[wareId.Packtype])
 	}
-
-	// we have exhausted our options, no mirror exists
-	// this is not an error, but we will return a nil WarehouseAddr
-	return &wareId, nil, nil
+	return &wareId, addrs, nil
 }
 
 // Get a catalog mirror for a given catalog reference.
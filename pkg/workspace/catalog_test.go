@@ -36,12 +36,27 @@ func TestCatalogLookup(t *testing.T) {
 	"catalogmirrors.v1": {
 		"byWare": {
 			"tar:abcd": [
-				"https://example.com/module/module-v1.0-x86_64.tgz"
+				"https://example.com/module/module-v1.0-x86_64.tgz",
+				"https://mirror.example.net/module/module-v1.0-x86_64.tgz"
 			]
+		},
+		"byModule": {
+			"example.com/module": {
+				"tar": [
+					"ca+https://warehouse.example.com/",
+					"https://mirror.example.net/module/module-v1.0-x86_64.tgz"
+				]
+			}
 		}
 	}
 }
 `
+		// ByWare mirrors come first, and duplicates are only listed once
+		wantAddrs := []wfapi.WarehouseAddr{
+			"https://example.com/module/module-v1.0-x86_64.tgz",
+			"https://mirror.example.net/module/module-v1.0-x86_64.tgz",
+			"ca+https://warehouse.example.com/",
+		}
 		replayData := `{
 	"plot.v1": {
 		"inputs": {
@@ -105,12 +120,12 @@ func TestCatalogLookup(t *testing.T) {
 			qt.Assert(t, err, qt.IsNil)
 			qt.Assert(t, ws, qt.IsNotNil)
 
-			wareId, wareAddr, err := ws.GetCatalogWare(ref)
+			wareId, wareAddrs, err := ws.GetCatalogWare(ref)
 			qt.Assert(t, err, qt.IsNil)
 			qt.Assert(t, wareId, qt.IsNotNil)
 			qt.Assert(t, wareId.Hash, qt.Equals, "abcd")
 			qt.Assert(t, wareId.Packtype, qt.Equals, wfapi.Packtype("tar"))
-			qt.Assert(t, *wareAddr, qt.Equals, wfapi.WarehouseAddr("https://example.com/module/module-v1.0-x86_64.tgz"))
+			qt.Assert(t, wareAddrs, qt.DeepEquals, wantAddrs)
 
 		})
 		t.Run("multi-catalog-lookup", func(t *testing.T) {
@@ -154,13 +169,12 @@ func TestCatalogLookup(t *testing.T) {
 					qt.Assert(t, cat.Modules()[0], qt.Equals, wfapi.ModuleName("example.com/module"))
 					qt.Assert(t, cat.Modules()[1], qt.Equals, wfapi.ModuleName("example.com/module-two"))
 
-					wareId, wareAddr, err := ws.GetCatalogWare(ref)
+					wareId, wareAddrs, err := ws.GetCatalogWare(ref)
 					qt.Assert(t, err, qt.IsNil)
 					qt.Assert(t, wareId, qt.IsNotNil)
-					qt.Assert(t, wareAddr, qt.IsNotNil)
 					qt.Assert(t, wareId.Hash, qt.Equals, "abcd")
 					qt.Assert(t, wareId.Packtype, qt.Equals, wfapi.Packtype("tar"))
-					qt.Assert(t, *wareAddr, qt.Equals, wfapi.WarehouseAddr("https://example.com/module/module-v1.0-x86_64.tgz"))
+					qt.Assert(t, wareAddrs, qt.DeepEquals, wantAddrs)
 				}
 			}
 			t.Run("without abs path", check("home/user/"))
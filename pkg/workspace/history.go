@@ -0,0 +1,178 @@
+package workspace
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/json"
+
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// Memos only keep the most recent successful RunRecord of a formula.
+// The history keeps every RunRecord of every formula run in the workspace, so that
+// runs can be audited later -- in particular, to find formulas which don't reproduce.
+// Each formula's history is a file with one RunRecord per line, which is only ever appended to:
+//
+//	.warpforge/history/<formulaID>.jsonl
+
+// Returns the base path which contains run history (e.g., `.../.warpforge/history`)
+func (ws *Workspace) HistoryBasePath() string {
+	return filepath.Join(
+		"/",
+		ws.InternalPath(),
+		"history",
+	)
+}
+
+// Returns the path of the history of runs of a formula within a workspace
+func (ws *Workspace) HistoryPath(fid string) string {
+	return filepath.Join(
+		ws.HistoryBasePath(),
+		strings.Join([]string{fid, "jsonl"}, "."),
+	)
+}
+
+// AppendHistory adds a RunRecord to the end of the history of its formula.
+//
+// Errors:
+//
+//   - warpforge-error-io -- when unable to write the history file
+//   - warpforge-error-serialization -- when unable to serialize the RunRecord
+func (ws *Workspace) AppendHistory(rr wfapi.RunRecord) error {
+	historyPath := ws.HistoryPath(rr.FormulaID)
+	err := os.MkdirAll(filepath.Dir(historyPath), 0755)
+	if err != nil {
+		return wfapi.ErrorIo("failed to create history dir", filepath.Dir(historyPath), err)
+	}
+	serial, err := ipld.Marshal(json.Encode, &rr, wfapi.TypeSystem.TypeByName("RunRecord"))
+	if err != nil {
+		return wfapi.ErrorSerialization("failed to serialize run history", err)
+	}
+	// the serial form must be one line, or the history couldn't be read back
+	serial = bytes.ReplaceAll(serial, []byte("\n"), nil)
+	f, err := os.OpenFile(historyPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return wfapi.ErrorIo("failed to open history file", historyPath, err)
+	}
+	defer f.Close()
+	_, err = f.Write(append(serial, '\n'))
+	if err != nil {
+		return wfapi.ErrorIo("failed to write history file", historyPath, err)
+	}
+	return nil
+}
+
+// LoadHistory returns every recorded run of a formula, oldest first.
+// A formula which has never run in this workspace has no history, and an empty slice is returned.
+//
+// Errors:
+//
+//   - warpforge-error-io -- when unable to read the history file
+//   - warpforge-error-serialization -- when unable to parse the history file
+func (ws *Workspace) LoadHistory(fid string) ([]wfapi.RunRecord, error) {
+	historyPath := ws.HistoryPath(fid)
+	f, err := ws.fsys.Open(historyPath[1:])
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, wfapi.ErrorIo("failed to open history file", historyPath, err)
+	}
+	defer f.Close()
+
+	var runs []wfapi.RunRecord
+	scanner := bufio.NewScanner(f)
+	// RunRecords with many results can make for long lines
+	scanner.Buffer(nil, 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		rr := wfapi.RunRecord{}
+		_, err := ipld.Unmarshal(scanner.Bytes(), json.Decode, &rr, wfapi.TypeSystem.TypeByName("RunRecord"))
+		if err != nil {
+			return nil, wfapi.ErrorSerialization(fmt.Sprintf("failed to deserialize line %d of history file %q", line, historyPath), err)
+		}
+		runs = append(runs, rr)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, wfapi.ErrorIo("failed to read history file", historyPath, err)
+	}
+	return runs, nil
+}
+
+// HistoryFormulaIDs returns the IDs of all formulas with a recorded history, sorted.
+//
+// Errors:
+//
+//   - warpforge-error-io -- when unable to read the history dir
+func (ws *Workspace) HistoryFormulaIDs() ([]string, error) {
+	historyBasePath := ws.HistoryBasePath()
+	entries, err := fs.ReadDir(ws.fsys, historyBasePath[1:])
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, wfapi.ErrorIo("failed to read history dir", historyBasePath, err)
+	}
+	var fids []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".jsonl") {
+			continue
+		}
+		fids = append(fids, strings.TrimSuffix(entry.Name(), ".jsonl"))
+	}
+	sort.Strings(fids)
+	return fids, nil
+}
+
+// ResultsDiffer returns true if the successful runs in a formula's history didn't all produce the same results.
+// Since a formula describes everything that goes into it, this means that the formula isn't reproducible.
+// Failed runs are ignored, since their results are incomplete.
+func ResultsDiffer(runs []wfapi.RunRecord) bool {
+	var first string
+	seen := false
+	for _, rr := range runs {
+		if rr.Exitcode != 0 {
+			continue
+		}
+		results := resultsString(rr)
+		if !seen {
+			first, seen = results, true
+			continue
+		}
+		if results != first {
+			return true
+		}
+	}
+	return false
+}
+
+// resultsString returns the results of a RunRecord in a canonical form, for comparison.
+func resultsString(rr wfapi.RunRecord) string {
+	names := make([]string, 0, len(rr.Results.Values))
+	for name := range rr.Results.Values {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		result := rr.Results.Values[wfapi.OutputName(name)]
+		switch {
+		case result.WareID != nil:
+			fmt.Fprintf(&sb, "%s=ware:%s\n", name, result.WareID)
+		case result.Literal != nil:
+			fmt.Fprintf(&sb, "%s=literal:%s\n", name, *result.Literal)
+		}
+	}
+	return sb.String()
+}
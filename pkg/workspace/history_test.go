@@ -0,0 +1,46 @@
+package workspace
+
+import (
+	"os"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/warptools/warpforge/wfapi"
+)
+
+func TestHistory(t *testing.T) {
+	ws := openWorkspace(os.DirFS("/"), t.TempDir()[1:])
+	run := func(guid string, exitcode int, ware string) wfapi.RunRecord {
+		rr := wfapi.RunRecord{Guid: guid, FormulaID: "fid", Exitcode: exitcode}
+		rr.Results.Values = map[wfapi.OutputName]wfapi.FormulaInputSimple{}
+		if ware != "" {
+			rr.Results.Keys = []wfapi.OutputName{"out"}
+			rr.Results.Values["out"] = wfapi.FormulaInputSimple{WareID: &wfapi.WareID{Packtype: "tar", Hash: ware}}
+		}
+		return rr
+	}
+
+	runs, err := ws.LoadHistory("fid")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, runs, qt.HasLen, 0)
+
+	qt.Assert(t, ws.AppendHistory(run("one", 0, "aaaaaaaa")), qt.IsNil)
+	qt.Assert(t, ws.AppendHistory(run("two", 1, "")), qt.IsNil)
+	qt.Assert(t, ws.AppendHistory(run("three", 0, "aaaaaaaa")), qt.IsNil)
+	runs, err = ws.LoadHistory("fid")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, runs, qt.HasLen, 3)
+	qt.Check(t, []string{runs[0].Guid, runs[1].Guid, runs[2].Guid}, qt.DeepEquals, []string{"one", "two", "three"})
+	qt.Check(t, runs[1].Exitcode, qt.Equals, 1)
+	qt.Check(t, ResultsDiffer(runs), qt.IsFalse)
+
+	qt.Assert(t, ws.AppendHistory(run("four", 0, "bbbbbbbb")), qt.IsNil)
+	runs, err = ws.LoadHistory("fid")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, ResultsDiffer(runs), qt.IsTrue)
+
+	fids, err := ws.HistoryFormulaIDs()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, fids, qt.DeepEquals, []string{"fid"})
+}
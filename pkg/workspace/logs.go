@@ -0,0 +1,230 @@
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/json"
+
+	"github.com/warptools/warpforge/wfapi"
+)
+
+// The output of every formula run is kept in the workspace, so that it can be
+// looked at later -- even if the run has since been memoized.
+// Logs are keyed by formula ID and run GUID, both of which are in the RunRecord
+// (and so in the memo), so a RunRecord is all that's needed to find its log:
+//
+//	.warpforge/logs/formulas/<formulaID>/<guid>.log
+//
+// The RunRecord of a run whose output was kept refers to its log by that path,
+// relative to the logs dir (see RunRecord.Log).
+//
+// Plot steps additionally record the RunRecord of their most recent run, so that
+// logs can be found by step name.  Steps are keyed by the module whose plot they're in,
+// and their step path: the names of any subplots they're nested in, then their own name.
+// The key is path-escaped, so that it's a single file name:
+//
+//	.warpforge/logs/steps/<module>:<subplot>:<stepName>.json
+
+// Returns the base path which contains logs (e.g., `.../.warpforge/logs`)
+func (ws *Workspace) LogBasePath() string {
+	return filepath.Join(
+		"/",
+		ws.InternalPath(),
+		"logs",
+	)
+}
+
+// logDirPath returns the path of the directory which contains the logs for runs of a formula
+func (ws *Workspace) logDirPath(fid string) string {
+	return filepath.Join(
+		ws.LogBasePath(),
+		"formulas",
+		fid,
+	)
+}
+
+// Returns the path of the log for a run of a formula within a workspace
+func (ws *Workspace) LogPath(fid string, guid string) string {
+	return filepath.Join(
+		ws.LogBasePath(),
+		LogRef(fid, guid),
+	)
+}
+
+// LogRef returns the path of the log for a run of a formula, relative to the logs dir.
+// This is what a RunRecord refers to its log by.
+func LogRef(fid string, guid string) string {
+	return filepath.Join(
+		"formulas",
+		fid,
+		strings.Join([]string{guid, "log"}, "."),
+	)
+}
+
+// StepKey returns the key which the runs of a plot step are recorded by:
+// the module, then the step path, separated by colons (e.g. `example.org/foo:subplot:build`).
+func StepKey(module wfapi.ModuleName, stepPath ...wfapi.StepName) string {
+	parts := []string{string(module)}
+	for _, step := range stepPath {
+		parts = append(parts, string(step))
+	}
+	return strings.Join(parts, ":")
+}
+
+// Returns the path of the record of the most recent run of a plot step within a workspace
+func (ws *Workspace) StepRunPath(module wfapi.ModuleName, stepPath ...wfapi.StepName) string {
+	return filepath.Join(
+		ws.LogBasePath(),
+		"steps",
+		strings.Join([]string{url.PathEscape(StepKey(module, stepPath...)), "json"}, "."),
+	)
+}
+
+// CreateLog creates the log file for a run of a formula, ready for writing.
+// The caller is responsible for closing it.
+//
+// Errors:
+//
+//   - warpforge-error-io -- when unable to create the log file
+func (ws *Workspace) CreateLog(fid string, guid string) (*os.File, error) {
+	logPath := ws.LogPath(fid, guid)
+	err := os.MkdirAll(filepath.Dir(logPath), 0755)
+	if err != nil {
+		return nil, wfapi.ErrorIo("failed to create log dir", filepath.Dir(logPath), err)
+	}
+	f, err := os.Create(logPath)
+	if err != nil {
+		return nil, wfapi.ErrorIo("failed to create log file", logPath, err)
+	}
+	return f, nil
+}
+
+// ReadLog returns the log of a run of a formula.
+//
+// Errors:
+//
+//   - warpforge-error-missing -- when there is no log for the run
+//   - warpforge-error-io -- when unable to read the log file
+func (ws *Workspace) ReadLog(fid string, guid string) ([]byte, error) {
+	return ws.ReadLogRef(LogRef(fid, guid))
+}
+
+// ReadLogRef returns the log which a RunRecord refers to (see RunRecord.Log).
+//
+// Errors:
+//
+//   - warpforge-error-missing -- when there is no such log
+//   - warpforge-error-io -- when unable to read the log file
+func (ws *Workspace) ReadLogRef(ref string) ([]byte, error) {
+	logPath := filepath.Join(ws.LogBasePath(), filepath.Clean("/"+ref))
+	content, err := fs.ReadFile(ws.fsys, logPath[1:])
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, wfapi.ErrorFileMissing(logPath)
+	}
+	if err != nil {
+		return nil, wfapi.ErrorIo("failed to read log file", logPath, err)
+	}
+	return content, nil
+}
+
+// LatestLog returns the GUID of the run whose log best describes a formula.
+// If the formula is memoized, that's the run which made the memo.
+// Otherwise, it's the most recently written log, which is usually a failed run.
+//
+// Errors:
+//
+//   - warpforge-error-missing -- when there are no logs for the formula
+//   - warpforge-error-io -- when unable to read the logs or memo
+//   - warpforge-error-serialization -- when unable to parse the memo file
+func (ws *Workspace) LatestLog(fid string) (string, error) {
+	memo, err := ws.LoadMemo(fid)
+	if err != nil {
+		return "", err
+	}
+	if memo != nil {
+		if _, err := fs.Stat(ws.fsys, ws.LogPath(fid, memo.Guid)[1:]); err == nil {
+			return memo.Guid, nil
+		}
+	}
+
+	logDir := ws.logDirPath(fid)
+	entries, err := fs.ReadDir(ws.fsys, logDir[1:])
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", wfapi.ErrorFileMissing(logDir)
+	}
+	if err != nil {
+		return "", wfapi.ErrorIo("failed to read log dir", logDir, err)
+	}
+	var latest fs.FileInfo
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return "", wfapi.ErrorIo("failed to stat log file", filepath.Join(logDir, entry.Name()), err)
+		}
+		if latest == nil || info.ModTime().After(latest.ModTime()) {
+			latest = info
+		}
+	}
+	if latest == nil {
+		return "", wfapi.ErrorFileMissing(logDir)
+	}
+	return strings.TrimSuffix(latest.Name(), ".log"), nil
+}
+
+// StoreStepRun records the RunRecord of the most recent run of a plot step,
+// so that its log can later be found by the step's module and path.
+//
+// Errors:
+//
+//   - warpforge-error-io -- when unable to write the step's record
+//   - warpforge-error-serialization -- when unable to serialize the RunRecord
+func (ws *Workspace) StoreStepRun(rr wfapi.RunRecord, module wfapi.ModuleName, stepPath ...wfapi.StepName) error {
+	recordPath := ws.StepRunPath(module, stepPath...)
+	err := os.MkdirAll(filepath.Dir(recordPath), 0755)
+	if err != nil {
+		return wfapi.ErrorIo("failed to create step record dir", filepath.Dir(recordPath), err)
+	}
+	serial, err := ipld.Marshal(json.Encode, &rr, wfapi.TypeSystem.TypeByName("RunRecord"))
+	if err != nil {
+		return wfapi.ErrorSerialization("failed to serialize step record", err)
+	}
+	err = os.WriteFile(recordPath, serial, 0644)
+	if err != nil {
+		return wfapi.ErrorIo("failed to write step record", recordPath, err)
+	}
+	return nil
+}
+
+// LoadStepRun returns the RunRecord of the most recent run of a plot step,
+// or nil if the step has not been run in this workspace.
+//
+// Errors:
+//
+//   - warpforge-error-io -- when unable to read the step's record
+//   - warpforge-error-serialization -- when unable to parse the step's record
+func (ws *Workspace) LoadStepRun(module wfapi.ModuleName, stepPath ...wfapi.StepName) (*wfapi.RunRecord, error) {
+	recordPath := ws.StepRunPath(module, stepPath...)
+	serial, err := fs.ReadFile(ws.fsys, recordPath[1:])
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, wfapi.ErrorIo("failed to read step record", recordPath, err)
+	}
+	rr := wfapi.RunRecord{}
+	_, err = ipld.Unmarshal(serial, json.Decode, &rr, wfapi.TypeSystem.TypeByName("RunRecord"))
+	if err != nil {
+		return nil, wfapi.ErrorSerialization(fmt.Sprintf("failed to deserialize step record %q", recordPath), err)
+	}
+	return &rr, nil
+}
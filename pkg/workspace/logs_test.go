@@ -0,0 +1,81 @@
+package workspace
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/serum-errors/go-serum"
+
+	"github.com/warptools/warpforge/wfapi"
+)
+
+func TestLogs(t *testing.T) {
+	ws := openWorkspace(os.DirFS("/"), t.TempDir()[1:])
+	writeLog := func(fid, guid, content string, mtime time.Time) {
+		f, err := ws.CreateLog(fid, guid)
+		qt.Assert(t, err, qt.IsNil)
+		_, err = f.WriteString(content)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, f.Close(), qt.IsNil)
+		qt.Assert(t, os.Chtimes(ws.LogPath(fid, guid), mtime, mtime), qt.IsNil)
+	}
+
+	_, err := ws.LatestLog("fid")
+	qt.Assert(t, serum.Code(err), qt.Equals, wfapi.ECodeMissing)
+
+	writeLog("fid", "old", "memoized run\n", time.Unix(1000, 0))
+	writeLog("fid", "new", "failed run\n", time.Unix(2000, 0))
+	t.Run("most recent run", func(t *testing.T) {
+		guid, err := ws.LatestLog("fid")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, guid, qt.Equals, "new")
+		content, err := ws.ReadLog("fid", guid)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, string(content), qt.Equals, "failed run\n")
+	})
+	t.Run("memoized run", func(t *testing.T) {
+		qt.Assert(t, ws.StoreMemo(wfapi.RunRecord{Guid: "old", FormulaID: "fid"}), qt.IsNil)
+		guid, err := ws.LatestLog("fid")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, guid, qt.Equals, "old")
+	})
+	t.Run("log ref", func(t *testing.T) {
+		content, err := ws.ReadLogRef(LogRef("fid", "old"))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, string(content), qt.Equals, "memoized run\n")
+	})
+	t.Run("step run", func(t *testing.T) {
+		rr, err := ws.LoadStepRun("example.org/foo", "build")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, rr, qt.IsNil)
+		qt.Assert(t, ws.StoreStepRun(wfapi.RunRecord{Guid: "new", FormulaID: "fid"}, "example.org/foo", "build"), qt.IsNil)
+		rr, err = ws.LoadStepRun("example.org/foo", "build")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, rr.Guid, qt.Equals, "new")
+		qt.Check(t, rr.FormulaID, qt.Equals, "fid")
+	})
+	t.Run("step runs are scoped", func(t *testing.T) {
+		// steps of the same name, in other modules or subplots, are recorded separately
+		qt.Assert(t, ws.StoreStepRun(wfapi.RunRecord{Guid: "other", FormulaID: "fid2"}, "example.org/bar", "build"), qt.IsNil)
+		qt.Assert(t, ws.StoreStepRun(wfapi.RunRecord{Guid: "sub", FormulaID: "fid3"}, "example.org/foo", "subplot", "build"), qt.IsNil)
+		for _, tc := range []struct {
+			module   wfapi.ModuleName
+			stepPath []wfapi.StepName
+			fid      string
+		}{
+			{"example.org/foo", []wfapi.StepName{"build"}, "fid"},
+			{"example.org/bar", []wfapi.StepName{"build"}, "fid2"},
+			{"example.org/foo", []wfapi.StepName{"subplot", "build"}, "fid3"},
+		} {
+			rr, err := ws.LoadStepRun(tc.module, tc.stepPath...)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, rr.FormulaID, qt.Equals, tc.fid)
+		}
+	})
+	t.Run("missing run", func(t *testing.T) {
+		_, err := ws.ReadLog("fid", "nope")
+		qt.Check(t, serum.Code(err), qt.Equals, wfapi.ECodeMissing)
+	})
+}
@@ -183,6 +183,28 @@ func (ws *Workspace) WarehousePath() string {
 	return filepath.Join(ws.InternalPath(), "warehouse")
 }
 
+// CacheMountBasePath returns the base path which contains cache mounts (e.g., `.../.warpforge/cache-mounts`)
+func (ws *Workspace) CacheMountBasePath() string {
+	return filepath.Join(
+		"/",
+		ws.InternalPath(),
+		"cache-mounts",
+	)
+}
+
+// CacheMountPath returns the path of the cache mount with a given name within a workspace.
+// Cache mount names have the same format as catalog names.
+//
+// Errors:
+//
+//    - warpforge-error-formula-invalid -- when the cache name is invalid
+func (ws *Workspace) CacheMountPath(name wfapi.CacheName) (string, error) {
+	if !reCatalogName.MatchString(string(name)) {
+		return "", wfapi.ErrorFormulaInvalid(fmt.Sprintf("cache name %q must match expression: %s", name, reCatalogName))
+	}
+	return filepath.Join(ws.CacheMountBasePath(), string(name)), nil
+}
+
 // CatalogPath returns the catalog path for catalog with a given name within a workspace.
 // A non-root workspace must use an empty string as the catalog name.
 // A root workspace must use a catalog name that matches the regular expression in the
@@ -277,7 +299,7 @@ func (ws *Workspace) ListCatalogs() ([]string, error) {
 //     - warpforge-error-catalog-parse -- when ipld parsing of lineage or mirror files fails
 //     - warpforge-error-catalog-invalid -- when ipld parsing of lineage or mirror files fails
 //     - warpforge-error-catalog-missing-entry -- when catalog item is missing
-func (ws *Workspace) GetCatalogWare(ref wfapi.CatalogRef) (*wfapi.WareID, *wfapi.WarehouseAddr, error) {
+func (ws *Workspace) GetCatalogWare(ref wfapi.CatalogRef) (*wfapi.WareID, []wfapi.WarehouseAddr, error) {
 	// list the catalogs within the "catalogs" subdirectory
 	cats, err := ws.ListCatalogs()
 	if err != nil {
@@ -295,7 +317,7 @@ func (ws *Workspace) GetCatalogWare(ref wfapi.CatalogRef) (*wfapi.WareID, *wfapi
 				return nil, nil, err
 			}
 		}
-		wareId, wareAddr, err := cat.GetWare(ref)
+		wareId, wareAddrs, err := cat.GetWare(ref)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -303,7 +325,7 @@ func (ws *Workspace) GetCatalogWare(ref wfapi.CatalogRef) (*wfapi.WareID, *wfapi
 			// not found in this catalog, keep trying
 			continue
 		}
-		return wareId, wareAddr, nil
+		return wareId, wareAddrs, nil
 	}
 
 	// nothing found
@@ -446,6 +468,22 @@ func (ws *Workspace) GetMirroringConfig() (wfapi.MirroringConfig, error) {
 	return dab.MirroringConfigFromFile(ws.fsys, filepath.Join(ws.InternalPath(), dab.MagicFilename_MirroringConfig))
 }
 
+// GetPolicy will return the Policy for this workspace,
+// which is read from the .warpforge/config/policy.json file.
+// If there is no such file, the zero Policy is returned, which permits everything.
+//
+// Errors:
+//
+// 	- warpforge-error-io -- for errors reading from fsys.
+// 	- warpforge-error-serialization -- for errors from try to parse the data as a Policy.
+func (ws *Workspace) GetPolicy() (wfapi.Policy, error) {
+	policyPath := filepath.Join(ws.InternalPath(), dab.MagicFilename_Policy)
+	if _, err := fs.Stat(ws.fsys, strings.TrimPrefix(policyPath, "/")); errors.Is(err, fs.ErrNotExist) {
+		return wfapi.Policy{}, nil
+	}
+	return dab.PolicyFromFile(ws.fsys, policyPath)
+}
+
 // StoreMemo will save a run record to the workspace
 //
 // Errors:
@@ -3,12 +3,17 @@ package wfapi
 import (
 	"encoding/json"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/serum-errors/go-serum"
 )
 
 // Error codes are loosely of the form "<application>-error-[subsystem]-<kind>".
 const (
+	ECodeActionFailed           = "warpforge-error-action-failed"            // ECodeActionFailed is used when a formula's action was launched, but exited with a non-zero exit code.
+	ECodeActionTimeout          = "warpforge-error-action-timeout"           // ECodeActionTimeout is used when a formula's action ran for longer than its timeout allows, and was killed.
 	ECodeArgument               = "warpforge-error-invalid-argument"         // ECodeArgument may be used when invalid arguments are provided to the warpforge command line.
 	ECodeAlreadyExists          = "warpforge-error-already-exists"           // ECodeAlreadyExists may be used when _something_ already exists. Specify _what_ when using this code.  Prefer more specific codes.
 	ECodeCatalogInvalid         = "warpforge-error-catalog-invalid"          // ECodeCatalogInvalid may be used when a catalog contains invalid data.
@@ -26,6 +31,7 @@ const (
 	ECodeIo                     = "warpforge-error-io"                       // ECodeIo wraps generic io errors.
 	ECodeMissing                = "warpforge-error-missing"                  // ECodeMissing wraps errors for missing files.
 	ECodeModuleInvalid          = "warpforge-error-module-invalid"           // ECodeModuleInvalid is returned when a module contains invalid data.
+	ECodeNotHermetic            = "warpforge-error-not-hermetic"             // ECodeNotHermetic is returned in strict mode, when a formula or plot uses anything which isn't hermetic.
 	ECodePlotExecution          = "warpforge-error-plot-execution-failed"    // ECodePlotExecution is used to wrap errors around plot execution.
 	ECodePlotInvalid            = "warpforge-error-plot-invalid"             // ECodePlotInvalid is returned when a plot contains invalid data.
 	ECodePlotStepFailed         = "warpforge-error-plot-step-failed"         // ECodePlotStepFailed is returned execution of a Step within a Plot fails.
@@ -109,6 +115,34 @@ func ErrorExecutorFailed(executorEngineName string, cause error) error {
 	)
 }
 
+// ErrorActionFailed is returned when the executor successfully launched
+// the action of a formula, but the action itself exited non-zero.
+// This is distinct from ErrorExecutorFailed, which indicates the executor
+// (rather than the user's command) is what broke.
+//
+// Errors:
+//
+//    - warpforge-error-action-failed --
+func ErrorActionFailed(exitCode int) error {
+	return serum.Error(ECodeActionFailed,
+		serum.WithMessageTemplate("action exited with code {{exitcode}}"),
+		serum.WithDetail("exitcode", strconv.Itoa(exitCode)),
+	)
+}
+
+// ErrorActionTimeout is returned when the action of a formula ran for longer than
+// the timeout in its limits, and so was killed.
+//
+// Errors:
+//
+//    - warpforge-error-action-timeout --
+func ErrorActionTimeout(timeout time.Duration) error {
+	return serum.Error(ECodeActionTimeout,
+		serum.WithMessageTemplate("action did not finish within its timeout of {{timeout}}"),
+		serum.WithDetail("timeout", timeout.String()),
+	)
+}
+
 // DEPRECATED: This constructor just prefixes a degenerate repetition of the error code.
 // Some IO errors do not have paths and the path isn't templated into the error.
 // Generally, relevant paths are expected to be included in the cause.
@@ -410,3 +444,16 @@ func ErrorDataTooNew(context string, cause error) error {
 		serum.WithDetail("context", context),
 	)
 }
+
+// ErrorNotHermetic is returned in strict mode, when a formula or plot uses anything which isn't hermetic.
+// All the violations found are listed, so they can be fixed in one go.
+//
+// Errors:
+//
+//    - warpforge-error-not-hermetic --
+func ErrorNotHermetic(violations []string) error {
+	return serum.Error(ECodeNotHermetic,
+		serum.WithMessageTemplate("refusing to run in strict mode, because it is not hermetic: {{violations}}"),
+		serum.WithDetail("violations", strings.Join(violations, "; ")),
+	)
+}
@@ -1,5 +1,9 @@
 package wfapi
 
+import (
+	"strings"
+)
+
 type FormulaCapsule struct {
 	Formula *Formula
 }
@@ -14,6 +18,7 @@ type Formula struct {
 		Keys   []OutputName
 		Values map[OutputName]GatherDirective
 	}
+	Limits *ResourceLimits
 }
 
 type SandboxPort struct {
@@ -32,6 +37,7 @@ type SandboxVar string
 type FormulaInput struct {
 	FormulaInputSimple  *FormulaInputSimple
 	FormulaInputComplex *FormulaInputComplex
+	LiteralLines        *LiteralLines
 }
 
 func (fi *FormulaInput) Basis() *FormulaInputSimple {
@@ -40,22 +46,56 @@ func (fi *FormulaInput) Basis() *FormulaInputSimple {
 		return fi.FormulaInputSimple
 	case fi.FormulaInputComplex != nil:
 		return &fi.FormulaInputComplex.Basis
+	case fi.LiteralLines != nil:
+		lit := fi.LiteralLines.Literal()
+		return &FormulaInputSimple{Literal: &lit}
 	default:
 		panic("unreachable")
 	}
 }
 
+// Layers returns everything which is mounted for an input, bottom first:
+// its basis, followed by any layers stacked on top of it.
+func (fi *FormulaInput) Layers() []FormulaInputSimple {
+	layers := []FormulaInputSimple{*fi.Basis()}
+	if fi.FormulaInputComplex != nil && fi.FormulaInputComplex.Layers != nil {
+		layers = append(layers, *fi.FormulaInputComplex.Layers...)
+	}
+	return layers
+}
+
 type Literal string
 
+// LiteralLines is a Literal written as a list of lines.
+type LiteralLines []string
+
+// Literal returns the Literal that the lines stand for: each of them, followed by a newline.
+func (ll LiteralLines) Literal() Literal {
+	var b strings.Builder
+	for _, line := range ll {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return Literal(b.String())
+}
+
 type FormulaInputSimple struct {
-	WareID  *WareID
-	Mount   *Mount
-	Literal *Literal
+	WareID    *WareID
+	Mount     *Mount
+	Literal   *Literal
+	Secret    *Secret
+	CacheName *CacheName
 }
 
+// CacheName names a directory which is kept by the root workspace across runs,
+// and mounted read-write into any action which asks for it.
+type CacheName string
+
 type FormulaInputComplex struct {
 	Basis   FormulaInputSimple
-	Filters FilterMap
+	Filters *FilterMap
+	Subpath *string
+	Layers  *[]FormulaInputSimple
 }
 
 type OutputName string
@@ -71,19 +111,103 @@ type Action struct {
 	Echo   *Action_Echo
 	Exec   *Action_Exec
 	Script *Action_Script
+	Noop   *Action_Noop
 }
 
 type Action_Echo struct {
 	// Nothing here.  This is just a debug action, and needs no detailed configuration.
 }
 type Action_Exec struct {
-	Command []string
-	Network *bool
+	Command       []string
+	Cwd           *string
+	Network       *bool
+	NetworkPolicy *NetworkPolicy
+	Userinfo      *ActionUserinfo
+	Deterministic *ActionDeterministic
 }
 type Action_Script struct {
-	Interpreter string
-	Contents    []string
-	Network     *bool
+	Interpreter   string
+	Contents      []string
+	Cwd           *string
+	Network       *bool
+	NetworkPolicy *NetworkPolicy
+	Userinfo      *ActionUserinfo
+	Deterministic *ActionDeterministic
+}
+type Action_Noop struct {
+	// Nothing here.  Outputs are gathered directly from the inputs.
+}
+
+// NetworkEnabled returns true if the action asks for access to the host's network.
+// Only exec and script actions can have network access, and it's off unless asked for.
+func (a Action) NetworkEnabled() bool {
+	switch {
+	case a.Exec != nil:
+		return a.Exec.Network != nil && *a.Exec.Network
+	case a.Script != nil:
+		return a.Script.Network != nil && *a.Script.Network
+	default:
+		return false
+	}
+}
+
+// GetNetworkPolicy returns the network policy of the action, or nil if it has none.
+func (a Action) GetNetworkPolicy() *NetworkPolicy {
+	switch {
+	case a.Exec != nil:
+		return a.Exec.NetworkPolicy
+	case a.Script != nil:
+		return a.Script.NetworkPolicy
+	default:
+		return nil
+	}
+}
+
+// GetDeterministic returns the deterministic sandbox settings of the action,
+// or nil if the action doesn't ask for a deterministic sandbox.
+// Only exec and script actions launch a process, so only they can ask for one.
+func (a Action) GetDeterministic() *ActionDeterministic {
+	switch {
+	case a.Exec != nil:
+		return a.Exec.Deterministic
+	case a.Script != nil:
+		return a.Script.Deterministic
+	default:
+		return nil
+	}
+}
+
+// ActionUserinfo describes the user that an action's process runs as.
+type ActionUserinfo struct {
+	Uid      int
+	Gid      int
+	Username string
+	Homedir  string
+}
+
+// ActionDeterministic asks for an action to run in a sandbox which hides sources of nondeterminism on the host.
+// An absent SourceDateEpoch is derived from the formula's inputs by the executor.
+type ActionDeterministic struct {
+	Hostname        *string
+	SourceDateEpoch *int
+}
+
+func (d ActionDeterministic) GetHostname() string {
+	if d.Hostname == nil {
+		return "warpforge"
+	}
+	return *d.Hostname
+}
+
+// ResourceLimits constrains the resources that the action of a formula may use.
+// A nil field means no limit.
+type ResourceLimits struct {
+	Memory    *int
+	CpuShares *int
+	CpuQuota  *int
+	CpuPeriod *int
+	Pids      *int
+	Timeout   *int // seconds
 }
 
 type FormulaContextCapsule struct {
@@ -93,7 +217,46 @@ type FormulaContextCapsule struct {
 type FormulaContext struct {
 	Warehouses struct {
 		Keys   []WareID
-		Values map[WareID]WarehouseAddr
+		Values map[WareID]WarehouseSources
+	}
+}
+
+// WarehouseAddrs returns the warehouses the context says a ware can be fetched from, in order.
+func (fc *FormulaContext) WarehouseAddrs(wareId WareID) []WarehouseAddr {
+	return fc.Warehouses.Values[wareId].Addrs()
+}
+
+// AddWarehouses adds warehouses to fetch a ware from, after any the context already has for it.
+// Warehouses already listed for the ware are skipped.
+// A ware with a single warehouse keeps the single form, as older formulas have it.
+func (fc *FormulaContext) AddWarehouses(wareId WareID, addrs ...WarehouseAddr) {
+	existing := fc.WarehouseAddrs(wareId)
+	merged := append([]WarehouseAddr{}, existing...)
+	for _, addr := range addrs {
+		dup := false
+		for _, m := range merged {
+			if m == addr {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			merged = append(merged, addr)
+		}
+	}
+	if len(merged) == len(existing) {
+		return
+	}
+	if fc.Warehouses.Values == nil {
+		fc.Warehouses.Values = make(map[WareID]WarehouseSources)
+	}
+	if _, exists := fc.Warehouses.Values[wareId]; !exists {
+		fc.Warehouses.Keys = append(fc.Warehouses.Keys, wareId)
+	}
+	if len(merged) == 1 {
+		fc.Warehouses.Values[wareId] = WarehouseSources{WarehouseAddr: &merged[0]}
+	} else {
+		fc.Warehouses.Values[wareId] = WarehouseSources{WarehouseAddrList: &merged}
 	}
 }
 
@@ -111,9 +274,27 @@ type RunRecord struct {
 		Keys   []OutputName
 		Values map[OutputName]FormulaInputSimple
 	}
+	ScriptEntries *[]ScriptEntryRecord   // 'optional': only present for script actions.
+	NetworkAccess *[]NetworkAccessRecord // 'optional': only present when a NetworkPolicy applied.
+	Log           *string                // 'optional': only present when the action's output was kept.
+}
+
+type NetworkAccessRecord struct {
+	Host    string
+	Allowed bool
+}
+
+type ScriptEntryRecord struct {
+	Exitcode int
+	Duration int64 // milliseconds
 }
 
 type FormulaExecConfig struct {
 	Interactive        bool
 	DisableMemoization bool
+	Executor           string // name of the executor to use; if empty, the executor from the general config is used.
+	DebugOnFailure     bool   // if the action fails, start an interactive shell in its container before giving up.
+	Strict             bool   // refuse to run anything which isn't hermetic: mount, ingest and secret inputs, network access, and interactive stdin.
+	Explain            bool   // resolve the formula and describe how it would be run, without launching any containers or fetching any wares.
+	ExplainSpec        bool   // when explaining, also print the OCI runtime config of the action's container.
 }
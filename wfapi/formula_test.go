@@ -22,6 +22,17 @@ func TestParseFormulaAndContext(t *testing.T) {
 					"filters": {
 						"uid": "10"
 					}
+				},
+				"/etc/npmrc": [
+					"registry=https://registry.example.org/",
+					"always-auth=true"
+				],
+				"/toolchain": {
+					"basis": "ware:tar:qwerasdf",
+					"subpath": "usr/lib",
+					"layers": [
+						"ware:tar:zxcvbnm"
+					]
 				}
 			},
 			"action": {
@@ -48,7 +59,10 @@ func TestParseFormulaAndContext(t *testing.T) {
 		"context.v1": {
 			"warehouses": {
 				"tar:qwerasdf": "ca+file:///somewhere/",
-				"tar:fghjkl": "ca+file:///elsewhere/"
+				"tar:fghjkl": [
+					"ca+file:///elsewhere/",
+					"ca+https://mirror.example.org/warehouse/"
+				]
 			}
 		}
 	}
@@ -63,6 +77,8 @@ func TestParseFormulaAndContext(t *testing.T) {
 		{SandboxPath: func() *SandboxPath { v := SandboxPath("mount/path"); return &v }()},
 		{SandboxVar: func() *SandboxVar { v := SandboxVar("ENV_VAR"); return &v }()},
 		{SandboxPath: func() *SandboxPath { v := SandboxPath("more/mounts"); return &v }()},
+		{SandboxPath: func() *SandboxPath { v := SandboxPath("etc/npmrc"); return &v }()},
+		{SandboxPath: func() *SandboxPath { v := SandboxPath("toolchain"); return &v }()},
 	}
 	inputs := frmAndCtx.Formula.Formula.Inputs
 	qt.Assert(t, inputs.Keys, qt.DeepEquals, ports)
@@ -78,12 +94,36 @@ func TestParseFormulaAndContext(t *testing.T) {
 	qt.Check(t, inputs.Values[inputs.Keys[2]], qt.DeepEquals,
 		FormulaInput{FormulaInputComplex: &FormulaInputComplex{
 			Basis: FormulaInputSimple{WareID: &WareID{"tar", "fghjkl"}},
-			Filters: FilterMap{
+			Filters: &FilterMap{
 				Keys:   []string{"uid"},
 				Values: map[string]string{"uid": "10"},
 			},
 		}},
 	)
+	// a literal can also be written as a list of lines
+	qt.Check(t, inputs.Values[inputs.Keys[3]], qt.DeepEquals,
+		FormulaInput{LiteralLines: &LiteralLines{"registry=https://registry.example.org/", "always-auth=true"}},
+	)
+	npmrc := inputs.Values[inputs.Keys[3]]
+	qt.Check(t, *npmrc.Basis().Literal, qt.Equals, Literal("registry=https://registry.example.org/\nalways-auth=true\n"))
+
+	// part of a ware can be mounted, with more wares stacked on top of it
+	toolchain := inputs.Values[inputs.Keys[4]]
+	qt.Check(t, toolchain.FormulaInputComplex.Filters, qt.IsNil)
+	qt.Check(t, *toolchain.FormulaInputComplex.Subpath, qt.Equals, "usr/lib")
+	qt.Check(t, toolchain.Layers(), qt.DeepEquals, []FormulaInputSimple{
+		{WareID: &WareID{"tar", "qwerasdf"}},
+		{WareID: &WareID{"tar", "zxcvbnm"}},
+	})
+
+	// a ware can have one warehouse, or a list of them, to be tried in order
+	frmCtx := frmAndCtx.Context.FormulaContext
+	qt.Check(t, frmCtx.WarehouseAddrs(WareID{"tar", "qwerasdf"}), qt.DeepEquals,
+		[]WarehouseAddr{"ca+file:///somewhere/"},
+	)
+	qt.Check(t, frmCtx.WarehouseAddrs(WareID{"tar", "fghjkl"}), qt.DeepEquals,
+		[]WarehouseAddr{"ca+file:///elsewhere/", "ca+https://mirror.example.org/warehouse/"},
+	)
 
 	reserial, err := ipld.Marshal(json.Encode, &frmAndCtx, TypeSystem.TypeByName("FormulaAndContext"))
 	qt.Assert(t, err, qt.IsNil)
@@ -91,6 +131,22 @@ func TestParseFormulaAndContext(t *testing.T) {
 	qt.Assert(t, string(reserial), qt.CmpEquals(), serial)
 }
 
+func TestAddWarehouses(t *testing.T) {
+	wareId := WareID{"tar", "qwerasdf"}
+	frmCtx := FormulaContext{}
+	qt.Check(t, frmCtx.WarehouseAddrs(wareId), qt.HasLen, 0)
+
+	frmCtx.AddWarehouses(wareId, "ca+file:///somewhere/")
+	qt.Check(t, frmCtx.Warehouses.Keys, qt.DeepEquals, []WareID{wareId})
+	qt.Check(t, frmCtx.Warehouses.Values[wareId].WarehouseAddr, qt.IsNotNil)
+
+	frmCtx.AddWarehouses(wareId, "ca+https://mirror.example.org/", "ca+file:///somewhere/")
+	qt.Check(t, frmCtx.Warehouses.Keys, qt.DeepEquals, []WareID{wareId})
+	qt.Check(t, frmCtx.WarehouseAddrs(wareId), qt.DeepEquals,
+		[]WarehouseAddr{"ca+file:///somewhere/", "ca+https://mirror.example.org/"},
+	)
+}
+
 func TestParseRunRecord(t *testing.T) {
 	serial := `{
 	"guid": "asefjghr-34jg5nhj-12jfb5jk",
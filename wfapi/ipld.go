@@ -6,7 +6,9 @@ import (
 	"embed"
 
 	_ "github.com/ipld/go-ipld-prime/codec/json" // side-effecting import; registers a codec.
+	"github.com/ipld/go-ipld-prime/datamodel"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/ipld/go-ipld-prime/schema"
 	schemadmt "github.com/ipld/go-ipld-prime/schema/dmt"
 	schemadsl "github.com/ipld/go-ipld-prime/schema/dsl"
@@ -45,3 +47,63 @@ var SchemaDMT, TypeSystem = func() (*schemadmt.Schema, *schema.TypeSystem) {
 	}
 	return schemaDmt, ts
 }()
+
+// HashableNode copies the representation of a typed node into a plain node, for computing its CID.
+//
+// This works around bindnode (as of go-ipld-prime v0.20) getting the length of a struct wrong
+// when it's a member of a kinded union (such as FormulaInputComplex) and has absent optional fields,
+// which dag-cbor refuses to encode.
+// The copy has exactly the same data, so the CID is no different than it would have been.
+func HashableNode(n schema.TypedNode) (datamodel.Node, error) {
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := copyDeep(n.Representation(), nb); err != nil {
+		return nil, err
+	}
+	return nb.Build(), nil
+}
+
+// copyDeep copies a node all the way down.
+// (datamodel.Copy can't be used, since basicnode keeps the nodes it's given for any recursive children,
+// rather than copying them.)
+func copyDeep(n datamodel.Node, na datamodel.NodeAssembler) error {
+	switch n.Kind() {
+	case datamodel.Kind_Map:
+		ma, err := na.BeginMap(0)
+		if err != nil {
+			return err
+		}
+		for itr := n.MapIterator(); !itr.Done(); {
+			k, v, err := itr.Next()
+			if err != nil {
+				return err
+			}
+			if v.IsAbsent() {
+				continue
+			}
+			if err := ma.AssembleKey().AssignNode(k); err != nil {
+				return err
+			}
+			if err := copyDeep(v, ma.AssembleValue()); err != nil {
+				return err
+			}
+		}
+		return ma.Finish()
+	case datamodel.Kind_List:
+		la, err := na.BeginList(0)
+		if err != nil {
+			return err
+		}
+		for itr := n.ListIterator(); !itr.Done(); {
+			_, v, err := itr.Next()
+			if err != nil {
+				return err
+			}
+			if err := copyDeep(v, la.AssembleValue()); err != nil {
+				return err
+			}
+		}
+		return la.Finish()
+	default:
+		return datamodel.Copy(n, na)
+	}
+}
@@ -34,6 +34,11 @@ func (plot *Plot) Cid() PlotCID {
 	// convert parsed release to node
 	nRelease := bindnode.Wrap(plot, TypeSystem.TypeByName("Plot"))
 
+	hashable, errRaw := HashableNode(nRelease.(schema.TypedNode))
+	if errRaw != nil {
+		panic(fmt.Sprintf("Fatal IPLD Error: failed to copy Plot for hashing: %s", errRaw))
+	}
+
 	// compute CID of parsed release data
 	lsys := cidlink.DefaultLinkSystem()
 	lnk, errRaw := lsys.ComputeLink(cidlink.LinkPrototype{Prefix: cid.Prefix{
@@ -41,7 +46,7 @@ func (plot *Plot) Cid() PlotCID {
 		Codec:    0x71, // 0x71 means "dag-cbor" -- See the multicodecs table: https://github.com/multiformats/multicodec/
 		MhType:   0x20, // 0x20 means "sha2-384" -- See the multicodecs table: https://github.com/multiformats/multicodec/
 		MhLength: 48,   // sha2-384 hash has a 48-byte sum.
-	}}, nRelease.(schema.TypedNode).Representation())
+	}}, hashable)
 
 	if errRaw != nil {
 		// panic! this should never fail unless IPLD is broken
@@ -77,6 +82,7 @@ type LocalLabel string
 type PlotInput struct {
 	PlotInputSimple  *PlotInputSimple
 	PlotInputComplex *PlotInputComplex
+	LiteralLines     *LiteralLines
 }
 
 func (pi *PlotInput) Basis() *PlotInputSimple {
@@ -85,23 +91,40 @@ func (pi *PlotInput) Basis() *PlotInputSimple {
 		return pi.PlotInputSimple
 	case pi.PlotInputComplex != nil:
 		return &pi.PlotInputComplex.Basis
+	case pi.LiteralLines != nil:
+		lit := pi.LiteralLines.Literal()
+		return &PlotInputSimple{Literal: &lit}
 	default:
 		panic("unreachable")
 	}
 }
 
+// Layers returns everything which is mounted for an input, bottom first:
+// its basis, followed by any layers stacked on top of it.
+func (pi *PlotInput) Layers() []PlotInputSimple {
+	layers := []PlotInputSimple{*pi.Basis()}
+	if pi.PlotInputComplex != nil && pi.PlotInputComplex.Layers != nil {
+		layers = append(layers, *pi.PlotInputComplex.Layers...)
+	}
+	return layers
+}
+
 type PlotInputSimple struct {
 	WareID     *WareID
 	Mount      *Mount
 	Literal    *Literal
+	Secret     *Secret
 	Pipe       *Pipe
 	CatalogRef *CatalogRef
 	Ingest     *Ingest
+	CacheName  *CacheName
 }
 
 type PlotInputComplex struct {
 	Basis   PlotInputSimple
-	Filters FilterMap
+	Filters *FilterMap
+	Subpath *string
+	Layers  *[]PlotInputSimple
 }
 
 type PlotOutput struct {
@@ -128,6 +151,7 @@ type Protoformula struct {
 		Keys   []LocalLabel
 		Values map[LocalLabel]GatherDirective
 	}
+	Limits *ResourceLimits
 }
 
 type ModuleName string
@@ -152,4 +176,8 @@ type PlotResults struct {
 type PlotExecConfig struct {
 	Recursive         bool
 	FormulaExecConfig FormulaExecConfig
+
+	// Module is the name of the module whose plot is run.
+	// The runs of the plot's steps are recorded under it (see `warpforge logs`).
+	Module ModuleName
 }
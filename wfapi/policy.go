@@ -0,0 +1,54 @@
+package wfapi
+
+import (
+	"net"
+	"strings"
+)
+
+type PolicyCapsule struct {
+	Policy *Policy
+}
+
+type Policy struct {
+	Strict  *bool
+	Network *NetworkPolicy
+}
+
+func (p Policy) GetStrict() bool {
+	if p.Strict == nil {
+		return false
+	}
+	return *p.Strict
+}
+
+// NetworkPolicy limits what an action with network access may connect to.
+// See the schema for what the entries of Allow may look like.
+type NetworkPolicy struct {
+	Allow []string
+}
+
+// Allows returns true if the policy allows connecting to the given host and port.
+// Host names are compared case-insensitively.
+func (p NetworkPolicy) Allows(host string, port string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, entry := range p.Allow {
+		allowHost, allowPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			allowHost, allowPort = h, p
+		}
+		allowHost = strings.ToLower(strings.TrimSuffix(allowHost, "."))
+		if allowPort != "" && allowPort != port {
+			continue
+		}
+		if strings.HasPrefix(allowHost, "*.") {
+			if strings.HasSuffix(host, allowHost[1:]) {
+				return true
+			}
+			continue
+		}
+		if allowHost == host {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package wfapi
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/json"
+)
+
+func TestParsePolicy(t *testing.T) {
+	serial := `{
+	"policy.v1": {
+		"strict": false,
+		"network": {
+			"allow": ["example.org:443", "*.example.net"]
+		}
+	}
+}`
+	p := PolicyCapsule{}
+	_, err := ipld.Unmarshal([]byte(serial), json.Decode, &p, TypeSystem.TypeByName("PolicyCapsule"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, p.Policy, qt.IsNotNil)
+	qt.Assert(t, p.Policy.Network, qt.IsNotNil)
+	qt.Check(t, p.Policy.Network.Allow, qt.DeepEquals, []string{"example.org:443", "*.example.net"})
+}
+
+func TestNetworkPolicyAllows(t *testing.T) {
+	policy := NetworkPolicy{Allow: []string{"example.org:443", "*.example.net", "Proxy.Example.com", "[::1]:8080"}}
+	for _, tc := range []struct {
+		host    string
+		port    string
+		allowed bool
+	}{
+		{"example.org", "443", true},
+		{"EXAMPLE.org.", "443", true},
+		{"example.org", "80", false},
+		{"sub.example.org", "443", false},
+		{"a.b.example.net", "22", true},
+		{"example.net", "443", false},
+		{"badexample.net", "443", false},
+		{"proxy.example.com", "3128", true},
+		{"::1", "8080", true},
+		{"::1", "80", false},
+		{"example.com", "443", false},
+	} {
+		qt.Check(t, policy.Allows(tc.host, tc.port), qt.Equals, tc.allowed, qt.Commentf("%s:%s", tc.host, tc.port))
+	}
+	qt.Check(t, NetworkPolicy{}.Allows("example.org", "443"), qt.IsFalse)
+}
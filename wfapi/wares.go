@@ -23,6 +23,24 @@ type Packtype string
 // WarehouseAddr is typically parsed as roughly a URL, but we don't deal with that at the API type level.
 type WarehouseAddr string
 
+// WarehouseSources is where a ware can be fetched from: one warehouse, or a list of them, to be tried in order.
+type WarehouseSources struct {
+	WarehouseAddr     *WarehouseAddr
+	WarehouseAddrList *[]WarehouseAddr
+}
+
+// Addrs returns the warehouses, in the order they should be tried.
+func (ws WarehouseSources) Addrs() []WarehouseAddr {
+	switch {
+	case ws.WarehouseAddr != nil:
+		return []WarehouseAddr{*ws.WarehouseAddr}
+	case ws.WarehouseAddrList != nil:
+		return *ws.WarehouseAddrList
+	default:
+		return nil
+	}
+}
+
 // Placeholder type.  May need better definition.
 type FilterMap struct {
 	Keys   []string
@@ -42,6 +60,22 @@ const (
 	MountMode_Overlay   MountMode = "overlay"
 )
 
+type Secret struct {
+	Source SecretSource
+	Ref    string
+}
+
+func (s Secret) String() string {
+	return fmt.Sprintf("%s:%s", s.Source, s.Ref)
+}
+
+type SecretSource string
+
+const (
+	SecretSource_Env  SecretSource = "env"
+	SecretSource_File SecretSource = "file"
+)
+
 type Ingest struct {
 	GitIngest *GitIngest
 }